@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/michaelwasher/kube-strace/pkg/analyze"
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+// Reports available from the analyze command
+const (
+	reportSummary  = "summary"
+	reportFiles    = "files"
+	reportNetwork  = "network"
+	reportOutliers = "outliers"
+)
+
+var analyzeReports = []string{reportSummary, reportFiles, reportNetwork, reportOutliers}
+
+// Optional CLI flags
+type AnalyzeCommandArgs struct {
+	formatStr *string
+	report    *string
+	slowest   *int
+	resolve   *bool
+	identity  *string
+
+	outlierFactor *float64
+}
+type AnalyzeCommand struct {
+	AnalyzeCommandArgs
+
+	// Converted flags
+	format analyze.Format
+
+	// Command state
+	collectionPath string
+
+	// GenericCLI Options
+	kubeConfigFlags *genericclioptions.ConfigFlags
+}
+
+func intptr(val int) *int {
+	return &val
+}
+
+func float64ptr(val float64) *float64 {
+	return &val
+}
+
+func NewAnalyzeDefaults() AnalyzeCommandArgs {
+	return AnalyzeCommandArgs{
+		formatStr: stringptr(string(analyze.FormatTable)),
+		report:    stringptr(reportSummary),
+		slowest:   intptr(10),
+		resolve:   boolptr(false),
+		identity:  stringptr(""),
+
+		outlierFactor: float64ptr(analyze.DefaultOutlierOptions().Factor),
+	}
+}
+
+func NewAnalyzeCommand(kubeConfigFlags *genericclioptions.ConfigFlags) *cobra.Command {
+	aCmd := &AnalyzeCommand{AnalyzeCommandArgs: NewAnalyzeDefaults(), kubeConfigFlags: kubeConfigFlags}
+
+	cmd := &cobra.Command{
+		Use:   "analyze <collection>",
+		Short: "Summarise the system calls in a strace collection",
+		Long: `Analyse a strace collection directory, a bundled collection archive or a single strace log.
+
+The summary report lists per-container system call counts, latency percentiles, errors and the slowest calls.
+The files report lists every path each container accessed with the outcome and the volume it belongs to.
+The network report lists every peer each container connected to, accepted from or exchanged datagrams with.
+The outliers report compares the replicas of each workload and flags those that behave differently from the rest.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := aCmd.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := aCmd.Validate(); err != nil {
+				return err
+			}
+			return aCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(aCmd.formatStr, "format", *aCmd.formatStr, fmt.Sprintf("The format of the report. Available options are %v.", analyze.Formats))
+	flags.StringVar(aCmd.report, "report", *aCmd.report, fmt.Sprintf("The report to produce. Available options are %v.", analyzeReports))
+	flags.IntVar(aCmd.slowest, "slowest", *aCmd.slowest, "The number of slowest individual calls to report per container.")
+	flags.Float64Var(aCmd.outlierFactor, "outlier-factor", *aCmd.outlierFactor, "How many times larger or smaller than the other replicas a metric must be for a replica to be flagged as an outlier.")
+	flags.BoolVar(aCmd.resolve, "resolve", *aCmd.resolve, "Resolve network peers to the Services and Pods in the current cluster.")
+	flags.StringVar(aCmd.identity, "identity", *aCmd.identity, identityUsage)
+
+	return cmd
+}
+
+func (aCmd *AnalyzeCommand) Complete(cmd *cobra.Command, args []string) error {
+	aCmd.collectionPath = args[0]
+	return nil
+}
+
+func (aCmd *AnalyzeCommand) Validate() error {
+	var err error
+
+	aCmd.format, err = analyze.ParseFormat(*aCmd.formatStr)
+	if err != nil {
+		return err
+	}
+	validReport := false
+	for _, report := range analyzeReports {
+		validReport = validReport || report == *aCmd.report
+	}
+	if !validReport {
+		return fmt.Errorf("unsupported report %q. available options are %v", *aCmd.report, analyzeReports)
+	}
+	if *aCmd.slowest < 0 {
+		return fmt.Errorf("the number of slowest calls cannot be negative")
+	}
+	if *aCmd.outlierFactor <= 1 {
+		return fmt.Errorf("the outlier factor must be greater than 1")
+	}
+	return nil
+}
+
+func (aCmd *AnalyzeCommand) Run() error {
+	collection, err := loadCollection(aCmd.collectionPath, *aCmd.identity)
+	if err != nil {
+		return err
+	}
+	defer collection.Close()
+
+	switch *aCmd.report {
+	case reportOutliers:
+		options := analyze.DefaultOutlierOptions()
+		options.Factor = *aCmd.outlierFactor
+		report, err := analyze.DetectOutliers(collection, options)
+		if err != nil {
+			return err
+		}
+		return analyze.WriteOutlierReport(os.Stdout, report, aCmd.format)
+	case reportNetwork:
+		report, err := analyze.ReportNetwork(collection, aCmd.resolver(collection))
+		if err != nil {
+			return err
+		}
+		return analyze.WriteNetworkReport(os.Stdout, report, aCmd.format)
+	case reportFiles:
+		report, err := analyze.ReportFiles(collection)
+		if err != nil {
+			return err
+		}
+		return analyze.WriteFileReport(os.Stdout, report, aCmd.format)
+	}
+
+	summary, err := analyze.Summarise(collection, analyze.Options{Slowest: *aCmd.slowest})
+	if err != nil {
+		return err
+	}
+	return analyze.WriteSummary(os.Stdout, summary, aCmd.format)
+}
+
+// resolver indexes the cluster for the network report. Resolution is best effort, so failures leave peers
+// unresolved rather than failing the report.
+func (aCmd *AnalyzeCommand) resolver(collection *analyze.Collection) analyze.Resolver {
+	if !*aCmd.resolve {
+		return nil
+	}
+
+	_, clientset, err := newClientset(aCmd.kubeConfigFlags)
+	if err != nil {
+		log.Warnf("unable to connect to the cluster, network peers will not be resolved. %v", err)
+		return nil
+	}
+
+	namespaces := []string{}
+	if collection.Manifest != nil {
+		seen := map[string]bool{}
+		for _, pod := range collection.Manifest.Pods {
+			if !seen[pod.Namespace] {
+				seen[pod.Namespace] = true
+				namespaces = append(namespaces, pod.Namespace)
+			}
+		}
+	}
+	resolver, err := kstrace.NewClusterResolver(clientset, namespaces)
+	if err != nil {
+		log.Warnf("unable to index the cluster, network peers will not be resolved. %v", err)
+		return nil
+	}
+	return resolver
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+// Optional CLI flags, matching those of the trace command
+type CanICommandArgs struct {
+	tracerNamespace      *string
+	tracerServiceAccount *string
+	imagePullSecrets     *[]string
+	redact               *bool
+}
+type CanICommand struct {
+	CanICommandArgs
+
+	// GenericCLI Options
+	kubeConfigFlags *genericclioptions.ConfigFlags
+}
+
+func NewCanIDefaults() CanICommandArgs {
+	return CanICommandArgs{
+		tracerNamespace:      stringptr(""),
+		tracerServiceAccount: stringptr(""),
+		imagePullSecrets:     &[]string{},
+		redact:               boolptr(false),
+	}
+}
+
+func NewCanICommand(kubeConfigFlags *genericclioptions.ConfigFlags) *cobra.Command {
+	cCmd := &CanICommand{CanICommandArgs: NewCanIDefaults(), kubeConfigFlags: kubeConfigFlags}
+
+	cmd := &cobra.Command{
+		Use:   "can-i",
+		Short: "Check the current user has the permissions kstrace needs",
+		Long: `Check that the current user can create the tracer namespace and pods, exec into them and list the pods
+being traced, without changing anything in the cluster.
+
+Pass the same --namespace, --tracer-namespace, --tracer-service-account, --image-pull-secret and --redact flags
+as when tracing. The same check runs before every trace.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return cCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(cCmd.tracerNamespace, "tracer-namespace", *cCmd.tracerNamespace, "The existing namespace the tracer pods would run in. By default a temporary namespace is checked.")
+	flags.StringVar(cCmd.tracerServiceAccount, "tracer-service-account", *cCmd.tracerServiceAccount, "The ServiceAccount the tracer pods would run as. By default one is created on OpenShift.")
+	flags.StringArrayVar(cCmd.imagePullSecrets, "image-pull-secret", *cCmd.imagePullSecrets, "A Secret that would be copied into the temporary tracer namespace to pull the trace image.")
+	flags.BoolVar(cCmd.redact, "redact", *cCmd.redact, "Check the Secrets of the traced pods can be read for redaction.")
+
+	return cmd
+}
+
+func (cCmd *CanICommand) Run() error {
+	_, clientset, err := newClientset(cCmd.kubeConfigFlags)
+	if err != nil {
+		return err
+	}
+	namespace, _, err := cCmd.kubeConfigFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	grantSCC := false
+	if *cCmd.tracerServiceAccount == "" {
+		grantSCC, err = kstrace.IsOpenShift(clientset)
+		if err != nil {
+			log.Debugf("Unable to detect whether the cluster is OpenShift. %v", err)
+		}
+	}
+
+	checks, err := kstrace.CheckAccess(context.TODO(), clientset, kstrace.RequiredPermissions(kstrace.AccessOptions{
+		TracerNamespace:    *cCmd.tracerNamespace,
+		TargetNamespaces:   []string{namespace},
+		ImagePullSecrets:   *cCmd.imagePullSecrets,
+		SecretNamespace:    namespace,
+		Redact:             *cCmd.redact,
+		GrantPrivilegedSCC: grantSCC,
+	}))
+	if err != nil {
+		return err
+	}
+	if err := writeAccessTable(os.Stdout, checks); err != nil {
+		return err
+	}
+
+	if missing := kstrace.MissingPermissions(checks); len(missing) > 0 {
+		return fmt.Errorf("missing %d of %d permissions required by kstrace", len(missing), len(checks))
+	}
+	log.Info("All permissions required by kstrace are granted")
+	return nil
+}
+
+// writeAccessTable prints the outcome of each permission check
+func writeAccessTable(output io.Writer, checks []kstrace.AccessCheck) error {
+	tabs := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tabs, "VERB\tRESOURCE\tNAMESPACE\tALLOWED\tREASON")
+	for _, check := range checks {
+		allowed := "no"
+		if check.Allowed {
+			allowed = "yes"
+		}
+		fmt.Fprintf(tabs, "%s\t%s\t%s\t%s\t%s\n", check.Verb, check.ResourceName(), check.Scope(), allowed, check.Reason)
+	}
+	return tabs.Flush()
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+// Optional CLI flags
+type CleanupCommandArgs struct {
+	olderThanStr *string
+	createdBy    *string
+	yes          *bool
+}
+type CleanupCommand struct {
+	CleanupCommandArgs
+
+	// Converted flags
+	olderThan time.Duration
+
+	// GenericCLI Options
+	kubeConfigFlags *genericclioptions.ConfigFlags
+}
+
+func NewCleanupDefaults() CleanupCommandArgs {
+	return CleanupCommandArgs{
+		olderThanStr: stringptr("0"),
+		createdBy:    stringptr(""),
+		yes:          boolptr(false),
+	}
+}
+
+func NewCleanupCommand(kubeConfigFlags *genericclioptions.ConfigFlags) *cobra.Command {
+	cCmd := &CleanupCommand{CleanupCommandArgs: NewCleanupDefaults(), kubeConfigFlags: kubeConfigFlags}
+
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Find and delete tracer namespaces and pods left behind by kstrace",
+		Long: `List the namespaces and privileged tracer pods created by kstrace across the cluster and delete them once confirmed.
+The tracer serviceaccounts and rolebindings created on OpenShift are also listed.
+
+Resources are normally removed when a trace finishes, but are left behind if kstrace is killed or loses its
+connection to the cluster. Traces that are still running are also listed, so use --older-than to skip them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cCmd.Validate(); err != nil {
+				return err
+			}
+			return cCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(cCmd.olderThanStr, "older-than", *cCmd.olderThanStr, "Only include resources created at least this long ago, e.g. 1h. Set to 0 to include everything.")
+	flags.StringVar(cCmd.createdBy, "created-by", *cCmd.createdBy, "Only include resources created by this user, as shown in the CREATED BY column.")
+	flags.BoolVarP(cCmd.yes, "yes", "y", *cCmd.yes, "Delete the resources without asking for confirmation.")
+
+	return cmd
+}
+
+func (cCmd *CleanupCommand) Validate() error {
+	var err error
+
+	cCmd.olderThan, err = time.ParseDuration(*cCmd.olderThanStr)
+	if err != nil {
+		return fmt.Errorf("invalid --older-than %q. %v", *cCmd.olderThanStr, err)
+	}
+	if cCmd.olderThan < 0 {
+		return fmt.Errorf("invalid --older-than %q. the duration cannot be negative", *cCmd.olderThanStr)
+	}
+	if !*cCmd.yes && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("confirmation requires an interactive terminal. use --yes to delete without confirmation")
+	}
+	return nil
+}
+
+func (cCmd *CleanupCommand) Run() error {
+	_, clientset, err := newClientset(cCmd.kubeConfigFlags)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	leftovers, err := kstrace.FindLeftovers(context.TODO(), clientset, kstrace.LeftoverFilter{
+		OlderThan: cCmd.olderThan,
+		CreatedBy: *cCmd.createdBy,
+		Now:       now,
+	})
+	if err != nil {
+		return err
+	}
+	if len(leftovers) == 0 {
+		log.Info("No kstrace resources found")
+		return nil
+	}
+
+	tabs := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tabs, "KIND\tNAMESPACE\tNAME\tCREATED BY\tAGE")
+	for _, leftover := range leftovers {
+		fmt.Fprintf(tabs, "%s\t%s\t%s\t%s\t%s\n", leftover.Kind, leftover.Namespace, leftover.Name, leftover.CreatedBy, duration.HumanDuration(now.Sub(leftover.Created)))
+	}
+	if err := tabs.Flush(); err != nil {
+		return err
+	}
+
+	if !*cCmd.yes && !confirm(fmt.Sprintf("Delete these %d resources?", len(leftovers))) {
+		log.Info("Nothing was deleted")
+		return nil
+	}
+
+	failures := 0
+	for _, leftover := range leftovers {
+		if err := kstrace.DeleteLeftover(clientset, leftover); err != nil {
+			log.Errorf("%v", err)
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("unable to delete %d of %d resources", failures, len(leftovers))
+	}
+	return nil
+}
+
+// confirm asks a yes or no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -3,24 +3,34 @@ package cmd
 // TODO Clean up imports
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"os/user"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/michaelwasher/kube-strace/pkg/analyze"
+	"github.com/michaelwasher/kube-strace/pkg/dashboard"
 	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/otlp"
+	"github.com/michaelwasher/kube-strace/pkg/redact"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/rand"
 
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/cli-runtime/pkg/resource"
@@ -32,6 +42,8 @@ import (
 
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"k8s.io/kubectl/pkg/scheme"
+
+	"golang.org/x/term"
 )
 
 // Version Information
@@ -44,21 +56,66 @@ var Version struct {
 type KubeStraceCommandArgs struct {
 	traceImage      *string
 	traceTimeoutStr *string
+	startTimeoutStr *string
 	socketPath      *string
+	tracerNamespace *string
 	logLevelStr     *string
 	logFile         *string
 	outputDirectory *string
+	colour          *string
+	archive         *string
+	bundle          *bool
+	maxSizeStr      *string
+	maxTotalSizeStr *string
+	stackTraces     *bool
+	ui              *bool
+	detectOutliers  *bool
+	redact          *bool
+	redactPatterns  *[]string
+	encryptTo       *string
+
+	// Tracer Pod scheduling
+	tracerTolerations    *[]string
+	tracerRequests       *string
+	tracerLimits         *string
+	tracerPriorityClass  *string
+	tracerLabels         *[]string
+	tracerAnnotations    *[]string
+	tracerServiceAccount *string
+
+	// Tracer image
+	imagePullSecrets *[]string
+	imagePullPolicy  *string
+	registryMirror   *string
+
+	otlpEndpoint     *string
+	otlpHeaderStrs   *[]string
+	otlpThresholdStr *string
+	otlpExportErrors *bool
 }
 type KubeStraceCommand struct {
 	KubeStraceCommandArgs
 
 	// Converted flags
-	logLevel     log.Level
-	traceTimeout time.Duration
+	logLevel      log.Level
+	traceTimeout  time.Duration
+	startTimeout  time.Duration
+	otlpThreshold time.Duration
+	otlpHeaders   map[string]string
+	compression   kstrace.Compression
+	maxSize       int64
+	maxTotalSize  int64
+	redactor      *redact.Redactor
+	recipients    *kstrace.Recipients
+
+	// tracerPod holds the scheduling options of the tracer Pods
+	tracerPod kstrace.PrivilegedPodOptions
 
 	// Command state
 	tracers    []kstrace.Tracer
 	targetPods []corev1.Pod
+	// namespace is the namespace of the current context
+	namespace string
 
 	// GenericCLI Options
 	clientset       *kubernetes.Clientset
@@ -71,19 +128,62 @@ func stringptr(val string) *string {
 	return &val
 }
 
+func boolptr(val bool) *bool {
+	return &val
+}
+
 func NewKubeStraceDefaults() KubeStraceCommandArgs {
 	kCmd := KubeStraceCommandArgs{
-		traceImage:      stringptr("quay.io/mwasher/crictl:0.0.1"),
+		traceImage:      stringptr(defaultTraceImage()),
 		socketPath:      stringptr("/run/crio/crio.sock"),
+		tracerNamespace: stringptr(""),
 		logLevelStr:     stringptr("info"),
 		traceTimeoutStr: stringptr("0"),
+		startTimeoutStr: stringptr(kstrace.DefaultStartTimeout.String()),
 		outputDirectory: stringptr("strace-collection"),
 		logFile:         stringptr("-"),
+		colour:          stringptr("auto"),
+		archive:         stringptr(""),
+		bundle:          boolptr(false),
+		maxSizeStr:      stringptr("0"),
+		maxTotalSizeStr: stringptr("0"),
+		stackTraces:     boolptr(false),
+		ui:              boolptr(false),
+		detectOutliers:  boolptr(false),
+		redact:          boolptr(false),
+		redactPatterns:  &[]string{},
+		encryptTo:       stringptr(""),
+
+		tracerTolerations:    &[]string{},
+		tracerRequests:       stringptr(""),
+		tracerLimits:         stringptr(""),
+		tracerPriorityClass:  stringptr(""),
+		tracerLabels:         &[]string{},
+		tracerAnnotations:    &[]string{},
+		tracerServiceAccount: stringptr(""),
+
+		imagePullSecrets: &[]string{},
+		imagePullPolicy:  stringptr(""),
+		registryMirror:   stringptr(""),
+
+		otlpEndpoint:     stringptr(""),
+		otlpHeaderStrs:   &[]string{},
+		otlpThresholdStr: stringptr("100ms"),
+		otlpExportErrors: boolptr(true),
 	}
+	return kCmd
+}
+
+// defaultImageRepository publishes a tracer image for each release
+const defaultImageRepository = "quay.io/mwasher/crictl"
+
+// defaultTraceImage is the tracer image matching this build
+func defaultTraceImage() string {
+	tag := "0.0.1"
 	if Version.Tag != "" {
-		kCmd.traceImage = stringptr("quay.io/mwasher/crictl:" + Version.Tag)
+		tag = Version.Tag
 	}
-	return kCmd
+	return defaultImageRepository + ":" + tag
 }
 
 func NewKubeStraceCommand(appName string) *cobra.Command {
@@ -94,6 +194,10 @@ func NewKubeStraceCommand(appName string) *cobra.Command {
 		Short:   "Run strace against Pods and Deployments in Kubernetes",
 		Version: Version.Tag,
 		Long:    fmt.Sprintf(`%q is a CLI tool that provides the ability to easily perform debugging of system-calls and process state for applications running on the Kubernetes platform.`, appName),
+		Args:    cobra.ArbitraryArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return kCmd.configureLogging()
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 
 			if err := kCmd.Complete(cmd, args); err != nil {
@@ -102,6 +206,8 @@ func NewKubeStraceCommand(appName string) *cobra.Command {
 			if err := kCmd.Validate(); err != nil {
 				return err
 			}
+			// Failures while tracing are not caused by the arguments
+			cmd.SilenceUsage = true
 			if err := kCmd.Run(); err != nil {
 				return err
 			}
@@ -111,7 +217,7 @@ func NewKubeStraceCommand(appName string) *cobra.Command {
 	}
 	cmd.SetVersionTemplate(appName + `{{printf "version %s" .Version}}`)
 
-	// Add Kubectl / Kubernetes CLI flags
+	// Add Kubectl / Kubernetes CLI flags, shared with the subcommands
 	flags := cmd.PersistentFlags()
 
 	stringptr := func(val string) *string {
@@ -125,11 +231,45 @@ func NewKubeStraceCommand(appName string) *cobra.Command {
 
 	kCmd.kubeConfigFlags.AddFlags(flags)
 
-	// Add command-specific flags
-	flags.StringVar(kCmd.socketPath, "socket-path", *kCmd.socketPath, "The location of the CRI socket on the host machine.")
-	flags.StringVar(kCmd.traceImage, "image", *kCmd.traceImage, "The trace image for use when performing the strace.")
-	flags.StringVar(kCmd.traceTimeoutStr, "trace-timeout", *kCmd.traceTimeoutStr, "The length of time to capture the strace output for.")
-	flags.StringVarP(kCmd.outputDirectory, "output", "o", *kCmd.outputDirectory, "The directory to store the strace data.")
+	// Add command-specific flags. These only apply to tracing, so the subcommands do not inherit them
+	traceFlags := cmd.Flags()
+	traceFlags.StringVar(kCmd.socketPath, "socket-path", *kCmd.socketPath, "The location of the CRI socket on the host machine.")
+	traceFlags.StringVar(kCmd.traceImage, "image", *kCmd.traceImage, "The trace image for use when performing the strace.")
+	traceFlags.StringVar(kCmd.tracerNamespace, "tracer-namespace", *kCmd.tracerNamespace, "An existing namespace to run the tracer pods in. Only the pods kstrace creates are removed from it. By default a temporary namespace is created and deleted.")
+	traceFlags.StringVar(kCmd.traceTimeoutStr, "trace-timeout", *kCmd.traceTimeoutStr, "The length of time to capture the strace output for.")
+	traceFlags.StringVar(kCmd.startTimeoutStr, "tracer-start-timeout", *kCmd.startTimeoutStr, "The length of time to wait for each tracer pod to start, including pulling its image.")
+	traceFlags.StringVarP(kCmd.outputDirectory, "output", "o", *kCmd.outputDirectory, "The directory to store the strace data. Use \"-\" to stream to standard out.")
+	traceFlags.StringVar(kCmd.archive, "archive", *kCmd.archive, fmt.Sprintf("Compress the output of each container as it is collected. Available options are %v.", kstrace.Compressions))
+	traceFlags.StringVar(kCmd.maxSizeStr, "max-size", *kCmd.maxSizeStr, "The maximum size of each container's output file before it is rotated into a new segment, e.g. 100Mi. Set to 0 to disable.")
+	traceFlags.StringVar(kCmd.maxTotalSizeStr, "max-total-size", *kCmd.maxTotalSizeStr, "The maximum size of the whole collection, e.g. 1Gi. All traces are stopped once it is reached. Set to 0 to disable.")
+	traceFlags.BoolVar(kCmd.stackTraces, "stack-traces", *kCmd.stackTraces, "Record the user space stack of every system call with strace -k. This adds significant overhead to the traced process.")
+	traceFlags.BoolVar(kCmd.detectOutliers, "detect-outliers", *kCmd.detectOutliers, "Compare the replicas of each workload once the collection is complete and report those that behave differently.")
+	traceFlags.BoolVar(kCmd.bundle, "bundle", *kCmd.bundle, "Bundle the output directory into a single archive once the collection is complete.")
+	traceFlags.BoolVar(kCmd.ui, "ui", *kCmd.ui, "Show a live dashboard of the system calls across every traced container instead of the raw output.")
+	traceFlags.BoolVar(kCmd.redact, "redact", *kCmd.redact, "Remove bearer tokens, AWS keys, JWTs and the values of environment variables sourced from Secrets before the output is stored or streamed. Run \"redact\" to redact an existing collection.")
+	traceFlags.StringArrayVar(kCmd.redactPatterns, "redact-pattern", *kCmd.redactPatterns, "A regular expression to redact in addition to the built-in detectors. Only the first capture group is replaced when present. Implies --redact.")
+	traceFlags.StringVar(kCmd.encryptTo, "encrypt-to", *kCmd.encryptTo, "A file of age recipients or a PGP public key to encrypt the output of each container to as it is written.")
+	traceFlags.StringVar(kCmd.colour, "color", *kCmd.colour, "Colourise the pod/container prefix when streaming to standard out. Available options are [auto, always, never].")
+
+	// Tracer Pod scheduling
+	traceFlags.StringArrayVar(kCmd.tracerTolerations, "tracer-toleration", *kCmd.tracerTolerations, "A taint for the tracer pods to tolerate in the form key[=value][:effect]. By default every taint is tolerated.")
+	traceFlags.StringVar(kCmd.tracerRequests, "tracer-requests", *kCmd.tracerRequests, "The resource requests of the tracer pods, e.g. cpu=100m,memory=64Mi.")
+	traceFlags.StringVar(kCmd.tracerLimits, "tracer-limits", *kCmd.tracerLimits, "The resource limits of the tracer pods, e.g. cpu=1,memory=256Mi.")
+	traceFlags.StringVar(kCmd.tracerPriorityClass, "tracer-priority-class", *kCmd.tracerPriorityClass, "The PriorityClass of the tracer pods.")
+	traceFlags.StringArrayVar(kCmd.tracerLabels, "tracer-label", *kCmd.tracerLabels, "A label to add to the tracer pods in the form key=value.")
+	traceFlags.StringArrayVar(kCmd.tracerAnnotations, "tracer-annotation", *kCmd.tracerAnnotations, "An annotation to add to the tracer pods in the form key=value.")
+	traceFlags.StringVar(kCmd.tracerServiceAccount, "tracer-service-account", *kCmd.tracerServiceAccount, "The ServiceAccount to run the tracer pods as.")
+
+	// Tracer image
+	traceFlags.StringArrayVar(kCmd.imagePullSecrets, "image-pull-secret", *kCmd.imagePullSecrets, "A Secret used to pull the trace image. It is copied from the current namespace into the temporary tracer namespace, and must already exist when --tracer-namespace is used.")
+	traceFlags.StringVar(kCmd.imagePullPolicy, "image-pull-policy", *kCmd.imagePullPolicy, fmt.Sprintf("The pull policy of the trace image. Available options are %v.", kstrace.PullPolicies))
+	traceFlags.StringVar(kCmd.registryMirror, "registry-mirror", *kCmd.registryMirror, "A registry, optionally with a path prefix, that mirrors the default trace image. Run \"image\" to find the image to mirror.")
+
+	// OpenTelemetry
+	traceFlags.StringVar(kCmd.otlpEndpoint, "otlp-endpoint", *kCmd.otlpEndpoint, "The OTLP/HTTP collector URL to export slow or failing system calls to as spans.")
+	traceFlags.StringArrayVar(kCmd.otlpHeaderStrs, "otlp-header", *kCmd.otlpHeaderStrs, "A header to send with each export request in the form key=value, e.g. for collector authentication.")
+	traceFlags.StringVar(kCmd.otlpThresholdStr, "otlp-latency-threshold", *kCmd.otlpThresholdStr, "The minimum duration of a system call for it to be exported as a span. Set to 0 to disable.")
+	traceFlags.BoolVar(kCmd.otlpExportErrors, "otlp-export-errors", *kCmd.otlpExportErrors, "Export every system call that returns an error as a span.")
 
 	// Logging
 	logLevels := func() []string {
@@ -148,29 +288,62 @@ func NewKubeStraceCommand(appName string) *cobra.Command {
 	flags.StringVar(kCmd.logLevelStr, "log-level", *kCmd.logLevelStr, fmt.Sprintf("The verbosity level of the output from the command. Available options are [%s].", strings.Join(logLevels, ", ")))
 	flags.StringVar(kCmd.logFile, "log-file", *kCmd.logFile, "Send logs to a file.")
 
+	// Offline tooling
+	cmd.AddCommand(NewAnalyzeCommand(kCmd.kubeConfigFlags))
+	cmd.AddCommand(NewDiffCommand())
+	cmd.AddCommand(NewFlameGraphCommand())
+	cmd.AddCommand(NewReportCommand())
+	cmd.AddCommand(NewRedactCommand(kCmd.kubeConfigFlags))
+	cmd.AddCommand(NewDecryptCommand())
+	cmd.AddCommand(NewCleanupCommand(kCmd.kubeConfigFlags))
+	cmd.AddCommand(NewImageCommand())
+	cmd.AddCommand(NewCanICommand(kCmd.kubeConfigFlags))
+
 	return cmd
 }
 
 func (kCmd *KubeStraceCommand) configureClientset() error {
-	// Setup REST APi conf
 	var err error
 
-	kCmd.restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kCmd.kubeConfigFlags.ToRawKubeConfigLoader().ConfigAccess().GetDefaultFilename()},
+	kCmd.restConfig, kCmd.clientset, err = newClientset(kCmd.kubeConfigFlags)
+	return err
+}
+
+// newClientset builds the REST configuration and clientset from the Kubernetes CLI flags
+func newClientset(kubeConfigFlags *genericclioptions.ConfigFlags) (*rest.Config, *kubernetes.Clientset, error) {
+	// Setup REST APi conf
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeConfigFlags.ToRawKubeConfigLoader().ConfigAccess().GetDefaultFilename()},
 		&clientcmd.ConfigOverrides{},
 	).ClientConfig()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	kCmd.clientset, err = kubernetes.NewForConfig(kCmd.restConfig)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return nil
+	return restConfig, clientset, nil
 }
-func (kCmd *KubeStraceCommand) Complete(cmd *cobra.Command, args []string) error {
+
+// currentUser names the kubeconfig user of the current context, falling back to the local user. It is recorded
+// on the resources kstrace creates so that leftovers can be attributed.
+func currentUser(kubeConfigFlags *genericclioptions.ConfigFlags) string {
+	rawConfig, err := kubeConfigFlags.ToRawKubeConfigLoader().RawConfig()
+	if err == nil {
+		if kubeContext, ok := rawConfig.Contexts[rawConfig.CurrentContext]; ok && kubeContext.AuthInfo != "" {
+			return kubeContext.AuthInfo
+		}
+	}
+	if localUser, err := user.Current(); err == nil {
+		return localUser.Username
+	}
+	return ""
+}
+
+func (kCmd *KubeStraceCommand) configureLogging() error {
 	var err error
 
 	// Configure log-file
@@ -192,6 +365,12 @@ func (kCmd *KubeStraceCommand) Complete(cmd *cobra.Command, args []string) error
 	log.SetLevel(kCmd.logLevel)
 	log.Infof("Running with loglevel: %v", kCmd.logLevel)
 
+	return nil
+}
+
+func (kCmd *KubeStraceCommand) Complete(cmd *cobra.Command, args []string) error {
+	var err error
+
 	// Configure ClientSet and API communication
 	err = kCmd.configureClientset()
 	if err != nil {
@@ -205,6 +384,15 @@ func (kCmd *KubeStraceCommand) Complete(cmd *cobra.Command, args []string) error
 	if err != nil {
 		return err
 	}
+	kCmd.namespace = namespace
+
+	// The mirror replaces the registry of the default image only
+	if *kCmd.registryMirror != "" {
+		if cmd.Flags().Changed("image") {
+			return fmt.Errorf("--registry-mirror cannot be used with --image. include the mirror in the image instead")
+		}
+		*kCmd.traceImage = kstrace.MirrorImage(*kCmd.traceImage, *kCmd.registryMirror)
+	}
 
 	kCmd.builder = f.NewBuilder().
 		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
@@ -226,11 +414,8 @@ func (kCmd *KubeStraceCommand) Validate() error {
 	if len(kCmd.targetPods) < 1 {
 		return fmt.Errorf("a target pod must be defined")
 	}
-	if len(kCmd.targetPods) > 1 && *kCmd.outputDirectory == "-" {
-		return fmt.Errorf("cannot have multiple target pods but output to standard out")
-	}
-	if len(kCmd.targetPods[0].Spec.Containers) > 1 && *kCmd.outputDirectory == "-" {
-		return fmt.Errorf("there are multiple containers defined for pod %q. unable to output to standard out for pods with multiple containers", kCmd.targetPods[0].Name)
+	if *kCmd.colour != "auto" && *kCmd.colour != "always" && *kCmd.colour != "never" {
+		return fmt.Errorf("invalid color option %q. available options are [auto, always, never]", *kCmd.colour)
 	}
 
 	kCmd.traceTimeout, err = time.ParseDuration(*kCmd.traceTimeoutStr)
@@ -238,34 +423,276 @@ func (kCmd *KubeStraceCommand) Validate() error {
 		return err
 	}
 
+	kCmd.startTimeout, err = time.ParseDuration(*kCmd.startTimeoutStr)
+	if err != nil {
+		return err
+	}
+	if kCmd.startTimeout <= 0 {
+		return fmt.Errorf("--tracer-start-timeout must be greater than zero")
+	}
+
+	if err := kCmd.parseTracerScheduling(); err != nil {
+		return err
+	}
+
+	kCmd.otlpThreshold, err = time.ParseDuration(*kCmd.otlpThresholdStr)
+	if err != nil {
+		return err
+	}
+	kCmd.otlpHeaders, err = kstrace.ParseKeyValues(*kCmd.otlpHeaderStrs)
+	if err != nil {
+		return fmt.Errorf("invalid --otlp-header. %v", err)
+	}
+
+	kCmd.compression, err = kstrace.ParseCompression(*kCmd.archive)
+	if err != nil {
+		return err
+	}
+	if (kCmd.compression != kstrace.CompressionNone || *kCmd.bundle) && *kCmd.outputDirectory == "-" {
+		return fmt.Errorf("cannot archive the collection when outputting to standard out")
+	}
+
+	kCmd.maxSize, err = parseSize(*kCmd.maxSizeStr)
+	if err != nil {
+		return err
+	}
+	kCmd.maxTotalSize, err = parseSize(*kCmd.maxTotalSizeStr)
+	if err != nil {
+		return err
+	}
+	if (kCmd.maxSize != 0 || kCmd.maxTotalSize != 0) && *kCmd.outputDirectory == "-" {
+		return fmt.Errorf("cannot limit the output size when outputting to standard out")
+	}
+	if *kCmd.detectOutliers && *kCmd.outputDirectory == "-" {
+		return fmt.Errorf("cannot detect outliers when outputting to standard out")
+	}
+	if *kCmd.redact || len(*kCmd.redactPatterns) > 0 {
+		kCmd.redactor, err = redact.New(*kCmd.redactPatterns)
+		if err != nil {
+			return err
+		}
+	}
+	if *kCmd.encryptTo != "" {
+		if *kCmd.outputDirectory == "-" {
+			return fmt.Errorf("cannot encrypt the output when outputting to standard out")
+		}
+		if *kCmd.detectOutliers {
+			return fmt.Errorf("cannot detect outliers in an encrypted collection. run \"analyze --report=outliers --identity\" once it is complete")
+		}
+		kCmd.recipients, err = kstrace.LoadRecipients(*kCmd.encryptTo)
+		if err != nil {
+			return err
+		}
+	}
+	if *kCmd.ui && (!term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd()))) {
+		return fmt.Errorf("the dashboard requires an interactive terminal")
+	}
+
 	return nil
 }
 
-func (kCmd *KubeStraceCommand) Run() error {
-	var err error
-	ctx := context.TODO()
+func (kCmd *KubeStraceCommand) Run() (runErr error) {
+	// Interrupting the command cancels the context, so the traces stop and their resources are removed.
+	// This is deferred first so that a second interrupt can still exit while cleaning up.
+	ctx, closeSignalHandler := setupSignalHandler()
+	defer closeSignalHandler()
+
+	manifest := kstrace.Manifest{
+		Kind:      kstrace.ManifestKindCollection,
+		Version:   Version.Tag,
+		Commit:    Version.Commit,
+		Command:   os.Args,
+		StartTime: time.Now(),
+	}
+	if kCmd.recipients != nil {
+		manifest.Encryption = kCmd.recipients.Encryption()
+	}
+	if kCmd.redactor != nil {
+		manifest.Redaction = append(kCmd.redactor.Detectors(), redact.DetectorSecret)
+	}
 
-	// Pass signal handler pointer to list of functions
-	cleanupFunctions := []func(){}
-	closeSignalHandler := kCmd.setupSignalHandler(&cleanupFunctions)
+	// OpenShift admits privileged Pods through a SecurityContextConstraint granted to their ServiceAccount
+	grantSCC := false
+	if kCmd.tracerPod.ServiceAccountName == "" {
+		openShift, err := kstrace.IsOpenShift(kCmd.clientset)
+		if err != nil {
+			log.Debugf("Unable to detect whether the cluster is OpenShift. %v", err)
+		}
+		grantSCC = openShift
+	}
 
-	// Create namespace for Strace Pods
-	ns, err := kstrace.CreateNamespace(ctx, kCmd.clientset)
+	// Missing permissions are reported before anything is created
+	if err := kCmd.preflight(ctx, grantSCC); err != nil {
+		return err
+	}
 
-	defer kstrace.CleanupNamespace(ctx, kCmd.clientset, ns.Name)
-	cleanupFunctions = append(cleanupFunctions, func() {
-		kstrace.CleanupNamespace(ctx, kCmd.clientset, ns.Name)
-	})
+	// Run the tracer Pods in an existing namespace, or a temporary one that is deleted afterwards
+	traceNamespace := *kCmd.tracerNamespace
+	runID := rand.String(8)
+	createdBy := currentUser(kCmd.kubeConfigFlags)
+	cleanupTracers := func() error {
+		return kstrace.CleanupTracerPods(kCmd.clientset, traceNamespace, runID)
+	}
+	if traceNamespace == "" {
+		ns, err := kstrace.CreateNamespace(ctx, kCmd.clientset, createdBy)
+		if apierrors.IsForbidden(err) {
+			return fmt.Errorf("unable to create a namespace for the tracer pods. use --tracer-namespace to run them in an existing namespace. %v", err)
+		}
+		if err != nil {
+			return err
+		}
+		traceNamespace = ns.Name
+		cleanupTracers = func() error {
+			return kstrace.CleanupNamespace(kCmd.clientset, ns.Name)
+		}
+	}
+	defer func() {
+		if err := cleanupTracers(); err != nil {
+			log.Errorf("%v", err)
+		}
+	}()
 
-	if err != nil {
-		return err
+	// The temporary namespace needs its own copy of the image pull Secrets
+	if *kCmd.tracerNamespace == "" {
+		if err := kstrace.CopySecrets(ctx, kCmd.clientset, kCmd.namespace, traceNamespace, *kCmd.imagePullSecrets); err != nil {
+			return err
+		}
+	}
+
+	// The ServiceAccount created on OpenShift is removed once the tracer Pods are gone
+	serviceAccount := kCmd.tracerPod.ServiceAccountName
+	if grantSCC {
+		var revoke func() error
+		var err error
+		serviceAccount, revoke, err = kstrace.GrantPrivilegedSCC(ctx, kCmd.clientset, traceNamespace, runID, createdBy)
+		if err != nil {
+			log.Warnf("The tracer pods may be rejected by SecurityContextConstraints. %v", err)
+		} else {
+			defer func() {
+				if err := revoke(); err != nil {
+					log.Errorf("%v", err)
+				}
+			}()
+		}
+	}
+
+	// Export slow and failing system calls
+	observers := []kstrace.LineObserver{}
+	if *kCmd.otlpEndpoint != "" {
+		exporter, err := otlp.NewExporter(otlp.Config{
+			Endpoint:         *kCmd.otlpEndpoint,
+			Headers:          kCmd.otlpHeaders,
+			LatencyThreshold: kCmd.otlpThreshold,
+			ExportErrors:     *kCmd.otlpExportErrors,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// The remaining spans are exported even when the trace was interrupted
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			// Missing spans fail the run unless it has already failed
+			if err := exporter.Shutdown(shutdownCtx); err != nil && runErr == nil {
+				runErr = err
+			} else if err != nil {
+				log.Errorf("%v", err)
+			}
+		}()
+		observers = append(observers, exporter)
+	}
+
+	// Tag each line with its source when streaming more than one container to standard out
+	containerCount := 0
+	for _, targetPod := range kCmd.targetPods {
+		containerCount += len(targetPod.Status.ContainerStatuses)
+	}
+	colour := *kCmd.colour == "always" || (*kCmd.colour == "auto" && term.IsTerminal(int(os.Stdout.Fd())))
+	stdout := kstrace.NewMultiplexer(os.Stdout, containerCount > 1, colour)
+
+	// The dashboard replaces the raw output on standard out
+	closeUI := func() {}
+	if *kCmd.ui {
+		ui := dashboard.New(dashboard.Options{
+			Input:  os.Stdin,
+			Output: os.Stdout,
+			OnQuit: func() {
+				// Quitting behaves like an interrupt so the traces are cleaned up
+				if process, err := os.FindProcess(os.Getpid()); err == nil {
+					process.Signal(os.Interrupt)
+				}
+			},
+			OnClose: func() {
+				// Cleanup is still logged once the dashboard has gone
+				if *kCmd.logFile == "-" {
+					log.SetOutput(os.Stderr)
+				}
+			},
+		})
+		if *kCmd.logFile == "-" {
+			log.SetOutput(ui)
+		}
+		if err := ui.Start(); err != nil {
+			ui.Close()
+			return err
+		}
+		closeUI = ui.Close
+		defer closeUI()
+
+		observers = append(observers, ui)
+		stdout = kstrace.NewMultiplexer(io.Discard, false, false)
+	}
+
+	// Store the output of each container, compressing and then encrypting it as it arrives
+	var sink kstrace.Sink = &kstrace.FileSink{Directory: *kCmd.outputDirectory}
+	if kCmd.recipients != nil {
+		sink = &kstrace.EncryptedSink{Sink: sink, Recipients: kCmd.recipients}
+	}
+	if kCmd.compression != kstrace.CompressionNone {
+		sink = &kstrace.CompressedSink{Sink: sink, Compression: kCmd.compression}
+	}
+	if kCmd.maxSize != 0 {
+		sink = &kstrace.RotatingSink{Sink: sink, MaxSize: kCmd.maxSize}
+	}
+	var budget *kstrace.Budget
+	if kCmd.maxTotalSize != 0 {
+		budget = kstrace.NewBudget(kCmd.maxTotalSize)
 	}
 
+	// Targets on the same node share a tracer Pod
+	template := kCmd.tracerPod
+	template.Namespace = traceNamespace
+	template.ContainerName = "container-name"
+	template.Image = *kCmd.traceImage
+	template.SocketPath = *kCmd.socketPath
+	template.RunID = runID
+	template.CreatedBy = createdBy
+	template.TraceTimeout = kCmd.traceTimeout
+	template.StartTimeout = kCmd.startTimeout
+	template.ServiceAccountName = serviceAccount
+	pool := kstrace.NewTracerPool(kCmd.clientset, template)
+
 	// Create Tracers for each Pod
 	var tracerWaitGroup sync.WaitGroup
 	for _, targetPod := range kCmd.targetPods {
 		pod := targetPod
-		tracer := kstrace.NewKStracer(kCmd.clientset, kCmd.restConfig, *kCmd.traceImage, &pod, ns.Name, *kCmd.socketPath, kCmd.traceTimeout, *kCmd.outputDirectory)
+		tracer := kstrace.NewKStracer(kstrace.KStracerOptions{
+			Clientset:       kCmd.clientset,
+			RestConfig:      kCmd.restConfig,
+			TraceImage:      *kCmd.traceImage,
+			TargetPod:       &pod,
+			Namespace:       traceNamespace,
+			SocketPath:      *kCmd.socketPath,
+			Timeout:         kCmd.traceTimeout,
+			OutputDirectory: *kCmd.outputDirectory,
+			StackTraces:     *kCmd.stackTraces,
+			Observers:       observers,
+			Stdout:          stdout,
+			Sink:            sink,
+			Budget:          budget,
+			Redactor:        kCmd.redactor,
+			Pool:            pool,
+		})
 		kCmd.tracers = append(kCmd.tracers, tracer)
 	}
 
@@ -274,28 +701,154 @@ func (kCmd *KubeStraceCommand) Run() error {
 		// Async start all tracers
 		tracerWaitGroup.Add(1)
 		go func(tracer kstrace.Tracer) {
-			err = tracer.Start()
-
-			// Configure Cleanup
+			// The tracer is cleaned up before the run is considered complete
+			defer tracerWaitGroup.Done()
 			defer tracer.Cleanup()
 
-			tracerWaitGroup.Done()
-			if err != nil {
-				log.Errorf("Once of the collections has failed and may require manual cleanup. Please ensure the %q Namespace is removed.", ns.Name)
-				return
+			if err := tracer.Start(ctx); err != nil && ctx.Err() == nil {
+				log.Errorf("The collection of pod %q has failed. %v", tracer.Result().Pod, err)
 			}
-
 		}(tracer)
 	}
 	// Wait for tracers
 	tracerWaitGroup.Wait()
 
-	// Remove signal catcher
-	closeSignalHandler <- true
+	// Record how the collection was produced
+	if *kCmd.outputDirectory != "-" {
+		manifest.TraceNamespace = traceNamespace
+		manifest.StopTime = time.Now()
+		for _, tracer := range kCmd.tracers {
+			manifest.Pods = append(manifest.Pods, tracer.Result())
+		}
+		if err := os.MkdirAll(*kCmd.outputDirectory, 0775); err != nil {
+			log.Warnf("Unable to create directory for the strace collection. %v", err)
+		} else if err := kstrace.WriteManifest(*kCmd.outputDirectory, manifest); err != nil {
+			log.Warnf("Unable to write the collection manifest. %v", err)
+		}
+	}
 
+	// The report is printed once the dashboard has restored the terminal
+	if *kCmd.detectOutliers {
+		closeUI()
+		if err := printOutliers(*kCmd.outputDirectory); err != nil {
+			log.Errorf("Unable to compare the traced replicas. %v", err)
+		}
+	}
+
+	if *kCmd.bundle {
+		archivePath, err := kstrace.BundleCollection(*kCmd.outputDirectory, kCmd.compression)
+		if err != nil {
+			log.Errorf("Unable to bundle the strace collection. %v", err)
+		} else {
+			log.Infof("Strace collection archived to %q", archivePath)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
 	return nil
 }
 
+// preflight checks the current user holds every permission needed to trace the target Pods
+func (kCmd *KubeStraceCommand) preflight(ctx context.Context, grantSCC bool) error {
+	targetNamespaces := []string{}
+	for _, targetPod := range kCmd.targetPods {
+		targetNamespaces = append(targetNamespaces, targetPod.Namespace)
+	}
+	checks, err := kstrace.CheckAccess(ctx, kCmd.clientset, kstrace.RequiredPermissions(kstrace.AccessOptions{
+		TracerNamespace:    *kCmd.tracerNamespace,
+		TargetNamespaces:   targetNamespaces,
+		ImagePullSecrets:   *kCmd.imagePullSecrets,
+		SecretNamespace:    kCmd.namespace,
+		Redact:             kCmd.redactor != nil,
+		GrantPrivilegedSCC: grantSCC,
+	}))
+	if err != nil {
+		// Clusters that do not serve access reviews are traced without the check
+		log.Warnf("Skipping the permission check. %v", err)
+		return nil
+	}
+
+	missing := kstrace.MissingPermissions(checks)
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := writeAccessTable(os.Stderr, missing); err != nil {
+		return err
+	}
+	return fmt.Errorf("missing %d permissions required to trace. nothing was created in the cluster", len(missing))
+}
+
+// printOutliers compares the replicas in a finished collection
+func printOutliers(directory string) error {
+	collection, err := analyze.LoadCollection(directory)
+	if err != nil {
+		return err
+	}
+	defer collection.Close()
+
+	report, err := analyze.DetectOutliers(collection, analyze.DefaultOutlierOptions())
+	if err != nil {
+		return err
+	}
+	return analyze.WriteOutlierReport(os.Stdout, report, analyze.FormatTable)
+}
+
+// parseTracerScheduling converts the flags that control where and how the tracer Pods run
+func (kCmd *KubeStraceCommand) parseTracerScheduling() error {
+	var err error
+
+	if len(*kCmd.tracerTolerations) > 0 {
+		kCmd.tracerPod.Tolerations = []corev1.Toleration{}
+	}
+	for _, spec := range *kCmd.tracerTolerations {
+		toleration, err := kstrace.ParseToleration(spec)
+		if err != nil {
+			return err
+		}
+		kCmd.tracerPod.Tolerations = append(kCmd.tracerPod.Tolerations, toleration)
+	}
+
+	kCmd.tracerPod.Resources.Requests, err = kstrace.ParseResourceList(*kCmd.tracerRequests)
+	if err != nil {
+		return err
+	}
+	kCmd.tracerPod.Resources.Limits, err = kstrace.ParseResourceList(*kCmd.tracerLimits)
+	if err != nil {
+		return err
+	}
+	kCmd.tracerPod.Labels, err = kstrace.ParseKeyValues(*kCmd.tracerLabels)
+	if err != nil {
+		return fmt.Errorf("invalid --tracer-label. %v", err)
+	}
+	kCmd.tracerPod.Annotations, err = kstrace.ParseKeyValues(*kCmd.tracerAnnotations)
+	if err != nil {
+		return fmt.Errorf("invalid --tracer-annotation. %v", err)
+	}
+	kCmd.tracerPod.PriorityClassName = *kCmd.tracerPriorityClass
+	kCmd.tracerPod.ServiceAccountName = *kCmd.tracerServiceAccount
+
+	kCmd.tracerPod.ImagePullPolicy, err = kstrace.ParsePullPolicy(*kCmd.imagePullPolicy)
+	if err != nil {
+		return err
+	}
+	kCmd.tracerPod.ImagePullSecrets = *kCmd.imagePullSecrets
+	return nil
+}
+
+// parseSize converts a size such as 100Mi into bytes
+func parseSize(size string) (int64, error) {
+	quantity, err := apiresource.ParseQuantity(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q. %v", size, err)
+	}
+	if quantity.Sign() < 0 {
+		return 0, fmt.Errorf("invalid size %q. the size cannot be negative", size)
+	}
+	return quantity.Value(), nil
+}
+
 func processResources(builder *resource.Builder, clientset *kubernetes.Clientset) ([]corev1.Pod, error) {
 	// Build the CLI requests
 	r := builder.Do()
@@ -385,26 +938,41 @@ func getPodsForLabel(labelSet *labels.Set, namespace string, clientset *kubernet
 	log.Infof("Pods found: [ %v ]", pods)
 	return pods, err
 }
-func (kCmd *KubeStraceCommand) setupSignalHandler(cleanupFunctions *[]func()) chan interface{} {
+
+// ErrInterrupted is returned once an interrupted trace has stopped and cleaned up
+var ErrInterrupted = errors.New("the trace was interrupted")
+
+// setupSignalHandler returns a context that is cancelled by the first SIGINT or SIGTERM. A second signal exits
+// immediately, skipping any cleanup that is still in progress. The returned function removes the handler.
+func setupSignalHandler() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
 	signals := make(chan os.Signal, 1)
-	exit := make(chan interface{})
+	closed := make(chan struct{})
 
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		select {
-		case sig := <-signals:
-			if sig == syscall.SIGINT || sig == syscall.SIGTERM {
-				log.Info("Cleanup signal received")
-				for _, cleanupFunc := range *cleanupFunctions {
-					cleanupFunc()
-				}
-				log.Info("Closing...")
-				os.Exit(130)
-			}
-		case <-exit:
+		case <-signals:
+			log.Info("Cleanup signal received. Stopping the traces, signal again to exit immediately")
+			cancel()
+		case <-closed:
 			return
 		}
 
+		select {
+		case <-signals:
+			log.Warn("Exiting before cleanup has completed. The tracer pods may require manual deletion.")
+			os.Exit(130)
+		case <-closed:
+		}
 	}()
-	return exit
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(closed)
+			cancel()
+		})
+	}
 }
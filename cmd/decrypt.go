@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/michaelwasher/kube-strace/pkg/analyze"
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+const identityUsage = "The age identity or PGP private key file used to read an encrypted collection."
+
+// Optional CLI flags
+type DecryptCommandArgs struct {
+	output   *string
+	identity *string
+}
+type DecryptCommand struct {
+	DecryptCommandArgs
+
+	// Command state
+	collectionPath string
+	// archive is set when the collection is a bundle, so the decrypted copy is bundled the same way
+	archive     bool
+	compression kstrace.Compression
+}
+
+func NewDecryptDefaults() DecryptCommandArgs {
+	return DecryptCommandArgs{
+		output:   stringptr(""),
+		identity: stringptr(""),
+	}
+}
+
+func NewDecryptCommand() *cobra.Command {
+	dCmd := &DecryptCommand{DecryptCommandArgs: NewDecryptDefaults()}
+
+	cmd := &cobra.Command{
+		Use:   "decrypt <collection>",
+		Short: "Restore a strace collection that was encrypted with --encrypt-to",
+		Long: `Write a copy of an encrypted strace collection directory or bundled archive with every log decrypted.
+
+Logs keep their compression and the manifest is updated to match. The original collection is left unchanged.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := dCmd.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := dCmd.Validate(); err != nil {
+				return err
+			}
+			return dCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(dCmd.output, "output", "o", *dCmd.output, "The directory to write the decrypted collection to. Defaults to the collection name with a \"-decrypted\" suffix.")
+	flags.StringVar(dCmd.identity, "identity", *dCmd.identity, identityUsage)
+
+	return cmd
+}
+
+func (dCmd *DecryptCommand) Complete(cmd *cobra.Command, args []string) error {
+	var name string
+	dCmd.collectionPath = filepath.Clean(args[0])
+	name, dCmd.archive, dCmd.compression = splitArchive(dCmd.collectionPath)
+	if *dCmd.output == "" {
+		*dCmd.output = name + "-decrypted"
+	}
+	*dCmd.output = filepath.Clean(*dCmd.output)
+	return nil
+}
+
+func (dCmd *DecryptCommand) Validate() error {
+	if *dCmd.identity == "" {
+		return fmt.Errorf("an identity file is required to decrypt the collection")
+	}
+	return validateCopy(dCmd.collectionPath, *dCmd.output, dCmd.archive, dCmd.compression)
+}
+
+func (dCmd *DecryptCommand) Run() error {
+	identities, err := kstrace.LoadIdentities(*dCmd.identity)
+	if err != nil {
+		return err
+	}
+	collection, err := analyze.LoadCollection(dCmd.collectionPath)
+	if err != nil {
+		return err
+	}
+	defer collection.Close()
+
+	if err := analyze.DecryptCollection(collection, *dCmd.output, identities); err != nil {
+		os.RemoveAll(*dCmd.output)
+		return err
+	}
+
+	if !dCmd.archive {
+		log.Infof("Decrypted collection written to %q", *dCmd.output)
+		return nil
+	}
+	// The copy was only written to be bundled
+	archivePath, err := kstrace.BundleCollection(*dCmd.output, dCmd.compression)
+	os.RemoveAll(*dCmd.output)
+	if err != nil {
+		return err
+	}
+	log.Infof("Decrypted collection archived to %q", archivePath)
+	return nil
+}
+
+// loadCollection loads a collection, reading encrypted logs with the identity file when one is given
+func loadCollection(path string, identityFile string) (*analyze.Collection, error) {
+	collection, err := analyze.LoadCollection(path)
+	if err != nil || identityFile == "" {
+		return collection, err
+	}
+
+	identities, err := kstrace.LoadIdentities(identityFile)
+	if err != nil {
+		collection.Close()
+		return nil, err
+	}
+	collection.Decrypt(identities)
+	return collection, nil
+}
+
+// splitArchive separates the name of a bundled collection from its archive extension
+func splitArchive(path string) (string, bool, kstrace.Compression) {
+	for _, compression := range append(kstrace.Compressions, kstrace.CompressionNone) {
+		if extension := ".tar" + compression.Extension(); strings.HasSuffix(path, extension) {
+			return strings.TrimSuffix(path, extension), true, compression
+		}
+	}
+	return path, false, kstrace.CompressionNone
+}
+
+// validateCopy ensures a copy of a collection never replaces the original or merges into existing data
+func validateCopy(collectionPath string, output string, archive bool, compression kstrace.Compression) error {
+	if output == collectionPath {
+		return fmt.Errorf("the copy of the collection cannot replace the original")
+	}
+
+	outputs := []string{output}
+	if archive {
+		outputs = append(outputs, output+".tar"+compression.Extension())
+	}
+	for _, output := range outputs {
+		if _, err := os.Stat(output); err == nil {
+			return fmt.Errorf("the output %q already exists", output)
+		}
+	}
+	return nil
+}
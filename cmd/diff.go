@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/michaelwasher/kube-strace/pkg/analyze"
+)
+
+// Optional CLI flags
+type DiffCommandArgs struct {
+	formatStr *string
+	limit     *int
+	identity  *string
+}
+type DiffCommand struct {
+	DiffCommandArgs
+
+	// Converted flags
+	format analyze.Format
+
+	// Command state
+	beforePath string
+	afterPath  string
+}
+
+func NewDiffDefaults() DiffCommandArgs {
+	return DiffCommandArgs{
+		formatStr: stringptr(string(analyze.FormatTable)),
+		limit:     intptr(20),
+		identity:  stringptr(""),
+	}
+}
+
+func NewDiffCommand() *cobra.Command {
+	dCmd := &DiffCommand{DiffCommandArgs: NewDiffDefaults()}
+
+	cmd := &cobra.Command{
+		Use:   "diff <collectionA> <collectionB>",
+		Short: "Compare two strace collections of the same workload",
+		Long: `Compare two strace collections, such as captures taken before and after a change.
+
+Containers are matched by name. The report compares the frequency, latency and errors of each system call and lists
+errors, file paths and network peers that only appear in one of the collections. Frequencies are compared as calls per
+second when both collections recorded timestamps.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := dCmd.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := dCmd.Validate(); err != nil {
+				return err
+			}
+			return dCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(dCmd.formatStr, "format", *dCmd.formatStr, fmt.Sprintf("The format of the report. Available options are %v.", analyze.Formats))
+	flags.IntVar(dCmd.limit, "limit", *dCmd.limit, "The maximum number of rows in each table of the report. Set to 0 to show every row.")
+	flags.StringVar(dCmd.identity, "identity", *dCmd.identity, identityUsage)
+
+	return cmd
+}
+
+func (dCmd *DiffCommand) Complete(cmd *cobra.Command, args []string) error {
+	dCmd.beforePath, dCmd.afterPath = args[0], args[1]
+	return nil
+}
+
+func (dCmd *DiffCommand) Validate() error {
+	var err error
+
+	dCmd.format, err = analyze.ParseFormat(*dCmd.formatStr)
+	if err != nil {
+		return err
+	}
+	if *dCmd.limit < 0 {
+		return fmt.Errorf("the row limit cannot be negative")
+	}
+	return nil
+}
+
+func (dCmd *DiffCommand) Run() error {
+	before, err := loadCollection(dCmd.beforePath, *dCmd.identity)
+	if err != nil {
+		return err
+	}
+	defer before.Close()
+
+	after, err := loadCollection(dCmd.afterPath, *dCmd.identity)
+	if err != nil {
+		return err
+	}
+	defer after.Close()
+
+	diff, err := analyze.DiffCollections(before, after)
+	if err != nil {
+		return err
+	}
+	return analyze.WriteDiff(os.Stdout, diff, dCmd.format, *dCmd.limit)
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/michaelwasher/kube-strace/pkg/analyze"
+)
+
+// Output formats available from the flamegraph command
+const (
+	flameGraphFolded = "folded"
+	flameGraphSVG    = "svg"
+)
+
+var flameGraphFormats = []string{flameGraphFolded, flameGraphSVG}
+
+// Optional CLI flags
+type FlameGraphCommandArgs struct {
+	weightStr       *string
+	formats         *[]string
+	outputDirectory *string
+	identity        *string
+}
+type FlameGraphCommand struct {
+	FlameGraphCommandArgs
+
+	// Converted flags
+	weight analyze.Weight
+
+	// Command state
+	collectionPath string
+}
+
+func NewFlameGraphDefaults() FlameGraphCommandArgs {
+	return FlameGraphCommandArgs{
+		weightStr:       stringptr(string(analyze.WeightCount)),
+		formats:         &[]string{flameGraphFolded, flameGraphSVG},
+		outputDirectory: stringptr("flamegraphs"),
+		identity:        stringptr(""),
+	}
+}
+
+func NewFlameGraphCommand() *cobra.Command {
+	fCmd := &FlameGraphCommand{FlameGraphCommandArgs: NewFlameGraphDefaults()}
+
+	cmd := &cobra.Command{
+		Use:   "flamegraph <collection>",
+		Short: "Fold the stack traces in a strace collection into flame graphs",
+		Long: `Fold the user space stack traces recorded with --stack-traces into a flame graph for each container.
+
+Stacks are written in the folded format used by flamegraph.pl and compatible tools, and rendered as an SVG.
+Each stack ends with the system call it made and is weighted by the number of calls or the time spent in them.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := fCmd.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := fCmd.Validate(); err != nil {
+				return err
+			}
+			return fCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(fCmd.weightStr, "weight", *fCmd.weightStr, fmt.Sprintf("How each stack is weighted. Available options are %v.", analyze.Weights))
+	flags.StringSliceVar(fCmd.formats, "format", *fCmd.formats, fmt.Sprintf("The files to write for each container. Available options are %v.", flameGraphFormats))
+	flags.StringVarP(fCmd.outputDirectory, "output", "o", *fCmd.outputDirectory, "The directory to write the flame graphs to.")
+	flags.StringVar(fCmd.identity, "identity", *fCmd.identity, identityUsage)
+
+	return cmd
+}
+
+func (fCmd *FlameGraphCommand) Complete(cmd *cobra.Command, args []string) error {
+	fCmd.collectionPath = args[0]
+	return nil
+}
+
+func (fCmd *FlameGraphCommand) Validate() error {
+	var err error
+
+	fCmd.weight, err = analyze.ParseWeight(*fCmd.weightStr)
+	if err != nil {
+		return err
+	}
+	for _, format := range *fCmd.formats {
+		if format != flameGraphFolded && format != flameGraphSVG {
+			return fmt.Errorf("unsupported format %q. available options are %v", format, flameGraphFormats)
+		}
+	}
+	return nil
+}
+
+func (fCmd *FlameGraphCommand) Run() error {
+	collection, err := loadCollection(fCmd.collectionPath, *fCmd.identity)
+	if err != nil {
+		return err
+	}
+	defer collection.Close()
+
+	if err := os.MkdirAll(*fCmd.outputDirectory, 0775); err != nil {
+		return err
+	}
+
+	written := 0
+	for _, containerLog := range collection.Containers {
+		graph, err := analyze.FoldStacks(containerLog, fCmd.weight)
+		if err != nil {
+			return err
+		}
+		if graph.Unwound == 0 {
+			log.Warnf("no stack traces were recorded for %s. collect with --stack-traces to record them", containerLog.Name())
+			continue
+		}
+
+		name := containerLog.Container
+		if containerLog.Pod != "" {
+			name = containerLog.Pod + "_" + containerLog.Container
+		}
+		for _, format := range *fCmd.formats {
+			path := filepath.Join(*fCmd.outputDirectory, name+"."+format)
+			if err := writeFlameGraph(path, graph, format); err != nil {
+				return err
+			}
+			log.Infof("wrote %s", path)
+		}
+		written++
+	}
+
+	if written == 0 {
+		return fmt.Errorf("the collection does not contain any stack traces")
+	}
+	return nil
+}
+
+func writeFlameGraph(path string, graph *analyze.FlameGraph, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == flameGraphSVG {
+		err = graph.WriteSVG(file)
+	} else {
+		err = graph.WriteFolded(file)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
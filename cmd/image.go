@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+// Optional CLI flags
+type ImageCommandArgs struct {
+	output         *string
+	registryMirror *string
+}
+type ImageCommand struct {
+	ImageCommandArgs
+}
+
+func NewImageDefaults() ImageCommandArgs {
+	return ImageCommandArgs{
+		output:         stringptr("-"),
+		registryMirror: stringptr(""),
+	}
+}
+
+func NewImageCommand() *cobra.Command {
+	iCmd := &ImageCommand{ImageCommandArgs: NewImageDefaults()}
+
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Print the tracer image used by this version of kstrace",
+		Long: `Print the exact reference of the default tracer image so it can be mirrored into a private registry.
+
+With --registry-mirror the source and mirrored references are printed on one line, ready for a tool such as
+"skopeo copy docker://<source> docker://<mirror>". Pass the same --registry-mirror when tracing.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return iCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(iCmd.output, "output", "o", *iCmd.output, "The file to save the image reference to. Use \"-\" for standard out.")
+	flags.StringVar(iCmd.registryMirror, "registry-mirror", *iCmd.registryMirror, "A registry, optionally with a path prefix, that the image is mirrored to.")
+
+	return cmd
+}
+
+func (iCmd *ImageCommand) Run() error {
+	image := defaultTraceImage()
+	line := image
+	if *iCmd.registryMirror != "" {
+		line = fmt.Sprintf("%s %s", image, kstrace.MirrorImage(image, *iCmd.registryMirror))
+	}
+
+	var output io.Writer = os.Stdout
+	if *iCmd.output != "-" {
+		file, err := os.Create(*iCmd.output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	if _, err := fmt.Fprintln(output, line); err != nil {
+		return err
+	}
+	if *iCmd.output != "-" {
+		log.Infof("Image reference saved to %q", *iCmd.output)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/michaelwasher/kube-strace/pkg/analyze"
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/redact"
+)
+
+// Optional CLI flags
+type RedactCommandArgs struct {
+	output   *string
+	patterns *[]string
+	secrets  *bool
+}
+type RedactCommand struct {
+	RedactCommandArgs
+
+	// Converted flags
+	redactor *redact.Redactor
+
+	// Command state
+	collectionPath string
+	// archive is set when the collection is a bundle, so the redacted copy is bundled the same way
+	archive     bool
+	compression kstrace.Compression
+
+	// GenericCLI Options
+	kubeConfigFlags *genericclioptions.ConfigFlags
+}
+
+func NewRedactDefaults() RedactCommandArgs {
+	return RedactCommandArgs{
+		output:   stringptr(""),
+		patterns: &[]string{},
+		secrets:  boolptr(false),
+	}
+}
+
+func NewRedactCommand(kubeConfigFlags *genericclioptions.ConfigFlags) *cobra.Command {
+	rCmd := &RedactCommand{RedactCommandArgs: NewRedactDefaults(), kubeConfigFlags: kubeConfigFlags}
+
+	cmd := &cobra.Command{
+		Use:   "redact <collection>",
+		Short: "Remove secrets from an existing strace collection",
+		Long: `Write a copy of a strace collection directory or bundled archive with secrets removed from every log.
+
+Bearer tokens, AWS keys and JWTs are always removed, along with matches of any --redact-pattern. Use --secrets to also
+remove the values of environment variables sourced from Secrets in the traced Pods, which must still exist.
+The original collection is left unchanged. New traces are redacted as they are written with --redact.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := rCmd.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := rCmd.Validate(); err != nil {
+				return err
+			}
+			return rCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(rCmd.output, "output", "o", *rCmd.output, "The directory to write the redacted collection to. Defaults to the collection name with a \"-redacted\" suffix.")
+	flags.StringArrayVar(rCmd.patterns, "redact-pattern", *rCmd.patterns, "A regular expression to redact in addition to the built-in detectors. Only the first capture group is replaced when present.")
+	flags.BoolVar(rCmd.secrets, "secrets", *rCmd.secrets, "Look up the Secrets used by the traced Pods in the current cluster and redact their values.")
+
+	return cmd
+}
+
+func (rCmd *RedactCommand) Complete(cmd *cobra.Command, args []string) error {
+	var name string
+	rCmd.collectionPath = filepath.Clean(args[0])
+	name, rCmd.archive, rCmd.compression = splitArchive(rCmd.collectionPath)
+	if *rCmd.output == "" {
+		*rCmd.output = name + "-redacted"
+	}
+	*rCmd.output = filepath.Clean(*rCmd.output)
+	return nil
+}
+
+func (rCmd *RedactCommand) Validate() error {
+	var err error
+
+	rCmd.redactor, err = redact.New(*rCmd.patterns)
+	if err != nil {
+		return err
+	}
+	return validateCopy(rCmd.collectionPath, *rCmd.output, rCmd.archive, rCmd.compression)
+}
+
+func (rCmd *RedactCommand) Run() error {
+	collection, err := analyze.LoadCollection(rCmd.collectionPath)
+	if err != nil {
+		return err
+	}
+	defer collection.Close()
+
+	options := analyze.RedactOptions{Redactor: rCmd.redactor, Secrets: rCmd.podSecrets(collection)}
+	if err := analyze.RedactCollection(collection, *rCmd.output, options); err != nil {
+		os.RemoveAll(*rCmd.output)
+		return err
+	}
+
+	if !rCmd.archive {
+		log.Infof("Redacted collection written to %q", *rCmd.output)
+		return nil
+	}
+	// The copy was only written to be bundled
+	archivePath, err := kstrace.BundleCollection(*rCmd.output, rCmd.compression)
+	os.RemoveAll(*rCmd.output)
+	if err != nil {
+		return err
+	}
+	log.Infof("Redacted collection archived to %q", archivePath)
+	return nil
+}
+
+// podSecrets looks up the Secret values used by each traced Pod. Lookups are best effort, so Pods that can no
+// longer be read are logged and skipped.
+func (rCmd *RedactCommand) podSecrets(collection *analyze.Collection) map[string][]string {
+	if !*rCmd.secrets {
+		return nil
+	}
+	if collection.Manifest == nil {
+		log.Warnf("The collection has no manifest, so the traced Pods and their Secrets are unknown.")
+		return nil
+	}
+
+	_, clientset, err := newClientset(rCmd.kubeConfigFlags)
+	if err != nil {
+		log.Warnf("Unable to connect to the cluster, Secret values will not be redacted. %v", err)
+		return nil
+	}
+
+	ctx := context.TODO()
+	secrets := map[string][]string{}
+	for _, traced := range collection.Manifest.Pods {
+		pod, err := clientset.CoreV1().Pods(traced.Namespace).Get(ctx, traced.Pod, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("Unable to find pod %q, its Secret values will not be redacted. %v", traced.Pod, err)
+			continue
+		}
+		values, err := kstrace.SecretEnvValues(ctx, clientset, pod)
+		if err != nil {
+			log.Warnf("Unable to read every Secret used by pod %q. Some Secret values may not be redacted. %v", traced.Pod, err)
+		}
+		secrets[traced.Pod] = values
+	}
+	return secrets
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/michaelwasher/kube-strace/pkg/analyze"
+)
+
+// Optional CLI flags
+type ReportCommandArgs struct {
+	output    *string
+	title     *string
+	maxEvents *int
+	slowest   *int
+	identity  *string
+}
+type ReportCommand struct {
+	ReportCommandArgs
+
+	// Command state
+	collectionPath string
+}
+
+func NewReportDefaults() ReportCommandArgs {
+	return ReportCommandArgs{
+		output:    stringptr("strace-report.html"),
+		title:     stringptr(""),
+		maxEvents: intptr(10000),
+		slowest:   intptr(10),
+		identity:  stringptr(""),
+	}
+}
+
+func NewReportCommand() *cobra.Command {
+	rCmd := &ReportCommand{ReportCommandArgs: NewReportDefaults()}
+
+	cmd := &cobra.Command{
+		Use:   "report <collection>",
+		Short: "Render a strace collection as a self-contained HTML report",
+		Long: `Render a strace collection as a single HTML file that can be attached to a ticket and viewed offline.
+
+The report includes the manifest, a summary of the system calls of each container with latency histograms, errors,
+the file and network reports, and a searchable table of events. It does not load any external assets.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := rCmd.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := rCmd.Validate(); err != nil {
+				return err
+			}
+			return rCmd.Run()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(rCmd.output, "output", "o", *rCmd.output, "The file to write the report to. Use \"-\" to write to standard out.")
+	flags.StringVar(rCmd.title, "title", *rCmd.title, "The title of the report. Defaults to the name of the collection.")
+	flags.IntVar(rCmd.maxEvents, "max-events", *rCmd.maxEvents, "The maximum number of events to embed per container. Set to 0 to embed every event.")
+	flags.IntVar(rCmd.slowest, "slowest", *rCmd.slowest, "The number of slowest individual calls to list per container.")
+	flags.StringVar(rCmd.identity, "identity", *rCmd.identity, identityUsage)
+
+	return cmd
+}
+
+func (rCmd *ReportCommand) Complete(cmd *cobra.Command, args []string) error {
+	rCmd.collectionPath = args[0]
+	if *rCmd.title == "" {
+		*rCmd.title = fmt.Sprintf("strace report: %s", filepath.Base(filepath.Clean(rCmd.collectionPath)))
+	}
+	return nil
+}
+
+func (rCmd *ReportCommand) Validate() error {
+	if *rCmd.maxEvents < 0 {
+		return fmt.Errorf("the maximum number of events cannot be negative")
+	}
+	if *rCmd.slowest < 0 {
+		return fmt.Errorf("the number of slowest calls cannot be negative")
+	}
+	return nil
+}
+
+func (rCmd *ReportCommand) Run() error {
+	collection, err := loadCollection(rCmd.collectionPath, *rCmd.identity)
+	if err != nil {
+		return err
+	}
+	defer collection.Close()
+
+	options := analyze.HTMLOptions{Title: *rCmd.title, MaxEvents: *rCmd.maxEvents, Slowest: *rCmd.slowest}
+	if *rCmd.output == "-" {
+		return analyze.WriteHTMLReport(os.Stdout, collection, options)
+	}
+
+	file, err := os.Create(*rCmd.output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := analyze.WriteHTMLReport(file, collection, options); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	log.Infof("Report written to %q", *rCmd.output)
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"path/filepath"
 	"strings"
 
@@ -24,6 +25,11 @@ func main() {
 
 	root := cmd.NewKubeStraceCommand(applicationName)
 	if err := root.Execute(); err != nil {
+		// The traces were stopped and cleaned up before the command returned
+		if errors.Is(err, cmd.ErrInterrupted) {
+			log.Info("Closing...")
+			os.Exit(130)
+		}
 		log.Debugf("%s has failed with error: %v", applicationName, err)
 		log.Fatalf("%s has encountered a problem and needs to close. Please try again.", applicationName)
 		os.Exit(1)
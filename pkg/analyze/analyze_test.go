@@ -0,0 +1,574 @@
+package analyze
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/redact"
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+func writeFile(t *testing.T, path string, content string, compress bool) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		t.Fatalf("Unable to create directory. %v", err)
+	}
+
+	data := []byte(content)
+	if compress {
+		buffer := new(bytes.Buffer)
+		writer := gzip.NewWriter(buffer)
+		writer.Write(data)
+		writer.Close()
+		data = buffer.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0664); err != nil {
+		t.Fatalf("Unable to write file. %v", err)
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	directory := t.TempDir()
+
+	// A rotated and compressed log split part way through an unfinished call
+	writeFile(t, filepath.Join(directory, "pod-a", "app_strace.log.gz"), strings.Join([]string{
+		`10:00:00.000000 openat(AT_FDCWD, "/etc/hosts", O_RDONLY) = 3 <0.000100>`,
+		`10:00:00.100000 openat(AT_FDCWD, "/missing", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000200>`,
+		`[pid 12] 10:00:00.200000 read(3,  <unfinished ...>`,
+		``,
+	}, "\n"), true)
+	writeFile(t, filepath.Join(directory, "pod-a", "app_strace.log.1.gz"), strings.Join([]string{
+		`[pid 12] 10:00:01.200000 <... read resumed>"data", 4096) = 4 <1.000000>`,
+		`10:00:02.000000 close(3) = 0 <0.000010>`,
+		``,
+	}, "\n"), true)
+	writeFile(t, filepath.Join(directory, "pod-b", "sidecar_strace.log"), "10:00:00.000000 close(1) = -1 EBADF (Bad file descriptor) <0.000010>\n", false)
+
+	collection, err := LoadCollection(directory)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+	if len(collection.Containers) != 2 || len(collection.Containers[0].Files) != 2 {
+		t.Fatalf("Collection mismatch. Got: %+v", collection.Containers)
+	}
+
+	summary, err := Summarise(collection, Options{Slowest: 2})
+	if err != nil {
+		t.Fatalf("Unable to summarise the collection. %v", err)
+	}
+
+	app := summary.Containers[0]
+	if app.Pod != "pod-a" || app.Container != "app" || app.Calls != 4 {
+		t.Fatalf("Container mismatch. Got: %+v", app)
+	}
+	if app.Syscalls[0].Name != "openat" || app.Syscalls[0].Count != 2 || app.Syscalls[0].Errors != 1 {
+		t.Errorf("Syscall mismatch. Got: %+v", app.Syscalls[0])
+	}
+	if app.Errors["ENOENT"] != 1 {
+		t.Errorf("Error mismatch. Got: %v", app.Errors)
+	}
+	if len(app.Slowest) != 2 || app.Slowest[0].Syscall != "read" || app.Slowest[1].Syscall != "openat" {
+		t.Errorf("Slowest calls mismatch. Got: %+v", app.Slowest)
+	}
+
+	output := new(bytes.Buffer)
+	if err := WriteSummary(output, summary, FormatMarkdown); err != nil {
+		t.Fatalf("Unable to write the summary. %v", err)
+	}
+	if !strings.Contains(output.String(), "| openat | 2 | 1 |") {
+		t.Errorf("Markdown output missing the openat row:\n%s", output.String())
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	histogram := NewHistogram()
+	for i := 1; i <= 100; i++ {
+		histogram.Add(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		quantile float64
+		expected time.Duration
+	}{{0.50, 50 * time.Millisecond}, {0.95, 95 * time.Millisecond}, {0.99, 99 * time.Millisecond}, {1, 100 * time.Millisecond}}
+
+	for _, tc := range tests {
+		value := histogram.Quantile(tc.quantile)
+		if value < tc.expected || float64(value) > float64(tc.expected)*bucketGrowth {
+			t.Errorf("Quantile %v mismatch. Expected ~%v, Got: %v", tc.quantile, tc.expected, value)
+		}
+	}
+}
+
+func TestReportFiles(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, filepath.Join(directory, "pod-a", "app_strace.log"), strings.Join([]string{
+		`10:00:00.000000 openat(AT_FDCWD, "/etc/config/app.yaml", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000010>`,
+		`10:00:00.000000 openat(AT_FDCWD, "/etc/hosts", O_RDONLY) = 3 <0.000010>`,
+		`10:00:00.000000 stat("/etc/hosts", {st_mode=S_IFREG|0644, st_size=10, ...}) = 0 <0.000010>`,
+		`10:00:00.000000 openat(3</etc/config>, "app.yaml", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000010>`,
+		`10:00:00.000000 read(3, "127.0.0.1", 10) = 10 <0.000010>`,
+		``,
+	}, "\n"), false)
+
+	collection, err := LoadCollection(directory)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+	collection.Manifest = &kstrace.Manifest{Pods: []kstrace.TraceResult{{
+		Pod: "pod-a",
+		Containers: []kstrace.ContainerResult{{
+			Name:   "app",
+			Mounts: []kstrace.Mount{{Volume: "config", MountPath: "/etc/config", Source: "configMap/app-config"}},
+		}},
+	}}}
+
+	report, err := ReportFiles(collection)
+	if err != nil {
+		t.Fatalf("Unable to report the files. %v", err)
+	}
+
+	files := report.Containers[0].Files
+	if len(files) != 2 {
+		t.Fatalf("File count mismatch. Expected %d, Got: %d", 2, len(files))
+	}
+	if files[0].Path != "/etc/config/app.yaml" || files[0].Results["ENOENT"] != 2 || !files[0].Highlighted() {
+		t.Errorf("Expected the missing config file first. Got: %+v", files[0])
+	}
+	if files[0].Mount == nil || files[0].Mount.Volume != "config" {
+		t.Errorf("Expected the config file to map to the config volume. Got: %+v", files[0].Mount)
+	}
+	if files[1].Path != "/etc/hosts" || files[1].Operations["stat"] != 1 || files[1].Operations["openat"] != 1 || files[1].Mount != nil {
+		t.Errorf("File mismatch. Got: %+v", files[1])
+	}
+}
+
+type staticResolver map[string]string
+
+func (resolver staticResolver) Resolve(address string) string {
+	return resolver[address]
+}
+
+func TestReportNetwork(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, filepath.Join(directory, "pod-a", "app_strace.log"), strings.Join([]string{
+		`10:00:00.000000 connect(3<TCP:[12345]>, {sa_family=AF_INET, sin_port=htons(5432), sin_addr=inet_addr("10.96.0.10")}, 16) = -1 EINPROGRESS (Operation now in progress) <0.000100>`,
+		`10:00:00.000000 sendto(3<TCP:[10.0.0.5:40000->10.96.0.10:5432]>, "query", 5, 0, NULL, 0) = 5 <0.000010>`,
+		`10:00:00.000000 recvfrom(3<TCP:[10.0.0.5:40000->10.96.0.10:5432]>, "row", 4096, 0, NULL, NULL) = 3 <0.000010>`,
+		`10:00:00.000000 connect(4<TCP:[12346]>, {sa_family=AF_INET, sin_port=htons(6379), sin_addr=inet_addr("10.96.0.20")}, 16) = -1 ECONNREFUSED (Connection refused) <0.000020>`,
+		`10:00:00.000000 accept4(5<TCP:[8080]>, {sa_family=AF_INET, sin_port=htons(51000), sin_addr=inet_addr("10.0.0.9")}, [16], SOCK_CLOEXEC) = 6<TCP:[10.0.0.5:8080->10.0.0.9:51000]> <0.000010>`,
+		`10:00:00.000000 connect(7<UNIX:[999]>, {sa_family=AF_UNIX, sun_path="/var/run/app.sock"}, 110) = 0 <0.000010>`,
+		``,
+	}, "\n"), false)
+
+	collection, err := LoadCollection(directory)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+	report, err := ReportNetwork(collection, staticResolver{"10.96.0.10": "service/default/postgres"})
+	if err != nil {
+		t.Fatalf("Unable to report the network. %v", err)
+	}
+
+	peers := map[string]*Peer{}
+	for _, peer := range report.Containers[0].Peers {
+		peers[peer.Direction+" "+peer.Endpoint()] = peer
+	}
+	if len(peers) != 4 {
+		t.Fatalf("Peer count mismatch. Expected %d, Got: %v", 4, peers)
+	}
+
+	database := peers["outbound 10.96.0.10:5432"]
+	if database == nil || database.Calls != 3 || len(database.Failures) != 0 || database.Resource != "service/default/postgres" {
+		t.Errorf("Database peer mismatch. Got: %+v", database)
+	}
+	if cache := peers["outbound 10.96.0.20:6379"]; cache == nil || cache.Failures["ECONNREFUSED"] != 1 {
+		t.Errorf("Expected the refused connection to be recorded. Got: %+v", cache)
+	}
+	if client := peers["inbound 10.0.0.9:8080"]; client == nil || client.Protocol != "TCP" {
+		t.Errorf("Expected the accepted client on the local port. Got: %+v", client)
+	}
+	if socket := peers["outbound /var/run/app.sock"]; socket == nil || socket.Protocol != "UNIX" {
+		t.Errorf("Expected the unix socket peer. Got: %+v", socket)
+	}
+
+	output := new(bytes.Buffer)
+	if err := WriteNetworkReport(output, report, FormatTable); err != nil {
+		t.Fatalf("Unable to write the network report. %v", err)
+	}
+	if !strings.Contains(output.String(), "!ECONNREFUSED") {
+		t.Errorf("Expected the refused connection to be highlighted:\n%s", output.String())
+	}
+}
+
+func TestDiffCollections(t *testing.T) {
+	before, after := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(before, "web-1", "app_strace.log"), strings.Join([]string{
+		`10:00:00.000000 openat(AT_FDCWD, "/etc/app.yaml", O_RDONLY) = 3 <0.000010>`,
+		`10:00:01.000000 read(3, "data", 4096) = 4 <0.000010>`,
+		`10:00:02.000000 openat(AT_FDCWD, "/proc/12/status", O_RDONLY) = 4 <0.000010>`,
+		`10:00:10.000000 close(3) = 0 <0.000010>`,
+		``,
+	}, "\n"), false)
+	writeFile(t, filepath.Join(after, "web-2", "app_strace.log"), strings.Join([]string{
+		`10:00:00.000000 openat(AT_FDCWD, "/etc/app.yml", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000010>`,
+		`10:00:00.500000 openat(AT_FDCWD, "/proc/99/status", O_RDONLY) = 4 <0.000010>`,
+		`10:00:01.000000 connect(5<TCP:[1]>, {sa_family=AF_INET, sin_port=htons(6379), sin_addr=inet_addr("10.96.0.20")}, 16) = 0 <0.000100>`,
+		`10:00:05.000000 close(3) = 0 <0.000010>`,
+		``,
+	}, "\n"), false)
+
+	beforeCollection, err := LoadCollection(before)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+	afterCollection, err := LoadCollection(after)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+
+	diff, err := DiffCollections(beforeCollection, afterCollection)
+	if err != nil {
+		t.Fatalf("Unable to diff the collections. %v", err)
+	}
+	if len(diff.Containers) != 1 {
+		t.Fatalf("Expected the containers to be matched by name. Got: %+v", diff.Containers)
+	}
+	container := diff.Containers[0]
+	if !container.Timed {
+		t.Errorf("Expected the frequencies to be compared as rates")
+	}
+
+	syscalls := map[string]SyscallDiff{}
+	for _, syscall := range container.Syscalls {
+		syscalls[syscall.Name] = syscall
+	}
+	if syscalls["connect"].Change != ChangeNew || syscalls["read"].Change != ChangeMissing {
+		t.Errorf("Syscall change mismatch. Got: %+v", container.Syscalls)
+	}
+	// One close in ten seconds before, one in five seconds after
+	if closeDiff := syscalls["close"]; closeDiff.Ratio < 1.99 || closeDiff.Ratio > 2.01 {
+		t.Errorf("Expected the close rate to double. Got: %+v", closeDiff)
+	}
+
+	if len(container.NewErrors) != 1 || container.NewErrors[0] != (ErrorDiff{Syscall: "openat", Errno: "ENOENT", Count: 1}) {
+		t.Errorf("New error mismatch. Got: %+v", container.NewErrors)
+	}
+	if fmt.Sprint(container.NewPaths) != "[/etc/app.yml]" || fmt.Sprint(container.MissingPaths) != "[/etc/app.yaml]" {
+		t.Errorf("Path mismatch. New: %v, Missing: %v", container.NewPaths, container.MissingPaths)
+	}
+	if fmt.Sprint(container.NewPeers) != "[outbound TCP 10.96.0.20:6379]" {
+		t.Errorf("Peer mismatch. Got: %v", container.NewPeers)
+	}
+
+	output := new(bytes.Buffer)
+	if err := WriteDiff(output, diff, FormatMarkdown, 1); err != nil {
+		t.Fatalf("Unable to write the diff. %v", err)
+	}
+	if !strings.Contains(output.String(), "| ... 1 more |") {
+		t.Errorf("Expected the paths table to be limited:\n%s", output.String())
+	}
+}
+
+func TestFoldStacks(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, filepath.Join(directory, "pod-a", "app_strace.log"), strings.Join([]string{
+		`10:00:00.000000 read(3, "data", 4096) = 4 <0.000010>`,
+		` > /usr/lib/libc.so.6(__read+0x14) [0x10e1e2]`,
+		` > /app/server(handleRequest+0x20) [0x4a1f0]`,
+		` > /app/server() [0x4a000]`,
+		`10:00:00.100000 read(3, "data", 4096) = 4 <0.000030>`,
+		` > /usr/lib/libc.so.6(__read+0x14) [0x10e1e2]`,
+		` > /app/server(handleRequest+0x20) [0x4a1f0]`,
+		` > /app/server() [0x4a000]`,
+		`10:00:00.200000 close(3) = 0 <0.000005>`,
+		``,
+	}, "\n"), false)
+
+	collection, err := LoadCollection(directory)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+
+	tests := []struct {
+		weight   Weight
+		expected string
+	}{
+		{WeightCount, "[server];handleRequest;__read;read 2\n[unknown];close 1\n"},
+		{WeightTime, "[server];handleRequest;__read;read 40\n[unknown];close 5\n"},
+	}
+	for _, tc := range tests {
+		graph, err := FoldStacks(collection.Containers[0], tc.weight)
+		if err != nil {
+			t.Fatalf("Unable to fold the stacks. %v", err)
+		}
+		if graph.Unwound != 2 {
+			t.Errorf("Unwound mismatch. Expected %d, Got: %d", 2, graph.Unwound)
+		}
+
+		output := new(bytes.Buffer)
+		if err := graph.WriteFolded(output); err != nil {
+			t.Fatalf("Unable to write the folded stacks. %v", err)
+		}
+		if output.String() != tc.expected {
+			t.Errorf("Folded stacks mismatch for %s.\nExpected %q\nGot:     %q", tc.weight, tc.expected, output.String())
+		}
+	}
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, filepath.Join(directory, "pod-a", "app_strace.log"), strings.Join([]string{
+		`10:00:00.000000 openat(AT_FDCWD, "/etc/app.yaml", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000010>`,
+		`10:00:01.000000 read(3, "</script><script>alert(1)</script>", 4096) = 34 <0.002000>`,
+		`10:00:02.000000 close(3) = 0 <0.000010>`,
+		``,
+	}, "\n"), false)
+
+	collection, err := LoadCollection(directory)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+
+	output := new(bytes.Buffer)
+	if err := WriteHTMLReport(output, collection, HTMLOptions{Title: "report", MaxEvents: 2, Slowest: 5}); err != nil {
+		t.Fatalf("Unable to write the report. %v", err)
+	}
+	report := output.String()
+
+	for _, expected := range []string{`<h2 id="container-0">pod-a/app</h2>`, `<td class="error">ENOENT</td>`, "/etc/app.yaml", "Only the first 2 of 3 events"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected %q in the report", expected)
+		}
+	}
+	if strings.Contains(report, "<script>alert(1)") {
+		t.Errorf("Expected the embedded events to be escaped")
+	}
+	if strings.Contains(report, `src="http`) || strings.Contains(report, `href="http`) {
+		t.Errorf("Expected the report not to load external assets")
+	}
+}
+
+func TestDetectOutliers(t *testing.T) {
+	directory := t.TempDir()
+	manifest := &kstrace.Manifest{}
+	for _, pod := range []string{"web-1", "web-2", "web-3", "web-4", "batch"} {
+		owner := "ReplicaSet/web-5d8f"
+		if pod == "batch" {
+			owner = "Job/batch"
+		}
+		manifest.Pods = append(manifest.Pods, kstrace.TraceResult{Pod: pod, Owner: owner})
+
+		lines := []string{}
+		for second := 0; second < 30; second++ {
+			lines = append(lines, fmt.Sprintf(`10:00:%02d.000000 read(3, "data", 4096) = 4 <0.000010>`, second))
+			// One replica fails to find its configuration over and over
+			if pod == "web-3" {
+				lines = append(lines, fmt.Sprintf(`10:00:%02d.500000 openat(AT_FDCWD, "/etc/app.yml", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000010>`, second))
+			}
+		}
+		writeFile(t, filepath.Join(directory, pod, "app_strace.log"), strings.Join(append(lines, ""), "\n"), false)
+	}
+
+	collection, err := LoadCollection(directory)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+	collection.Manifest = manifest
+
+	report, err := DetectOutliers(collection, DefaultOutlierOptions())
+	if err != nil {
+		t.Fatalf("Unable to detect outliers. %v", err)
+	}
+	if len(report.Groups) != 2 {
+		t.Fatalf("Expected the replicas to be grouped by owner. Got: %+v", report.Groups)
+	}
+	if batch := report.Groups[0]; batch.Owner != "Job/batch" || batch.Skipped == "" {
+		t.Errorf("Expected a single pod not to be compared. Got: %+v", batch)
+	}
+
+	web := report.Groups[1]
+	if len(web.Pods) != 4 || len(web.Outliers) != 1 || web.Outliers[0].Pod != "web-3" {
+		t.Fatalf("Expected web-3 to be the only outlier. Got: %+v", web)
+	}
+	metrics := map[string]bool{}
+	for _, finding := range web.Outliers[0].Findings {
+		metrics[finding.Syscall+" "+finding.Metric] = true
+	}
+	for _, expected := range []string{" " + MetricErrorRate, "openat " + MetricShare, "openat " + MetricErrorRate, "read " + MetricShare} {
+		if !metrics[expected] {
+			t.Errorf("Expected a %q finding. Got: %+v", expected, web.Outliers[0].Findings)
+		}
+	}
+
+	output := &strings.Builder{}
+	if err := WriteOutlierReport(output, report, FormatTable); err != nil {
+		t.Fatalf("Unable to write the outlier report. %v", err)
+	}
+	if !strings.Contains(output.String(), "new") {
+		t.Errorf("Expected the failing openat calls to be reported as new. Got:\n%s", output.String())
+	}
+}
+
+func TestLoadPodDirectory(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "web-1", "app_strace.log"), "10:00:00.000000 read(3, \"\", 10) = 0 <0.000010>\n", false)
+	writeFile(t, filepath.Join(root, "web-2", "app_strace.log"), "10:00:00.000000 read(3, \"\", 10) = 0 <0.000010>\n", false)
+	result := kstrace.TraceResult{Pod: "web-1", Containers: []kstrace.ContainerResult{{Name: "app", LogFiles: []string{"web-1/app_strace.log"}}}}
+	if err := kstrace.WritePodManifest(filepath.Join(root, "web-1"), result); err != nil {
+		t.Fatalf("Unable to write the pod manifest. %v", err)
+	}
+	// Pod manifests written by older versions have no kind
+	if err := kstrace.WriteManifest(filepath.Join(root, "web-2"), result); err != nil {
+		t.Fatalf("Unable to write the pod manifest. %v", err)
+	}
+
+	for _, pod := range []string{"web-1", "web-2"} {
+		collection, err := LoadCollection(filepath.Join(root, pod))
+		if err != nil {
+			t.Fatalf("Unable to load the pod directory %q. %v", pod, err)
+		}
+		if len(collection.Containers) != 1 || collection.Containers[0].Container != "app" {
+			t.Fatalf("Expected the app container in %q. Got %+v", pod, collection.Containers)
+		}
+		if _, err := os.Stat(collection.Containers[0].Files[0]); err != nil {
+			t.Errorf("Expected the log of %q to exist. %v", pod, err)
+		}
+	}
+
+	if _, err := LoadCollection(t.TempDir()); err == nil || !strings.Contains(err.Error(), "no strace logs found") {
+		t.Errorf("Expected an error when there are no logs. Got %v", err)
+	}
+}
+
+func TestRedactCollection(t *testing.T) {
+	source, target := t.TempDir(), filepath.Join(t.TempDir(), "redacted")
+	writeFile(t, filepath.Join(source, "web-1", "app_strace.log.gz"),
+		"10:00:00.000000 write(3, \"Authorization: Bearer abc123\\r\\n\", 29) = 29 <0.000010>\n", true)
+	writeFile(t, filepath.Join(source, "web-1", "app_strace.log.1.gz"),
+		"10:00:01.000000 write(1, \"hunter22\", 8) = 8 <0.000010>", true)
+	manifest := &kstrace.Manifest{Pods: []kstrace.TraceResult{{Pod: "web-1", Containers: []kstrace.ContainerResult{{
+		Name: "app", LogFiles: []string{"web-1/app_strace.log.gz", "web-1/app_strace.log.1.gz"},
+	}}}}}
+	if err := kstrace.WriteManifest(source, manifest); err != nil {
+		t.Fatalf("Unable to write the manifest. %v", err)
+	}
+	if err := kstrace.WriteManifest(filepath.Join(source, "web-1"), manifest.Pods[0]); err != nil {
+		t.Fatalf("Unable to write the pod manifest. %v", err)
+	}
+
+	collection, err := LoadCollection(source)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+	redactor, err := redact.New(nil)
+	if err != nil {
+		t.Fatalf("Unable to create the redactor. %v", err)
+	}
+	options := RedactOptions{Redactor: redactor, Secrets: map[string][]string{"web-1": {"hunter22"}}}
+	if err := RedactCollection(collection, target, options); err != nil {
+		t.Fatalf("Unable to redact the collection. %v", err)
+	}
+
+	redacted, err := LoadCollection(target)
+	if err != nil {
+		t.Fatalf("Unable to load the redacted collection. %v", err)
+	}
+	if fmt.Sprint(redacted.Manifest.Redaction) != "[bearer-token aws-access-key aws-secret-key jwt secret]" {
+		t.Errorf("Expected the manifest to record the detectors. Got: %v", redacted.Manifest.Redaction)
+	}
+	if _, err := os.Stat(filepath.Join(target, "web-1", kstrace.ManifestFile)); err != nil {
+		t.Errorf("Expected the pod manifest to be copied. %v", err)
+	}
+
+	args := []string{}
+	err = redacted.Containers[0].Read(func(event *strace.Event) error {
+		args = append(args, event.Args)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to read the redacted log. %v", err)
+	}
+	expected := []string{`3, "Authorization: Bearer [REDACTED:bearer-token]\r\n", 29`, `1, "[REDACTED:secret]", 8`}
+	if fmt.Sprint(args) != fmt.Sprint(expected) {
+		t.Errorf("Redacted events mismatch. Expected %q, Got: %q", expected, args)
+	}
+}
+
+func TestDecryptCollection(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Unable to generate an age identity. %v", err)
+	}
+	keys := t.TempDir()
+	writeFile(t, filepath.Join(keys, "recipient"), identity.Recipient().String()+"\n", false)
+	writeFile(t, filepath.Join(keys, "identity"), identity.String()+"\n", false)
+	recipients, err := kstrace.LoadRecipients(filepath.Join(keys, "recipient"))
+	if err != nil {
+		t.Fatalf("Unable to load the recipients. %v", err)
+	}
+	identities, err := kstrace.LoadIdentities(filepath.Join(keys, "identity"))
+	if err != nil {
+		t.Fatalf("Unable to load the identities. %v", err)
+	}
+
+	// Write a collection the way the tracers do
+	source := t.TempDir()
+	sink := &kstrace.CompressedSink{Sink: &kstrace.EncryptedSink{Sink: &kstrace.FileSink{Directory: source}, Recipients: recipients}, Compression: kstrace.CompressionZstd}
+	writer, name, err := sink.Create(filepath.Join("web-1", "app_strace.log"))
+	if err != nil {
+		t.Fatalf("Unable to create the sink. %v", err)
+	}
+	fmt.Fprintln(writer, `10:00:00.000000 close(3) = 0 <0.000010>`)
+	writer.Close()
+	manifest := &kstrace.Manifest{Encryption: kstrace.EncryptionAge, Pods: []kstrace.TraceResult{{
+		Pod: "web-1", Containers: []kstrace.ContainerResult{{Name: "app", LogFiles: []string{name}}},
+	}}}
+	if err := kstrace.WriteManifest(source, manifest); err != nil {
+		t.Fatalf("Unable to write the manifest. %v", err)
+	}
+
+	countCalls := func(collection *Collection) (int, error) {
+		calls := 0
+		err := collection.Containers[0].Read(func(event *strace.Event) error {
+			calls++
+			return nil
+		})
+		return calls, err
+	}
+
+	collection, err := LoadCollection(source)
+	if err != nil {
+		t.Fatalf("Unable to load the collection. %v", err)
+	}
+	if _, err := countCalls(collection); err == nil {
+		t.Errorf("Expected the collection to be unreadable without an identity")
+	}
+	collection.Decrypt(identities)
+	if calls, err := countCalls(collection); err != nil || calls != 1 {
+		t.Errorf("Unable to read the encrypted collection. Calls: %d, %v", calls, err)
+	}
+
+	target := filepath.Join(t.TempDir(), "decrypted")
+	if err := DecryptCollection(collection, target, identities); err != nil {
+		t.Fatalf("Unable to decrypt the collection. %v", err)
+	}
+	decrypted, err := LoadCollection(target)
+	if err != nil {
+		t.Fatalf("Unable to load the decrypted collection. %v", err)
+	}
+	if decrypted.Manifest.Encryption != kstrace.EncryptionNone || decrypted.Manifest.Pods[0].Containers[0].LogFiles[0] != "web-1/app_strace.log.zst" {
+		t.Errorf("Expected the manifest to describe the decrypted logs. Got: %+v", decrypted.Manifest)
+	}
+	if calls, err := countCalls(decrypted); err != nil || calls != 1 {
+		t.Errorf("Unable to read the decrypted collection. Calls: %d, %v", calls, err)
+	}
+}
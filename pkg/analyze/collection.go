@@ -0,0 +1,376 @@
+package analyze
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+// Matches container logs, including rotated segments and compressed or encrypted streams
+var logFilePattern = regexp.MustCompile(`^(.+)_strace\.log(?:\.(\d+))?(\.gz|\.zst)?(\.age|\.gpg)?$`)
+
+// ContainerLog is the strace output of a single container in a collection
+type ContainerLog struct {
+	Pod       string
+	Container string
+	StartTime time.Time
+	// Files are the segments of the log in the order they were written
+	Files []string
+
+	identities *kstrace.Identities
+}
+
+// Collection is a strace collection loaded from disk
+type Collection struct {
+	Root       string
+	Manifest   *kstrace.Manifest
+	Containers []ContainerLog
+
+	cleanup func()
+}
+
+// LoadCollection reads a collection directory, a bundled collection archive or a single log file.
+// Collections with a manifest are read using the files it records, otherwise the directory is
+// searched for strace logs.
+func LoadCollection(path string) (*Collection, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		if isArchive(path) {
+			return loadArchive(path)
+		}
+		return &Collection{
+			Root:       filepath.Dir(path),
+			Containers: []ContainerLog{{Container: filepath.Base(path), StartTime: info.ModTime(), Files: []string{path}}},
+		}, nil
+	}
+
+	collection := &Collection{Root: path}
+	manifest, err := kstrace.ReadManifest(path)
+	switch {
+	case err == nil && manifest.Kind == kstrace.ManifestKindPod:
+		// A single Pod directory from within a collection
+		pod, err := kstrace.ReadPodManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		collection.Containers = containersFromPod(path, pod)
+	case err == nil && len(manifest.Pods) > 0:
+		collection.Manifest = manifest
+		collection.Containers = containersFromManifest(path, manifest)
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	default:
+		// Pod manifests written before they recorded their kind have no Pods
+		log.Debugf("No collection manifest found in %q. Searching for strace logs.", path)
+		collection.Containers, err = containersFromDirectory(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(collection.Containers) == 0 {
+		return nil, fmt.Errorf("no strace logs found in %q", path)
+	}
+	return collection, nil
+}
+
+func containersFromManifest(root string, manifest *kstrace.Manifest) []ContainerLog {
+	containers := []ContainerLog{}
+	for _, pod := range manifest.Pods {
+		containers = append(containers, podContainers(pod, func(file string) string {
+			return filepath.Join(root, file)
+		})...)
+	}
+	return containers
+}
+
+// containersFromPod finds the logs listed in the manifest of a Pod directory. The log files are named
+// relative to the collection, so the Pod folder is removed from each name.
+func containersFromPod(root string, pod *kstrace.TraceResult) []ContainerLog {
+	return podContainers(*pod, func(file string) string {
+		return filepath.Join(root, strings.TrimPrefix(filepath.ToSlash(file), pod.Pod+"/"))
+	})
+}
+
+func podContainers(pod kstrace.TraceResult, resolve func(file string) string) []ContainerLog {
+	containers := []ContainerLog{}
+	for _, container := range pod.Containers {
+		containerLog := ContainerLog{Pod: pod.Pod, Container: container.Name, StartTime: container.StartTime}
+		for _, file := range container.LogFiles {
+			containerLog.Files = append(containerLog.Files, resolve(file))
+		}
+		if len(containerLog.Files) > 0 {
+			containers = append(containers, containerLog)
+		}
+	}
+	return containers
+}
+
+func containersFromDirectory(root string) ([]ContainerLog, error) {
+	type segment struct {
+		path  string
+		index int
+	}
+	segments := map[[2]string][]segment{}
+	startTimes := map[[2]string]time.Time{}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		match := logFilePattern.FindStringSubmatch(info.Name())
+		if match == nil {
+			return nil
+		}
+
+		pod, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if pod == "." {
+			pod = ""
+		}
+		key := [2]string{pod, match[1]}
+		index, _ := strconv.Atoi(match[2])
+		segments[key] = append(segments[key], segment{path: path, index: index})
+
+		if startTime, ok := startTimes[key]; !ok || info.ModTime().Before(startTime) {
+			startTimes[key] = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []ContainerLog{}
+	for key, files := range segments {
+		sort.Slice(files, func(i, j int) bool { return files[i].index < files[j].index })
+
+		containerLog := ContainerLog{Pod: key[0], Container: key[1], StartTime: startTimes[key]}
+		for _, file := range files {
+			containerLog.Files = append(containerLog.Files, file.path)
+		}
+		containers = append(containers, containerLog)
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Pod != containers[j].Pod {
+			return containers[i].Pod < containers[j].Pod
+		}
+		return containers[i].Container < containers[j].Container
+	})
+	return containers, nil
+}
+
+// Decrypt sets the private keys used to read encrypted logs
+func (collection *Collection) Decrypt(identities *kstrace.Identities) {
+	for index := range collection.Containers {
+		collection.Containers[index].identities = identities
+	}
+}
+
+// Close removes any temporary files created while loading the collection
+func (collection *Collection) Close() {
+	if collection.cleanup != nil {
+		collection.cleanup()
+	}
+}
+
+// Open returns a reader over every segment of the container's log, decompressing as required
+func (containerLog ContainerLog) Open() (io.ReadCloser, error) {
+	readers := []io.Reader{}
+	closers := []func(){}
+	closeAll := func() {
+		for _, closeFunc := range closers {
+			closeFunc()
+		}
+	}
+
+	for _, path := range containerLog.Files {
+		file, err := os.Open(path)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		closers = append(closers, func() { file.Close() })
+
+		// Streams are compressed before they are encrypted
+		var plainText io.Reader = file
+		encryption := kstrace.EncryptionOf(path)
+		if encryption != kstrace.EncryptionNone {
+			plainText, err = containerLog.identities.Decrypt(file, encryption)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("unable to decrypt %q. %v", path, err)
+			}
+		}
+
+		reader, closeReader, err := decompress(strings.TrimSuffix(path, encryption.Extension()), plainText)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		readers = append(readers, reader)
+		closers = append(closers, closeReader)
+	}
+
+	return &multiReadCloser{Reader: io.MultiReader(readers...), close: closeAll}, nil
+}
+
+// Read parses every system call in the container's log
+func (containerLog ContainerLog) Read(fn func(*strace.Event) error) error {
+	reader, err := containerLog.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return strace.NewParser(containerLog.StartTime).Read(reader, fn)
+}
+
+// Name identifies the container within the collection
+func (containerLog ContainerLog) Name() string {
+	if containerLog.Pod == "" {
+		return containerLog.Container
+	}
+	return fmt.Sprintf("%s/%s", containerLog.Pod, containerLog.Container)
+}
+
+type multiReadCloser struct {
+	io.Reader
+	close func()
+}
+
+func (reader *multiReadCloser) Close() error {
+	reader.close()
+	return nil
+}
+
+func decompress(path string, reader io.Reader) (io.Reader, func(), error) {
+	switch {
+	case strings.HasSuffix(path, kstrace.CompressionGzip.Extension()):
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decompressor, func() { decompressor.Close() }, nil
+	case strings.HasSuffix(path, kstrace.CompressionZstd.Extension()):
+		decompressor, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decompressor, decompressor.Close, nil
+	}
+	return reader, func() {}, nil
+}
+
+func isArchive(path string) bool {
+	for _, compression := range append(kstrace.Compressions, kstrace.CompressionNone) {
+		if strings.HasSuffix(path, ".tar"+compression.Extension()) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadArchive extracts a bundled collection into a temporary directory
+func loadArchive(path string) (*Collection, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, closeReader, err := decompress(path, file)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader()
+
+	directory, err := os.MkdirTemp("", "kstrace-")
+	if err != nil {
+		return nil, err
+	}
+	removeDirectory := func() { os.RemoveAll(directory) }
+
+	root := ""
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			removeDirectory()
+			return nil, err
+		}
+
+		// Guard against entries that would be written outside of the temporary directory
+		target := filepath.Join(directory, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, directory+string(os.PathSeparator)) {
+			removeDirectory()
+			return nil, fmt.Errorf("invalid path %q in archive %q", header.Name, path)
+		}
+		if root == "" {
+			root = filepath.Join(directory, strings.SplitN(filepath.ToSlash(header.Name), "/", 2)[0])
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0775); err != nil {
+				removeDirectory()
+				return nil, err
+			}
+			continue
+		}
+		if err := extractFile(archive, target); err != nil {
+			removeDirectory()
+			return nil, err
+		}
+	}
+
+	if root == "" {
+		removeDirectory()
+		return nil, fmt.Errorf("the archive %q is empty", path)
+	}
+
+	collection, err := LoadCollection(root)
+	if err != nil {
+		removeDirectory()
+		return nil, err
+	}
+	collection.cleanup = removeDirectory
+	return collection, nil
+}
+
+func extractFile(reader io.Reader, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0775); err != nil {
+		return err
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return err
+}
@@ -0,0 +1,40 @@
+package analyze
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+// DecryptCollection writes a copy of the collection to the given directory with every encrypted log
+// decrypted. Logs keep their compression and the manifest is updated to match the new names.
+func DecryptCollection(collection *Collection, directory string, identities *kstrace.Identities) error {
+	stripEncryption := func(name string) string {
+		return strings.TrimSuffix(name, kstrace.EncryptionOf(name).Extension())
+	}
+
+	manifest, err := rewriteCollection(collection, directory, stripEncryption, func(containerLog ContainerLog, source string, target string) error {
+		input, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		plainText, err := identities.Decrypt(input, kstrace.EncryptionOf(source))
+		if err != nil {
+			return err
+		}
+		return createFile(target, func(output io.Writer) error {
+			_, err := io.Copy(output, plainText)
+			return err
+		})
+	})
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	manifest.Encryption = kstrace.EncryptionNone
+	return kstrace.WriteManifest(directory, manifest)
+}
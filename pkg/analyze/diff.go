@@ -0,0 +1,377 @@
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ChangeNew     = "new"
+	ChangeMissing = "missing"
+)
+
+// Process specific paths are compared without the PID so restarts do not show up as changes
+var procPIDPattern = regexp.MustCompile(`^/proc/\d+(/|$)`)
+
+// SyscallDiff compares a single system call between two collections. Rates are only set when both
+// collections recorded timestamps, otherwise the change is calculated from the call counts.
+type SyscallDiff struct {
+	Name         string        `json:"name"`
+	Change       string        `json:"change,omitempty"`
+	BeforeCount  int64         `json:"beforeCount"`
+	AfterCount   int64         `json:"afterCount"`
+	BeforeRate   float64       `json:"beforeRate,omitempty"`
+	AfterRate    float64       `json:"afterRate,omitempty"`
+	Ratio        float64       `json:"ratio,omitempty"`
+	BeforeP50    time.Duration `json:"beforeP50"`
+	AfterP50     time.Duration `json:"afterP50"`
+	BeforeP99    time.Duration `json:"beforeP99"`
+	AfterP99     time.Duration `json:"afterP99"`
+	BeforeErrors int64         `json:"beforeErrors"`
+	AfterErrors  int64         `json:"afterErrors"`
+}
+
+// ErrorDiff is an error returned by a system call in the second collection but never in the first
+type ErrorDiff struct {
+	Syscall string `json:"syscall"`
+	Errno   string `json:"errno"`
+	Count   int64  `json:"count"`
+}
+
+// ContainerDiff compares a container across two collections. Containers are matched by name, so replicas
+// and recreated pods of the same workload are combined.
+type ContainerDiff struct {
+	Container   string   `json:"container"`
+	Change      string   `json:"change,omitempty"`
+	BeforePods  []string `json:"beforePods"`
+	AfterPods   []string `json:"afterPods"`
+	BeforeCalls int64    `json:"beforeCalls"`
+	AfterCalls  int64    `json:"afterCalls"`
+	// Timed is set when frequencies are compared as rates rather than counts
+	Timed        bool          `json:"timed"`
+	Syscalls     []SyscallDiff `json:"syscalls"`
+	NewErrors    []ErrorDiff   `json:"newErrors"`
+	NewPaths     []string      `json:"newPaths"`
+	MissingPaths []string      `json:"missingPaths"`
+	NewPeers     []string      `json:"newPeers"`
+	MissingPeers []string      `json:"missingPeers"`
+}
+
+// Diff is the result of comparing two collections
+type Diff struct {
+	Containers []ContainerDiff `json:"containers"`
+}
+
+// profile combines everything recorded for the containers sharing a name in one collection
+type profile struct {
+	pods     []string
+	calls    int64
+	timed    bool
+	syscalls map[string]*SyscallSummary
+	rates    map[string]float64
+	errors   map[[2]string]int64
+	paths    map[string]bool
+	peers    map[string]bool
+}
+
+func newProfile() *profile {
+	return &profile{
+		timed:    true,
+		syscalls: map[string]*SyscallSummary{},
+		rates:    map[string]float64{},
+		errors:   map[[2]string]int64{},
+		paths:    map[string]bool{},
+		peers:    map[string]bool{},
+	}
+}
+
+func profileCollection(collection *Collection) (map[string]*profile, error) {
+	summary, err := Summarise(collection, Options{})
+	if err != nil {
+		return nil, err
+	}
+	files, err := ReportFiles(collection)
+	if err != nil {
+		return nil, err
+	}
+	network, err := ReportNetwork(collection, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := map[string]*profile{}
+	get := func(container string) *profile {
+		if _, ok := profiles[container]; !ok {
+			profiles[container] = newProfile()
+		}
+		return profiles[container]
+	}
+
+	for _, container := range summary.Containers {
+		profile := get(container.Container)
+		profile.pods = append(profile.pods, container.Pod)
+		profile.calls += container.Calls
+		profile.timed = profile.timed && container.Duration > 0
+
+		for _, syscall := range container.Syscalls {
+			merged, ok := profile.syscalls[syscall.Name]
+			if !ok {
+				merged = &SyscallSummary{Name: syscall.Name, Latency: NewHistogram()}
+				profile.syscalls[syscall.Name] = merged
+			}
+			merged.Count += syscall.Count
+			merged.Errors += syscall.Errors
+			merged.Latency.Merge(syscall.Latency)
+
+			// Replicas run side by side, so their rates add up
+			if container.Duration > 0 {
+				profile.rates[syscall.Name] += float64(syscall.Count) / container.Duration.Seconds()
+			}
+			for errno, count := range syscall.Errnos {
+				profile.errors[[2]string{syscall.Name, errno}] += count
+			}
+		}
+	}
+	for _, container := range files.Containers {
+		profile := get(container.Container)
+		for _, file := range container.Files {
+			profile.paths[procPIDPattern.ReplaceAllString(file.Path, "/proc/<pid>$1")] = true
+		}
+	}
+	for _, container := range network.Containers {
+		profile := get(container.Container)
+		for _, peer := range container.Peers {
+			profile.peers[strings.TrimSpace(strings.Join([]string{peer.Direction, peer.Protocol, peer.Endpoint()}, " "))] = true
+		}
+	}
+	return profiles, nil
+}
+
+// DiffCollections compares the system call frequency and latency, errors, file paths and network peers of
+// the containers in two collections of the same workload
+func DiffCollections(before *Collection, after *Collection) (*Diff, error) {
+	beforeProfiles, err := profileCollection(before)
+	if err != nil {
+		return nil, err
+	}
+	afterProfiles, err := profileCollection(after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range beforeProfiles {
+		names[name] = true
+	}
+	for name := range afterProfiles {
+		names[name] = true
+	}
+
+	diff := &Diff{Containers: []ContainerDiff{}}
+	for name := range names {
+		diff.Containers = append(diff.Containers, diffContainer(name, beforeProfiles[name], afterProfiles[name]))
+	}
+	sort.Slice(diff.Containers, func(i, j int) bool {
+		return diff.Containers[i].Container < diff.Containers[j].Container
+	})
+	return diff, nil
+}
+
+func diffContainer(name string, before *profile, after *profile) ContainerDiff {
+	container := ContainerDiff{Container: name}
+	switch {
+	case before == nil:
+		container.Change = ChangeNew
+		before = newProfile()
+	case after == nil:
+		container.Change = ChangeMissing
+		after = newProfile()
+	}
+
+	container.BeforePods, container.AfterPods = before.pods, after.pods
+	container.BeforeCalls, container.AfterCalls = before.calls, after.calls
+	timed := before.timed && after.timed && len(before.pods) > 0 && len(after.pods) > 0
+	container.Timed = timed
+
+	// System calls
+	container.Syscalls = []SyscallDiff{}
+	syscalls := map[string]bool{}
+	for syscall := range before.syscalls {
+		syscalls[syscall] = true
+	}
+	for syscall := range after.syscalls {
+		syscalls[syscall] = true
+	}
+	for syscall := range syscalls {
+		container.Syscalls = append(container.Syscalls, diffSyscall(syscall, before, after, timed))
+	}
+	sort.Slice(container.Syscalls, func(i, j int) bool {
+		left, right := container.Syscalls[i], container.Syscalls[j]
+		if left.Change != right.Change {
+			return left.Change > right.Change
+		}
+		leftDelta, rightDelta := math.Abs(float64(left.AfterCount-left.BeforeCount)), math.Abs(float64(right.AfterCount-right.BeforeCount))
+		if timed {
+			leftDelta, rightDelta = math.Abs(left.AfterRate-left.BeforeRate), math.Abs(right.AfterRate-right.BeforeRate)
+		}
+		if leftDelta != rightDelta {
+			return leftDelta > rightDelta
+		}
+		return left.Name < right.Name
+	})
+
+	// Errors seen for the first time
+	container.NewErrors = []ErrorDiff{}
+	for key, count := range after.errors {
+		if before.errors[key] == 0 {
+			container.NewErrors = append(container.NewErrors, ErrorDiff{Syscall: key[0], Errno: key[1], Count: count})
+		}
+	}
+	sort.Slice(container.NewErrors, func(i, j int) bool {
+		left, right := container.NewErrors[i], container.NewErrors[j]
+		if left.Count != right.Count {
+			return left.Count > right.Count
+		}
+		return left.Syscall+left.Errno < right.Syscall+right.Errno
+	})
+
+	container.NewPaths, container.MissingPaths = compareSets(before.paths, after.paths)
+	container.NewPeers, container.MissingPeers = compareSets(before.peers, after.peers)
+	return container
+}
+
+func diffSyscall(name string, before *profile, after *profile, timed bool) SyscallDiff {
+	syscall := SyscallDiff{Name: name}
+	if summary, ok := before.syscalls[name]; ok {
+		syscall.BeforeCount, syscall.BeforeErrors = summary.Count, summary.Errors
+		syscall.BeforeP50, syscall.BeforeP99 = summary.Latency.Quantile(0.50), summary.Latency.Quantile(0.99)
+	}
+	if summary, ok := after.syscalls[name]; ok {
+		syscall.AfterCount, syscall.AfterErrors = summary.Count, summary.Errors
+		syscall.AfterP50, syscall.AfterP99 = summary.Latency.Quantile(0.50), summary.Latency.Quantile(0.99)
+	}
+
+	beforeValue, afterValue := float64(syscall.BeforeCount), float64(syscall.AfterCount)
+	if timed {
+		syscall.BeforeRate, syscall.AfterRate = before.rates[name], after.rates[name]
+		beforeValue, afterValue = syscall.BeforeRate, syscall.AfterRate
+	}
+
+	switch {
+	case syscall.BeforeCount == 0:
+		syscall.Change = ChangeNew
+	case syscall.AfterCount == 0:
+		syscall.Change = ChangeMissing
+	default:
+		syscall.Ratio = afterValue / beforeValue
+	}
+	return syscall
+}
+
+// compareSets returns the sorted keys only present in after, and those only present in before
+func compareSets(before map[string]bool, after map[string]bool) ([]string, []string) {
+	added, removed := []string{}, []string{}
+	for key := range after {
+		if !before[key] {
+			added = append(added, key)
+		}
+	}
+	for key := range before {
+		if !after[key] {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func formatRatio(syscall SyscallDiff) string {
+	if syscall.Change != "" {
+		return syscall.Change
+	}
+	return fmt.Sprintf("%+.0f%%", (syscall.Ratio-1)*100)
+}
+
+// WriteDiff renders the diff in the requested format. Tables are limited to the given number of rows,
+// a limit of zero shows every row.
+func WriteDiff(writer io.Writer, diff *Diff, format Format, limit int) error {
+	if format == FormatJSON {
+		return writeJSON(writer, diff)
+	}
+
+	// Truncated rows are summarised in a final row so nothing disappears silently
+	limited := func(section table) table {
+		if limit > 0 && len(section.rows) > limit {
+			more := make([]string, len(section.headers))
+			more[0] = fmt.Sprintf("... %d more", len(section.rows)-limit)
+			section.rows = append(section.rows[:limit], more)
+		}
+		return section
+	}
+
+	tables := []table{}
+	for _, container := range diff.Containers {
+		name := container.Container
+		if container.Change != "" {
+			name = fmt.Sprintf("%s (%s)", name, container.Change)
+		}
+
+		frequency := func(count int64, rate float64) string {
+			if container.Timed {
+				return fmt.Sprintf("%.1f/s", rate)
+			}
+			return fmt.Sprint(count)
+		}
+
+		syscalls := table{
+			title: fmt.Sprintf("%s: %d -> %d system calls from pods %v -> %v",
+				name, container.BeforeCalls, container.AfterCalls, container.BeforePods, container.AfterPods),
+			headers: []string{"SYSCALL", "BEFORE", "AFTER", "CHANGE", "P50 BEFORE", "P50 AFTER", "P99 BEFORE", "P99 AFTER", "ERRORS BEFORE", "ERRORS AFTER"},
+		}
+		for _, syscall := range container.Syscalls {
+			syscalls.rows = append(syscalls.rows, []string{
+				syscall.Name, frequency(syscall.BeforeCount, syscall.BeforeRate), frequency(syscall.AfterCount, syscall.AfterRate), formatRatio(syscall),
+				formatDuration(syscall.BeforeP50), formatDuration(syscall.AfterP50), formatDuration(syscall.BeforeP99), formatDuration(syscall.AfterP99),
+				fmt.Sprint(syscall.BeforeErrors), fmt.Sprint(syscall.AfterErrors),
+			})
+		}
+		tables = append(tables, limited(syscalls))
+
+		if len(container.NewErrors) > 0 {
+			errors := table{title: fmt.Sprintf("%s: new errors", name), headers: []string{"SYSCALL", "ERRNO", "COUNT"}}
+			for _, newError := range container.NewErrors {
+				errors.rows = append(errors.rows, []string{newError.Syscall, newError.Errno, fmt.Sprint(newError.Count)})
+			}
+			tables = append(tables, limited(errors))
+		}
+
+		if len(container.NewPaths)+len(container.MissingPaths) > 0 {
+			paths := table{title: fmt.Sprintf("%s: file paths", name), headers: []string{"CHANGE", "PATH"}}
+			for _, path := range container.NewPaths {
+				paths.rows = append(paths.rows, []string{ChangeNew, path})
+			}
+			for _, path := range container.MissingPaths {
+				paths.rows = append(paths.rows, []string{ChangeMissing, path})
+			}
+			tables = append(tables, limited(paths))
+		}
+
+		if len(container.NewPeers)+len(container.MissingPeers) > 0 {
+			peers := table{title: fmt.Sprintf("%s: network peers", name), headers: []string{"CHANGE", "PEER"}}
+			for _, peer := range container.NewPeers {
+				peers.rows = append(peers.rows, []string{ChangeNew, peer})
+			}
+			for _, peer := range container.MissingPeers {
+				peers.rows = append(peers.rows, []string{ChangeMissing, peer})
+			}
+			tables = append(tables, limited(peers))
+		}
+	}
+	return writeTables(writer, format, tables)
+}
@@ -0,0 +1,224 @@
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+// pathArguments is the index of the path argument for system calls that access files by name
+var pathArguments = map[string]int{
+	"open": 0, "creat": 0, "openat": 1, "openat2": 1,
+	"stat": 0, "lstat": 0, "stat64": 0, "lstat64": 0, "newfstatat": 1, "fstatat64": 1, "statx": 1,
+	"access": 0, "faccessat": 1, "faccessat2": 1,
+	"execve": 0, "execveat": 1,
+	"readlink": 0, "readlinkat": 1,
+}
+
+// Errors that usually point at a missing mount or a permission problem
+var highlightedErrnos = map[string]bool{"ENOENT": true, "EACCES": true}
+
+// FileAccess aggregates every access of a single path
+type FileAccess struct {
+	Path       string           `json:"path"`
+	Count      int64            `json:"count"`
+	Operations map[string]int64 `json:"operations"`
+	// Results counts each outcome, either OK or the errno returned
+	Results map[string]int64 `json:"results"`
+	// Mount is the volume mount from the Pod spec that contains the path
+	Mount *kstrace.Mount `json:"mount,omitempty"`
+}
+
+// Highlighted reports whether any access failed with an error that usually points at a misconfiguration
+func (access *FileAccess) Highlighted() bool {
+	for result := range access.Results {
+		if highlightedErrnos[result] {
+			return true
+		}
+	}
+	return false
+}
+
+type ContainerFiles struct {
+	Pod       string          `json:"pod"`
+	Container string          `json:"container"`
+	Mounts    []kstrace.Mount `json:"mounts"`
+	Files     []*FileAccess   `json:"files"`
+}
+
+type FileReport struct {
+	Containers []ContainerFiles `json:"containers"`
+}
+
+// FileAccessPath returns the path accessed by a file system call, or false if the event is not one
+func FileAccessPath(event *strace.Event) (string, bool) {
+	index, ok := pathArguments[event.Syscall]
+	if !ok {
+		return "", false
+	}
+
+	args := strace.SplitArgs(event.Args)
+	if index >= len(args) {
+		return "", false
+	}
+	filePath, ok, _ := strace.Unquote(args[index])
+	if !ok {
+		return "", false
+	}
+
+	// Resolve relative paths against a directory file descriptor decorated by `-y`
+	if index > 0 && !path.IsAbs(filePath) {
+		if directory := strace.Decoration(args[0]); path.IsAbs(directory) {
+			filePath = path.Join(directory, filePath)
+		}
+	}
+	return filePath, true
+}
+
+// findMount returns the mount with the longest path containing the file
+func findMount(mounts []kstrace.Mount, filePath string) *kstrace.Mount {
+	var found *kstrace.Mount
+	for index := range mounts {
+		mount := &mounts[index]
+		mountPath := strings.TrimSuffix(mount.MountPath, "/")
+		if filePath != mountPath && !strings.HasPrefix(filePath, mountPath+"/") {
+			continue
+		}
+		if found == nil || len(mount.MountPath) > len(found.MountPath) {
+			found = mount
+		}
+	}
+	return found
+}
+
+// containerMounts looks up the volume mounts recorded in the manifest for the container
+func (collection *Collection) containerMounts(containerLog ContainerLog) []kstrace.Mount {
+	if collection.Manifest == nil {
+		return nil
+	}
+	for _, pod := range collection.Manifest.Pods {
+		if pod.Pod != containerLog.Pod {
+			continue
+		}
+		for _, container := range pod.Containers {
+			if container.Name == containerLog.Container {
+				return container.Mounts
+			}
+		}
+	}
+	return nil
+}
+
+// ReportFiles lists every path accessed by each container in the collection
+func ReportFiles(collection *Collection) (*FileReport, error) {
+	report := &FileReport{Containers: []ContainerFiles{}}
+
+	for _, containerLog := range collection.Containers {
+		mounts := collection.containerMounts(containerLog)
+		files := map[string]*FileAccess{}
+
+		err := containerLog.Read(func(event *strace.Event) error {
+			filePath, ok := FileAccessPath(event)
+			if !ok {
+				return nil
+			}
+
+			access, ok := files[filePath]
+			if !ok {
+				access = &FileAccess{
+					Path:       filePath,
+					Operations: map[string]int64{},
+					Results:    map[string]int64{},
+					Mount:      findMount(mounts, filePath),
+				}
+				files[filePath] = access
+			}
+
+			access.Count++
+			access.Operations[event.Syscall]++
+			if event.Failed() {
+				access.Results[event.Errno]++
+			} else {
+				access.Results["OK"]++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		containerFiles := ContainerFiles{Pod: containerLog.Pod, Container: containerLog.Container, Mounts: mounts, Files: []*FileAccess{}}
+		for _, access := range files {
+			containerFiles.Files = append(containerFiles.Files, access)
+		}
+
+		// Highlighted failures first, then the most accessed paths
+		sort.Slice(containerFiles.Files, func(i, j int) bool {
+			left, right := containerFiles.Files[i], containerFiles.Files[j]
+			if left.Highlighted() != right.Highlighted() {
+				return left.Highlighted()
+			}
+			if left.Count != right.Count {
+				return left.Count > right.Count
+			}
+			return left.Path < right.Path
+		})
+		report.Containers = append(report.Containers, containerFiles)
+	}
+	return report, nil
+}
+
+func formatCounts(counts map[string]int64, highlight func(string) string) string {
+	formatted := []string{}
+	for _, key := range sortedKeys(counts) {
+		formatted = append(formatted, fmt.Sprintf("%s:%d", highlight(key), counts[key]))
+	}
+	return strings.Join(formatted, " ")
+}
+
+// WriteFileReport renders the file report in the requested format
+func WriteFileReport(writer io.Writer, report *FileReport, format Format) error {
+	if format == FormatJSON {
+		return writeJSON(writer, report)
+	}
+
+	highlight := func(result string) string {
+		if !highlightedErrnos[result] {
+			return result
+		}
+		if format == FormatMarkdown {
+			return "**" + result + "**"
+		}
+		return "!" + result
+	}
+
+	tables := []table{}
+	for _, container := range report.Containers {
+		name := container.Container
+		if container.Pod != "" {
+			name = container.Pod + "/" + container.Container
+		}
+
+		files := table{
+			title:   fmt.Sprintf("%s: %d paths accessed", name, len(container.Files)),
+			headers: []string{"PATH", "COUNT", "OPERATIONS", "RESULTS", "VOLUME"},
+		}
+		for _, access := range container.Files {
+			volume := ""
+			if access.Mount != nil {
+				volume = fmt.Sprintf("%s (%s)", access.Mount.Volume, access.Mount.Source)
+			}
+			files.rows = append(files.rows, []string{
+				access.Path, fmt.Sprint(access.Count), formatCounts(access.Operations, func(key string) string { return key }),
+				formatCounts(access.Results, highlight), volume,
+			})
+		}
+		tables = append(tables, files)
+	}
+	return writeTables(writer, format, tables)
+}
@@ -0,0 +1,208 @@
+package analyze
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+type Weight string
+
+const (
+	// WeightCount weights each stack by the number of system calls
+	WeightCount Weight = "count"
+	// WeightTime weights each stack by the time spent in system calls, in microseconds
+	WeightTime Weight = "time"
+)
+
+var Weights = []Weight{WeightCount, WeightTime}
+
+func ParseWeight(value string) (Weight, error) {
+	for _, weight := range Weights {
+		if value == string(weight) {
+			return weight, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported weight %q. available options are %v", value, Weights)
+}
+
+// FlameGraph holds the folded stacks of a single container
+type FlameGraph struct {
+	Pod       string
+	Container string
+	Weight    Weight
+	// Stacks maps each folded stack, outermost frame first and ending with the system call, to its weight
+	Stacks map[string]int64
+	// Unwound is the number of system calls that had a stack trace
+	Unwound int64
+}
+
+// FoldStacks folds the stack traces of every system call in a container's log. System calls without a
+// stack trace are folded under a single [unknown] frame.
+func FoldStacks(containerLog ContainerLog, weight Weight) (*FlameGraph, error) {
+	graph := &FlameGraph{Pod: containerLog.Pod, Container: containerLog.Container, Weight: weight, Stacks: map[string]int64{}}
+
+	err := containerLog.Read(func(event *strace.Event) error {
+		frames := []string{}
+		for index := len(event.Stack) - 1; index >= 0; index-- {
+			frames = append(frames, foldedFrame(strace.ParseFrame(event.Stack[index]).Name()))
+		}
+		if len(frames) == 0 {
+			frames = append(frames, "[unknown]")
+		} else {
+			graph.Unwound++
+		}
+		frames = append(frames, event.Syscall)
+
+		value := int64(1)
+		if weight == WeightTime {
+			value = event.Duration.Microseconds()
+		}
+		if value > 0 {
+			graph.Stacks[strings.Join(frames, ";")] += value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// Semicolons separate frames and spaces separate the weight in the folded format
+func foldedFrame(name string) string {
+	return strings.NewReplacer(";", ":", " ", "_").Replace(name)
+}
+
+// WriteFolded writes the stacks in the folded format used by flamegraph.pl and compatible tools
+func (graph *FlameGraph) WriteFolded(writer io.Writer) error {
+	stacks := make([]string, 0, len(graph.Stacks))
+	for stack := range graph.Stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(writer, "%s %d\n", stack, graph.Stacks[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type flameNode struct {
+	name     string
+	value    int64
+	children map[string]*flameNode
+}
+
+func (node *flameNode) child(name string) *flameNode {
+	child, ok := node.children[name]
+	if !ok {
+		child = &flameNode{name: name, children: map[string]*flameNode{}}
+		node.children[name] = child
+	}
+	return child
+}
+
+func (node *flameNode) depth() int {
+	depth := 0
+	for _, child := range node.children {
+		if childDepth := child.depth() + 1; childDepth > depth {
+			depth = childDepth
+		}
+	}
+	return depth
+}
+
+const (
+	flameWidth       = 1200.0
+	flameFrameHeight = 16.0
+	flamePadding     = 10.0
+	flameTitleHeight = 40.0
+	flameCharWidth   = 7.0
+)
+
+// WriteSVG renders the stacks as a flame graph with the outermost frames at the bottom
+func (graph *FlameGraph) WriteSVG(writer io.Writer) error {
+	root := &flameNode{name: "all", children: map[string]*flameNode{}}
+	for stack, value := range graph.Stacks {
+		root.value += value
+		node := root
+		for _, frame := range strings.Split(stack, ";") {
+			node = node.child(frame)
+			node.value += value
+		}
+	}
+
+	depth := root.depth() + 1
+	height := flameTitleHeight + float64(depth)*flameFrameHeight + flamePadding
+	name := graph.Container
+	if graph.Pod != "" {
+		name = graph.Pod + "/" + graph.Container
+	}
+	unit := "samples"
+	if graph.Weight == WeightTime {
+		unit = "µs"
+	}
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, `<?xml version="1.0" standalone="no"?>`+"\n")
+	fmt.Fprintf(builder, `<svg version="1.1" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" xmlns="http://www.w3.org/2000/svg">`+"\n",
+		flameWidth, height, flameWidth, height)
+	fmt.Fprintf(builder, `<rect x="0" y="0" width="100%%" height="100%%" fill="#f8f8f8"/>`+"\n")
+	fmt.Fprintf(builder, `<text x="%.0f" y="24" font-size="17" font-family="Verdana" text-anchor="middle">%s</text>`+"\n",
+		flameWidth/2, html.EscapeString(fmt.Sprintf("%s system calls by %s", name, graph.Weight)))
+
+	if root.value > 0 {
+		scale := (flameWidth - 2*flamePadding) / float64(root.value)
+		var draw func(node *flameNode, x float64, level int)
+		draw = func(node *flameNode, x float64, level int) {
+			width := float64(node.value) * scale
+			// Frames too narrow to see are skipped along with their children
+			if width < 0.1 {
+				return
+			}
+
+			y := height - flamePadding - float64(level+1)*flameFrameHeight
+			label := html.EscapeString(node.name)
+			fmt.Fprintf(builder, `<g><title>%s (%d %s, %.2f%%)</title>`, label, node.value, unit, 100*float64(node.value)/float64(root.value))
+			fmt.Fprintf(builder, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.0f" fill="%s" rx="2" ry="2"/>`, x, y, width, flameFrameHeight-1, flameColour(node.name))
+			if chars := int(width / flameCharWidth); chars >= 3 {
+				fmt.Fprintf(builder, `<text x="%.1f" y="%.1f" font-size="12" font-family="Verdana">%s</text>`,
+					x+3, y+flameFrameHeight-4, html.EscapeString(truncate(node.name, chars)))
+			}
+			fmt.Fprintln(builder, `</g>`)
+
+			// Children are ordered alphabetically, as flamegraph.pl does
+			names := make([]string, 0, len(node.children))
+			for childName := range node.children {
+				names = append(names, childName)
+			}
+			sort.Strings(names)
+			for _, childName := range names {
+				child := node.children[childName]
+				draw(child, x, level+1)
+				x += float64(child.value) * scale
+			}
+		}
+		draw(root, flamePadding, 0)
+	}
+	fmt.Fprintln(builder, `</svg>`)
+
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
+
+// flameColour picks a stable warm colour for each frame name
+func flameColour(name string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	value := hash.Sum32()
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+value%50, (value>>8)%230, (value>>16)%55)
+}
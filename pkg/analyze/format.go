@@ -0,0 +1,165 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatTable    Format = "table"
+	FormatJSON     Format = "json"
+	FormatMarkdown Format = "markdown"
+)
+
+var Formats = []Format{FormatTable, FormatJSON, FormatMarkdown}
+
+func ParseFormat(value string) (Format, error) {
+	for _, format := range Formats {
+		if value == string(format) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported format %q. available options are %v", value, Formats)
+}
+
+// table is a format independent representation of a report section
+type table struct {
+	title   string
+	headers []string
+	rows    [][]string
+}
+
+// writeTables renders tables as aligned plain text or markdown
+func writeTables(writer io.Writer, format Format, tables []table) error {
+	for index, section := range tables {
+		if index > 0 {
+			fmt.Fprintln(writer)
+		}
+
+		if format == FormatMarkdown {
+			writeMarkdownTable(writer, section)
+			continue
+		}
+
+		fmt.Fprintln(writer, section.title)
+		tabs := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tabs, strings.Join(section.headers, "\t"))
+		for _, row := range section.rows {
+			fmt.Fprintln(tabs, strings.Join(row, "\t"))
+		}
+		if err := tabs.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMarkdownTable(writer io.Writer, section table) {
+	escape := func(cells []string) string {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = strings.ReplaceAll(cell, "|", "\\|")
+		}
+		return "| " + strings.Join(escaped, " | ") + " |"
+	}
+
+	fmt.Fprintf(writer, "### %s\n\n", section.title)
+	fmt.Fprintln(writer, escape(section.headers))
+	fmt.Fprintln(writer, "|"+strings.Repeat(" --- |", len(section.headers)))
+	for _, row := range section.rows {
+		fmt.Fprintln(writer, escape(row))
+	}
+}
+
+func writeJSON(writer io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func formatDuration(duration time.Duration) string {
+	if duration == 0 {
+		return "-"
+	}
+	return duration.String()
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length-3] + "..."
+}
+
+// sortedKeys returns the keys of a count map ordered by descending count
+func sortedKeys(counts map[string]int64) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// WriteSummary renders the summary in the requested format
+func WriteSummary(writer io.Writer, summary *Summary, format Format) error {
+	if format == FormatJSON {
+		return writeJSON(writer, summary)
+	}
+
+	tables := []table{}
+	for _, container := range summary.Containers {
+		name := container.Container
+		if container.Pod != "" {
+			name = container.Pod + "/" + container.Container
+		}
+
+		syscalls := table{
+			title:   fmt.Sprintf("%s: %d system calls", name, container.Calls),
+			headers: []string{"SYSCALL", "CALLS", "ERRORS", "TOTAL", "P50", "P95", "P99", "MAX"},
+		}
+		for _, syscall := range container.Syscalls {
+			syscalls.rows = append(syscalls.rows, []string{
+				syscall.Name, fmt.Sprint(syscall.Count), fmt.Sprint(syscall.Errors), formatDuration(syscall.Total),
+				formatDuration(syscall.P50), formatDuration(syscall.P95), formatDuration(syscall.P99), formatDuration(syscall.Max),
+			})
+		}
+		tables = append(tables, syscalls)
+
+		if len(container.Errors) > 0 {
+			errors := table{title: fmt.Sprintf("%s: errors", name), headers: []string{"ERRNO", "COUNT"}}
+			for _, errno := range sortedKeys(container.Errors) {
+				errors.rows = append(errors.rows, []string{errno, fmt.Sprint(container.Errors[errno])})
+			}
+			tables = append(tables, errors)
+		}
+
+		if len(container.Slowest) > 0 {
+			slowest := table{title: fmt.Sprintf("%s: slowest calls", name), headers: []string{"TIME", "PID", "DURATION", "CALL", "RESULT"}}
+			for _, event := range container.Slowest {
+				result := event.Return
+				if event.Failed() {
+					result = fmt.Sprintf("%s %s", event.Return, event.Errno)
+				}
+				slowest.rows = append(slowest.rows, []string{
+					event.Time.Format("15:04:05.000000"), fmt.Sprint(event.PID), event.Duration.String(),
+					truncate(fmt.Sprintf("%s(%s)", event.Syscall, event.Args), 80), result,
+				})
+			}
+			tables = append(tables, slowest)
+		}
+	}
+	return writeTables(writer, format, tables)
+}
@@ -0,0 +1,109 @@
+package analyze
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Buckets grow by 2% so quantiles are accurate to within 2% using a bounded amount of memory
+const bucketGrowth = 1.02
+
+var logBucketGrowth = math.Log(bucketGrowth)
+
+// Histogram records a distribution of durations in logarithmic buckets
+type Histogram struct {
+	Buckets map[int]int64 `json:"buckets"`
+	Count   int64         `json:"count"`
+	Total   time.Duration `json:"total"`
+	Max     time.Duration `json:"max"`
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{Buckets: map[int]int64{}}
+}
+
+func bucketFor(duration time.Duration) int {
+	if duration < time.Microsecond {
+		return 0
+	}
+	return int(math.Log(float64(duration)/float64(time.Microsecond))/logBucketGrowth) + 1
+}
+
+// bucketValue returns the upper bound of a bucket
+func bucketValue(bucket int) time.Duration {
+	if bucket == 0 {
+		return time.Microsecond
+	}
+	return time.Duration(math.Pow(bucketGrowth, float64(bucket)) * float64(time.Microsecond))
+}
+
+func (histogram *Histogram) Add(duration time.Duration) {
+	histogram.Buckets[bucketFor(duration)]++
+	histogram.Count++
+	histogram.Total += duration
+	if duration > histogram.Max {
+		histogram.Max = duration
+	}
+}
+
+// Merge adds every observation from another histogram
+func (histogram *Histogram) Merge(other *Histogram) {
+	for bucket, count := range other.Buckets {
+		histogram.Buckets[bucket] += count
+	}
+	histogram.Count += other.Count
+	histogram.Total += other.Total
+	if other.Max > histogram.Max {
+		histogram.Max = other.Max
+	}
+}
+
+// Quantile returns the duration below which the given fraction of observations fall
+func (histogram *Histogram) Quantile(quantile float64) time.Duration {
+	if histogram.Count == 0 {
+		return 0
+	}
+
+	buckets := make([]int, 0, len(histogram.Buckets))
+	for bucket := range histogram.Buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	rank := int64(math.Ceil(quantile * float64(histogram.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for _, bucket := range buckets {
+		seen += histogram.Buckets[bucket]
+		if seen >= rank {
+			value := bucketValue(bucket)
+			if value > histogram.Max {
+				return histogram.Max
+			}
+			return value
+		}
+	}
+	return histogram.Max
+}
+
+func (histogram *Histogram) Mean() time.Duration {
+	if histogram.Count == 0 {
+		return 0
+	}
+	return histogram.Total / time.Duration(histogram.Count)
+}
+
+// Distribution counts the observations up to each bound, with a final count for those above the last bound
+func (histogram *Histogram) Distribution(bounds []time.Duration) []int64 {
+	counts := make([]int64, len(bounds)+1)
+	for bucket, count := range histogram.Buckets {
+		value := bucketValue(bucket)
+		index := sort.Search(len(bounds), func(i int) bool { return value <= bounds[i] })
+		counts[index] += count
+	}
+	return counts
+}
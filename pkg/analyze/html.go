@@ -0,0 +1,163 @@
+package analyze
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+//go:embed templates/report.html
+var reportTemplate string
+
+// Latency ranges shown in the histogram of each system call
+var latencyBounds = []time.Duration{time.Microsecond, 10 * time.Microsecond, 100 * time.Microsecond, time.Millisecond,
+	10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+
+var latencyLabels = []string{"≤1µs", "≤10µs", "≤100µs", "≤1ms", "≤10ms", "≤100ms", "≤1s", ">1s"}
+
+type HTMLOptions struct {
+	// Title is shown at the top of the report
+	Title string
+	// MaxEvents is the number of events embedded per container. Zero embeds every event
+	MaxEvents int
+	// Slowest is the number of slowest calls listed per container
+	Slowest int
+}
+
+type htmlBucket struct {
+	Label  string
+	Count  int64
+	Height int
+}
+
+type htmlSyscall struct {
+	SyscallSummary
+	Share        int
+	Distribution []htmlBucket
+}
+
+type htmlContainer struct {
+	ID       string
+	Name     string
+	Summary  ContainerSummary
+	Syscalls []htmlSyscall
+	Files    []*FileAccess
+	Peers    []*Peer
+	Events   int64
+	Embedded int64
+}
+
+type htmlReport struct {
+	Title      string
+	Generated  time.Time
+	Manifest   *kstrace.Manifest
+	Containers []htmlContainer
+	// Events are stored as compact rows to keep the report small
+	Events [][]interface{}
+}
+
+// WriteHTMLReport renders a self-contained HTML report of the collection. All styles, scripts and data are
+// embedded so the report can be viewed offline.
+func WriteHTMLReport(writer io.Writer, collection *Collection, options HTMLOptions) error {
+	summary, err := Summarise(collection, Options{Slowest: options.Slowest})
+	if err != nil {
+		return err
+	}
+	files, err := ReportFiles(collection)
+	if err != nil {
+		return err
+	}
+	network, err := ReportNetwork(collection, nil)
+	if err != nil {
+		return err
+	}
+
+	report := htmlReport{
+		Title:     options.Title,
+		Generated: time.Now(),
+		Manifest:  collection.Manifest,
+		Events:    [][]interface{}{},
+	}
+
+	// Every report lists the containers in the order of the collection
+	for index, containerLog := range collection.Containers {
+		container := htmlContainer{
+			ID:      fmt.Sprintf("container-%d", index),
+			Name:    containerLog.Name(),
+			Summary: summary.Containers[index],
+			Files:   files.Containers[index].Files,
+			Peers:   network.Containers[index].Peers,
+		}
+		container.Syscalls = htmlSyscalls(container.Summary)
+
+		err := containerLog.Read(func(event *strace.Event) error {
+			container.Events++
+			if options.MaxEvents > 0 && container.Embedded >= int64(options.MaxEvents) {
+				return nil
+			}
+			container.Embedded++
+			report.Events = append(report.Events, []interface{}{
+				container.Name, event.Time.Format("15:04:05.000000"), event.PID, event.Syscall, truncate(event.Args, 1024),
+				event.Return, event.Errno, event.Duration.Microseconds(),
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		report.Containers = append(report.Containers, container)
+	}
+
+	page, err := template.New("report").Funcs(template.FuncMap{
+		"duration":    formatDuration,
+		"highlighted": func(errno string) bool { return highlightedErrnos[errno] || highlightedNetworkErrnos[errno] },
+		"timestamp": func(value time.Time) string {
+			if value.IsZero() {
+				return "-"
+			}
+			return value.Format(time.RFC3339)
+		},
+		"join": strings.Join,
+		"keys": sortedKeys,
+	}).Parse(reportTemplate)
+	if err != nil {
+		return err
+	}
+	return page.Execute(writer, report)
+}
+
+func htmlSyscalls(summary ContainerSummary) []htmlSyscall {
+	syscalls := []htmlSyscall{}
+	var busiest int64
+	for _, syscall := range summary.Syscalls {
+		if syscall.Count > busiest {
+			busiest = syscall.Count
+		}
+	}
+
+	for _, syscall := range summary.Syscalls {
+		distribution := syscall.Latency.Distribution(latencyBounds)
+		var largest int64
+		for _, count := range distribution {
+			if count > largest {
+				largest = count
+			}
+		}
+
+		buckets := make([]htmlBucket, len(distribution))
+		for index, count := range distribution {
+			buckets[index] = htmlBucket{Label: latencyLabels[index], Count: count}
+			if largest > 0 {
+				buckets[index].Height = int(count * 24 / largest)
+			}
+		}
+		syscalls = append(syscalls, htmlSyscall{SyscallSummary: syscall, Share: int(syscall.Count * 100 / busiest), Distribution: buckets})
+	}
+	return syscalls
+}
@@ -0,0 +1,275 @@
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+const (
+	DirectionOutbound = "outbound"
+	DirectionInbound  = "inbound"
+)
+
+// Errors that are part of normal non-blocking socket use rather than failures
+var nonFailureErrnos = map[string]bool{"EINPROGRESS": true, "EAGAIN": true, "EWOULDBLOCK": true, "EINTR": true}
+
+// Errors that usually point at an unreachable or unhealthy peer
+var highlightedNetworkErrnos = map[string]bool{"ECONNREFUSED": true, "ETIMEDOUT": true, "EHOSTUNREACH": true, "ENETUNREACH": true, "ECONNRESET": true}
+
+// Resolver maps a peer address to the cluster resource that owns it
+type Resolver interface {
+	Resolve(address string) string
+}
+
+// Peer aggregates the traffic between a container and a single remote endpoint. Outbound peers are
+// identified by their address and port, inbound peers by their address and the local port they reached.
+type Peer struct {
+	Direction string           `json:"direction"`
+	Protocol  string           `json:"protocol,omitempty"`
+	Address   string           `json:"address"`
+	Port      int              `json:"port,omitempty"`
+	Resource  string           `json:"resource,omitempty"`
+	Calls     int64            `json:"calls"`
+	Syscalls  map[string]int64 `json:"syscalls"`
+	Failures  map[string]int64 `json:"failures,omitempty"`
+
+	ConnectLatency *Histogram    `json:"-"`
+	ConnectP50     time.Duration `json:"connectP50,omitempty"`
+	ConnectMax     time.Duration `json:"connectMax,omitempty"`
+}
+
+// Endpoint formats the address and port of the peer
+func (peer *Peer) Endpoint() string {
+	if peer.Port == 0 {
+		return peer.Address
+	}
+	if strings.Contains(peer.Address, ":") {
+		return fmt.Sprintf("[%s]:%d", peer.Address, peer.Port)
+	}
+	return fmt.Sprintf("%s:%d", peer.Address, peer.Port)
+}
+
+func (peer *Peer) failed() int64 {
+	var failed int64
+	for _, count := range peer.Failures {
+		failed += count
+	}
+	return failed
+}
+
+type ContainerNetwork struct {
+	Pod       string  `json:"pod"`
+	Container string  `json:"container"`
+	Peers     []*Peer `json:"peers"`
+}
+
+type NetworkReport struct {
+	Containers []ContainerNetwork `json:"containers"`
+}
+
+type peerKey struct {
+	direction string
+	protocol  string
+	address   string
+	port      int
+}
+
+type networkCollector struct {
+	peers map[peerKey]*Peer
+}
+
+func (collector *networkCollector) peer(key peerKey) *Peer {
+	peer, ok := collector.peers[key]
+	if !ok {
+		peer = &Peer{
+			Direction:      key.direction,
+			Protocol:       key.protocol,
+			Address:        key.address,
+			Port:           key.port,
+			Syscalls:       map[string]int64{},
+			Failures:       map[string]int64{},
+			ConnectLatency: NewHistogram(),
+		}
+		collector.peers[key] = peer
+	}
+	return peer
+}
+
+// socketArgs returns the decoded file descriptor and address arguments of a network system call
+func socketArgs(event *strace.Event, addressIndex int) (strace.SocketDecoration, strace.Sockaddr, bool) {
+	args := strace.SplitArgs(event.Args)
+	if len(args) == 0 {
+		return strace.SocketDecoration{}, strace.Sockaddr{}, false
+	}
+
+	socket, _ := strace.ParseSocketDecoration(strace.Decoration(args[0]))
+	var sockaddr strace.Sockaddr
+	hasAddress := false
+	if addressIndex < len(args) {
+		sockaddr, hasAddress = strace.ParseSockaddr(args[addressIndex])
+	}
+	return socket, sockaddr, hasAddress
+}
+
+func (collector *networkCollector) add(event *strace.Event) {
+	var key peerKey
+
+	switch event.Syscall {
+	case "connect":
+		socket, sockaddr, ok := socketArgs(event, 1)
+		if !ok || sockaddr.Family == "AF_UNSPEC" {
+			return
+		}
+		key = peerKey{DirectionOutbound, protocolFor(socket, sockaddr), sockaddr.Address, sockaddr.Port}
+
+		peer := collector.peer(key)
+		peer.ConnectLatency.Add(event.Duration)
+		collector.record(peer, event)
+		return
+
+	case "accept", "accept4":
+		socket, sockaddr, ok := socketArgs(event, 1)
+		// The accepted connection is decorated on the returned file descriptor
+		accepted, decorated := strace.ParseSocketDecoration(strace.Decoration(event.Return))
+		localPort := 0
+		if decorated {
+			socket = accepted
+			_, localPort, _ = strace.SplitHostPort(accepted.Local)
+			if remote, _, split := strace.SplitHostPort(accepted.Remote); split && !ok {
+				sockaddr, ok = strace.Sockaddr{Address: remote}, true
+			}
+		}
+		if !ok {
+			return
+		}
+		key = peerKey{DirectionInbound, protocolFor(socket, sockaddr), sockaddr.Address, localPort}
+
+	case "sendto", "sendmsg", "recvfrom", "recvmsg":
+		socket, sockaddr, ok := socketArgs(event, 4)
+		remote, remotePort, split := strace.SplitHostPort(socket.Remote)
+		if !ok && split {
+			sockaddr, ok = strace.Sockaddr{Address: remote, Port: remotePort}, true
+		}
+		if !ok || sockaddr.Address == "" {
+			return
+		}
+
+		// Traffic belongs to an existing outbound peer, otherwise sends are outbound and receives inbound
+		key = peerKey{DirectionOutbound, protocolFor(socket, sockaddr), sockaddr.Address, sockaddr.Port}
+		if _, exists := collector.peers[key]; !exists && strings.HasPrefix(event.Syscall, "recv") {
+			_, localPort, _ := strace.SplitHostPort(socket.Local)
+			key = peerKey{DirectionInbound, key.protocol, sockaddr.Address, localPort}
+		}
+
+	default:
+		return
+	}
+
+	collector.record(collector.peer(key), event)
+}
+
+func (collector *networkCollector) record(peer *Peer, event *strace.Event) {
+	peer.Calls++
+	peer.Syscalls[event.Syscall]++
+	if event.Failed() && !nonFailureErrnos[event.Errno] {
+		peer.Failures[event.Errno]++
+	}
+}
+
+func protocolFor(socket strace.SocketDecoration, sockaddr strace.Sockaddr) string {
+	if socket.Protocol != "" {
+		return socket.Protocol
+	}
+	if sockaddr.Family == "AF_UNIX" {
+		return "UNIX"
+	}
+	return ""
+}
+
+// ReportNetwork lists the peers each container in the collection communicated with. A nil resolver
+// leaves peers unresolved.
+func ReportNetwork(collection *Collection, resolver Resolver) (*NetworkReport, error) {
+	report := &NetworkReport{Containers: []ContainerNetwork{}}
+
+	for _, containerLog := range collection.Containers {
+		collector := &networkCollector{peers: map[peerKey]*Peer{}}
+		err := containerLog.Read(func(event *strace.Event) error {
+			collector.add(event)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		containerNetwork := ContainerNetwork{Pod: containerLog.Pod, Container: containerLog.Container, Peers: []*Peer{}}
+		for _, peer := range collector.peers {
+			peer.ConnectP50 = peer.ConnectLatency.Quantile(0.5)
+			peer.ConnectMax = peer.ConnectLatency.Max
+			if resolver != nil && peer.Protocol != "UNIX" {
+				peer.Resource = resolver.Resolve(peer.Address)
+			}
+			containerNetwork.Peers = append(containerNetwork.Peers, peer)
+		}
+
+		// Outbound before inbound, then the busiest peers
+		sort.Slice(containerNetwork.Peers, func(i, j int) bool {
+			left, right := containerNetwork.Peers[i], containerNetwork.Peers[j]
+			if left.Direction != right.Direction {
+				return left.Direction == DirectionOutbound
+			}
+			if left.Calls != right.Calls {
+				return left.Calls > right.Calls
+			}
+			return left.Endpoint() < right.Endpoint()
+		})
+		report.Containers = append(report.Containers, containerNetwork)
+	}
+	return report, nil
+}
+
+// WriteNetworkReport renders the network report in the requested format
+func WriteNetworkReport(writer io.Writer, report *NetworkReport, format Format) error {
+	if format == FormatJSON {
+		return writeJSON(writer, report)
+	}
+
+	highlight := func(errno string) string {
+		if !highlightedNetworkErrnos[errno] {
+			return errno
+		}
+		if format == FormatMarkdown {
+			return "**" + errno + "**"
+		}
+		return "!" + errno
+	}
+
+	tables := []table{}
+	for _, container := range report.Containers {
+		name := container.Container
+		if container.Pod != "" {
+			name = container.Pod + "/" + container.Container
+		}
+
+		peers := table{
+			title:   fmt.Sprintf("%s: %d network peers", name, len(container.Peers)),
+			headers: []string{"DIRECTION", "PROTOCOL", "PEER", "RESOURCE", "CALLS", "FAILURES", "CONNECT P50", "CONNECT MAX"},
+		}
+		for _, peer := range container.Peers {
+			failures := "-"
+			if peer.failed() > 0 {
+				failures = formatCounts(peer.Failures, highlight)
+			}
+			peers.rows = append(peers.rows, []string{
+				peer.Direction, peer.Protocol, peer.Endpoint(), peer.Resource, fmt.Sprint(peer.Calls), failures,
+				formatDuration(peer.ConnectP50), formatDuration(peer.ConnectMax),
+			})
+		}
+		tables = append(tables, peers)
+	}
+	return writeTables(writer, format, tables)
+}
@@ -0,0 +1,310 @@
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	MetricCallRate   = "call rate"
+	MetricErrorRate  = "error rate"
+	MetricShare      = "share of calls"
+	MetricLatencyP99 = "p99 latency"
+)
+
+// Replicas need at least this many peers for the median of the others to be meaningful
+const minReplicas = 3
+
+type OutlierOptions struct {
+	// Factor is how many times larger or smaller than the other replicas a value must be to be flagged
+	Factor float64
+	// MinCalls ignores system calls made fewer times than this by both the replica and the other replicas
+	MinCalls int64
+	// MinErrorRate is the smallest increase in an error rate that is flagged
+	MinErrorRate float64
+	// MinLatency is the smallest increase in latency that is flagged
+	MinLatency time.Duration
+}
+
+func DefaultOutlierOptions() OutlierOptions {
+	return OutlierOptions{Factor: 2, MinCalls: 20, MinErrorRate: 0.05, MinLatency: time.Millisecond}
+}
+
+// Finding is a single way in which a replica differs from the other replicas. Syscall is empty for
+// metrics of the whole container.
+type Finding struct {
+	Metric   string  `json:"metric"`
+	Syscall  string  `json:"syscall,omitempty"`
+	Value    float64 `json:"value"`
+	Baseline float64 `json:"baseline"`
+}
+
+// Ratio is how many times larger or smaller the value is than the baseline
+func (finding Finding) Ratio() float64 {
+	if finding.Value == 0 || finding.Baseline == 0 {
+		return math.Inf(1)
+	}
+	if finding.Value < finding.Baseline {
+		return finding.Baseline / finding.Value
+	}
+	return finding.Value / finding.Baseline
+}
+
+type Outlier struct {
+	Pod      string    `json:"pod"`
+	Score    float64   `json:"score"`
+	Findings []Finding `json:"findings"`
+}
+
+// ReplicaGroup is a container that is run by several replicas of the same workload
+type ReplicaGroup struct {
+	Owner     string    `json:"owner,omitempty"`
+	Container string    `json:"container"`
+	Pods      []string  `json:"pods"`
+	Outliers  []Outlier `json:"outliers"`
+	// Skipped explains why a group was not compared
+	Skipped string `json:"skipped,omitempty"`
+}
+
+type OutlierReport struct {
+	Groups []ReplicaGroup `json:"groups"`
+}
+
+// replicaProfile holds the metrics of one replica
+type replicaProfile struct {
+	pod      string
+	calls    int64
+	errors   int64
+	duration time.Duration
+	syscalls map[string]SyscallSummary
+}
+
+// DetectOutliers compares the replicas of each workload in the collection and flags those whose system call
+// mix, error rates or latencies differ from the other replicas. Replicas are grouped by the controller recorded
+// in the manifest and the container name.
+func DetectOutliers(collection *Collection, options OutlierOptions) (*OutlierReport, error) {
+	summary, err := Summarise(collection, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[string]string{}
+	if collection.Manifest != nil {
+		for _, pod := range collection.Manifest.Pods {
+			owners[pod.Pod] = pod.Owner
+		}
+	}
+
+	type groupKey struct{ owner, container string }
+	groups := map[groupKey][]replicaProfile{}
+	for _, container := range summary.Containers {
+		profile := replicaProfile{
+			pod:      container.Pod,
+			calls:    container.Calls,
+			duration: container.Duration,
+			syscalls: map[string]SyscallSummary{},
+		}
+		for _, syscall := range container.Syscalls {
+			profile.errors += syscall.Errors
+			profile.syscalls[syscall.Name] = syscall
+		}
+		key := groupKey{owners[container.Pod], container.Container}
+		groups[key] = append(groups[key], profile)
+	}
+
+	report := &OutlierReport{Groups: []ReplicaGroup{}}
+	for key, replicas := range groups {
+		group := ReplicaGroup{Owner: key.owner, Container: key.container, Pods: []string{}, Outliers: []Outlier{}}
+		for _, replica := range replicas {
+			group.Pods = append(group.Pods, replica.pod)
+		}
+		sort.Strings(group.Pods)
+
+		if len(replicas) < minReplicas {
+			group.Skipped = fmt.Sprintf("at least %d replicas are needed to find an outlier", minReplicas)
+			report.Groups = append(report.Groups, group)
+			continue
+		}
+
+		for index, replica := range replicas {
+			others := make([]replicaProfile, 0, len(replicas)-1)
+			others = append(others, replicas[:index]...)
+			others = append(others, replicas[index+1:]...)
+
+			if findings := compareReplica(replica, others, options); len(findings) > 0 {
+				outlier := Outlier{Pod: replica.pod, Findings: findings}
+				for _, finding := range findings {
+					outlier.Score += math.Log(math.Min(finding.Ratio(), 100))
+				}
+				group.Outliers = append(group.Outliers, outlier)
+			}
+		}
+		sort.Slice(group.Outliers, func(i, j int) bool {
+			return group.Outliers[i].Score > group.Outliers[j].Score
+		})
+		report.Groups = append(report.Groups, group)
+	}
+
+	sort.Slice(report.Groups, func(i, j int) bool {
+		left, right := report.Groups[i], report.Groups[j]
+		if left.Owner != right.Owner {
+			return left.Owner < right.Owner
+		}
+		return left.Container < right.Container
+	})
+	return report, nil
+}
+
+// compareReplica compares a replica against the median of the other replicas
+func compareReplica(replica replicaProfile, others []replicaProfile, options OutlierOptions) []Finding {
+	findings := []Finding{}
+	differs := func(value float64, baseline float64) bool {
+		return value != baseline && (value >= baseline*options.Factor || value*options.Factor <= baseline)
+	}
+
+	// The whole container
+	timed := replica.duration > 0
+	for _, other := range others {
+		timed = timed && other.duration > 0
+	}
+	if timed {
+		value := float64(replica.calls) / replica.duration.Seconds()
+		baseline := median(others, func(other replicaProfile) float64 { return float64(other.calls) / other.duration.Seconds() })
+		if differs(value, baseline) {
+			findings = append(findings, Finding{Metric: MetricCallRate, Value: value, Baseline: baseline})
+		}
+	}
+	errorRate := func(errors int64, calls int64) float64 {
+		if calls == 0 {
+			return 0
+		}
+		return float64(errors) / float64(calls)
+	}
+	value := errorRate(replica.errors, replica.calls)
+	baseline := median(others, func(other replicaProfile) float64 { return errorRate(other.errors, other.calls) })
+	if value-baseline >= options.MinErrorRate && differs(value, baseline) {
+		findings = append(findings, Finding{Metric: MetricErrorRate, Value: value, Baseline: baseline})
+	}
+
+	// Each system call made by any of the replicas
+	names := map[string]bool{}
+	for name := range replica.syscalls {
+		names[name] = true
+	}
+	for _, other := range others {
+		for name := range other.syscalls {
+			names[name] = true
+		}
+	}
+	for name := range names {
+		syscall := replica.syscalls[name]
+		baselineCalls := median(others, func(other replicaProfile) float64 { return float64(other.syscalls[name].Count) })
+		if syscall.Count < options.MinCalls && baselineCalls < float64(options.MinCalls) {
+			continue
+		}
+
+		share := func(profile replicaProfile) float64 {
+			if profile.calls == 0 {
+				return 0
+			}
+			return float64(profile.syscalls[name].Count) / float64(profile.calls)
+		}
+		if value, baseline := share(replica), median(others, share); differs(value, baseline) {
+			findings = append(findings, Finding{Metric: MetricShare, Syscall: name, Value: value, Baseline: baseline})
+		}
+
+		syscallErrorRate := func(profile replicaProfile) float64 {
+			return errorRate(profile.syscalls[name].Errors, profile.syscalls[name].Count)
+		}
+		if value, baseline := syscallErrorRate(replica), median(others, syscallErrorRate); value-baseline >= options.MinErrorRate && differs(value, baseline) {
+			findings = append(findings, Finding{Metric: MetricErrorRate, Syscall: name, Value: value, Baseline: baseline})
+		}
+
+		// Latency is only compared when both sides made the call often enough for a p99
+		if syscall.Count >= options.MinCalls && baselineCalls >= float64(options.MinCalls) {
+			p99 := func(profile replicaProfile) float64 { return float64(profile.syscalls[name].P99) }
+			value, baseline := p99(replica), median(others, p99)
+			if math.Abs(value-baseline) >= float64(options.MinLatency) && differs(value, baseline) {
+				findings = append(findings, Finding{Metric: MetricLatencyP99, Syscall: name, Value: value, Baseline: baseline})
+			}
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Ratio() != findings[j].Ratio() {
+			return findings[i].Ratio() > findings[j].Ratio()
+		}
+		return findings[i].Syscall+findings[i].Metric < findings[j].Syscall+findings[j].Metric
+	})
+	return findings
+}
+
+func median(profiles []replicaProfile, value func(replicaProfile) float64) float64 {
+	values := make([]float64, len(profiles))
+	for index, profile := range profiles {
+		values[index] = value(profile)
+	}
+	sort.Float64s(values)
+
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+func formatMetric(metric string, value float64) string {
+	switch metric {
+	case MetricCallRate:
+		return fmt.Sprintf("%.1f/s", value)
+	case MetricLatencyP99:
+		return time.Duration(value).String()
+	default:
+		return fmt.Sprintf("%.1f%%", value*100)
+	}
+}
+
+// WriteOutlierReport renders the outlier report in the requested format
+func WriteOutlierReport(writer io.Writer, report *OutlierReport, format Format) error {
+	if format == FormatJSON {
+		return writeJSON(writer, report)
+	}
+
+	tables := []table{}
+	for _, group := range report.Groups {
+		name := group.Container
+		if group.Owner != "" {
+			name = group.Owner + " " + group.Container
+		}
+
+		outliers := table{
+			title:   fmt.Sprintf("%s: %d replicas, %d outliers", name, len(group.Pods), len(group.Outliers)),
+			headers: []string{"POD", "METRIC", "SYSCALL", "VALUE", "OTHER REPLICAS", "DIFFERENCE"},
+		}
+		if group.Skipped != "" {
+			outliers.title = fmt.Sprintf("%s: %d replicas, not compared as %s", name, len(group.Pods), group.Skipped)
+		}
+		for _, outlier := range group.Outliers {
+			for _, finding := range outlier.Findings {
+				difference := "new"
+				if ratio := finding.Ratio(); !math.IsInf(ratio, 1) {
+					difference = fmt.Sprintf("%.1fx higher", ratio)
+					if finding.Value < finding.Baseline {
+						difference = fmt.Sprintf("%.1fx lower", ratio)
+					}
+				} else if finding.Value == 0 {
+					difference = "absent"
+				}
+				outliers.rows = append(outliers.rows, []string{
+					outlier.Pod, finding.Metric, finding.Syscall,
+					formatMetric(finding.Metric, finding.Value), formatMetric(finding.Metric, finding.Baseline), difference,
+				})
+			}
+		}
+		tables = append(tables, outliers)
+	}
+	return writeTables(writer, format, tables)
+}
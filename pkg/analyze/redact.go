@@ -0,0 +1,106 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/redact"
+)
+
+type RedactOptions struct {
+	Redactor *redact.Redactor
+	// Secrets are literal values to remove from the logs of each Pod, keyed by Pod name
+	Secrets map[string][]string
+}
+
+// RedactCollection writes a copy of the collection to the given directory with secrets removed from every
+// log. Compressed and rotated logs keep their names and the manifest records the detectors applied.
+func RedactCollection(collection *Collection, directory string, options RedactOptions) error {
+	detectors := options.Redactor.Detectors()
+	keepName := func(name string) string { return name }
+
+	manifest, err := rewriteCollection(collection, directory, keepName, func(containerLog ContainerLog, source string, target string) error {
+		redactor := options.Redactor
+		if values := options.Secrets[containerLog.Pod]; len(values) > 0 {
+			redactor = redactor.WithValues(values)
+			detectors = appendUnique(detectors, redact.DetectorSecret)
+		}
+		return redactFile(source, target, redactor)
+	})
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	for _, detector := range detectors {
+		manifest.Redaction = appendUnique(manifest.Redaction, detector)
+	}
+	return kstrace.WriteManifest(directory, manifest)
+}
+
+// redactFile copies a single log line by line, keeping its compression
+func redactFile(source string, target string, redactor *redact.Redactor) error {
+	if kstrace.EncryptionOf(source) != kstrace.EncryptionNone {
+		return fmt.Errorf("%q is encrypted. decrypt the collection before redacting it", source)
+	}
+
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	reader, closeReader, err := decompress(source, input)
+	if err != nil {
+		return err
+	}
+	defer closeReader()
+
+	compression := kstrace.CompressionNone
+	for _, candidate := range kstrace.Compressions {
+		if strings.HasSuffix(target, candidate.Extension()) {
+			compression = candidate
+		}
+	}
+
+	return createFile(target, func(output io.Writer) error {
+		compressor, err := compression.NewWriter(output)
+		if err != nil {
+			return err
+		}
+
+		// Lines are read whole as strace output can exceed the default buffer of a Scanner
+		lines := bufio.NewReader(reader)
+		for {
+			line, readErr := lines.ReadString('\n')
+			if readErr != nil && readErr != io.EOF {
+				return readErr
+			}
+			if line != "" {
+				redacted := redactor.Redact(strings.TrimSuffix(line, "\n"))
+				if strings.HasSuffix(line, "\n") {
+					redacted += "\n"
+				}
+				if _, err := io.WriteString(compressor, redacted); err != nil {
+					return err
+				}
+			}
+			if readErr == io.EOF {
+				break
+			}
+		}
+		return compressor.Close()
+	})
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,85 @@
+package analyze
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+// rewriteCollection writes a copy of the collection to the given directory, passing each log file through
+// rewrite. rename maps the name of each log within the collection to the name it is stored under. The pod
+// manifests are copied and the root manifest is returned, with the log files renamed, for the caller to
+// update and write.
+func rewriteCollection(collection *Collection, directory string, rename func(name string) string,
+	rewrite func(containerLog ContainerLog, source string, target string) error) (*kstrace.Manifest, error) {
+	if err := os.MkdirAll(directory, 0775); err != nil {
+		return nil, err
+	}
+
+	for _, containerLog := range collection.Containers {
+		for _, path := range containerLog.Files {
+			name, err := filepath.Rel(collection.Root, path)
+			if err != nil {
+				return nil, err
+			}
+			target := filepath.Join(directory, rename(name))
+			if err := os.MkdirAll(filepath.Dir(target), 0775); err != nil {
+				return nil, err
+			}
+			if err := rewrite(containerLog, path, target); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if collection.Manifest == nil {
+		return nil, nil
+	}
+	manifest := *collection.Manifest
+	manifest.Pods = make([]kstrace.TraceResult, len(collection.Manifest.Pods))
+	for index, pod := range collection.Manifest.Pods {
+		manifest.Pods[index] = renameLogFiles(pod, rename)
+
+		podManifest, err := kstrace.ReadPodManifest(filepath.Join(collection.Root, pod.Pod))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := kstrace.WritePodManifest(filepath.Join(directory, pod.Pod), renameLogFiles(*podManifest, rename)); err != nil {
+			return nil, err
+		}
+	}
+	return &manifest, nil
+}
+
+// renameLogFiles copies the result of a traced Pod with the log files renamed
+func renameLogFiles(pod kstrace.TraceResult, rename func(name string) string) kstrace.TraceResult {
+	containers := make([]kstrace.ContainerResult, len(pod.Containers))
+	for index, container := range pod.Containers {
+		container.LogFiles = append([]string{}, container.LogFiles...)
+		for fileIndex, name := range container.LogFiles {
+			container.LogFiles[fileIndex] = filepath.ToSlash(rename(filepath.FromSlash(name)))
+		}
+		containers[index] = container
+	}
+	pod.Containers = containers
+	return pod
+}
+
+// createFile creates the target of a rewritten log, writing through the given function
+func createFile(target string, write func(writer io.Writer) error) error {
+	output, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	if err := write(output); err != nil {
+		return err
+	}
+	return output.Close()
+}
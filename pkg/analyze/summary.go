@@ -0,0 +1,162 @@
+package analyze
+
+import (
+	"sort"
+	"time"
+
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+// SyscallSummary aggregates every call of a single system call
+type SyscallSummary struct {
+	Name   string `json:"name"`
+	Count  int64  `json:"count"`
+	Errors int64  `json:"errors"`
+	// Errnos counts the errors returned by the system call
+	Errnos  map[string]int64 `json:"errnos,omitempty"`
+	Total   time.Duration    `json:"total"`
+	P50     time.Duration    `json:"p50"`
+	P95     time.Duration    `json:"p95"`
+	P99     time.Duration    `json:"p99"`
+	Max     time.Duration    `json:"max"`
+	Latency *Histogram       `json:"-"`
+}
+
+// ContainerSummary aggregates the system calls made by a single container
+type ContainerSummary struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Calls     int64  `json:"calls"`
+	// Duration is the time between the first and last recorded call
+	Duration time.Duration    `json:"duration"`
+	Syscalls []SyscallSummary `json:"syscalls"`
+	Errors   map[string]int64 `json:"errors"`
+	Slowest  []*strace.Event  `json:"slowest"`
+}
+
+// Summary is the result of analysing a collection
+type Summary struct {
+	Containers []ContainerSummary `json:"containers"`
+}
+
+type Options struct {
+	// Slowest is the number of individual calls to report per container
+	Slowest int
+}
+
+// Summarise reads every container in the collection and aggregates its system calls
+func Summarise(collection *Collection, options Options) (*Summary, error) {
+	summary := &Summary{Containers: []ContainerSummary{}}
+
+	for _, containerLog := range collection.Containers {
+		containerSummary, err := SummariseContainer(containerLog, options)
+		if err != nil {
+			return nil, err
+		}
+		summary.Containers = append(summary.Containers, *containerSummary)
+	}
+	return summary, nil
+}
+
+func SummariseContainer(containerLog ContainerLog, options Options) (*ContainerSummary, error) {
+	summariser := newSummariser(containerLog.Pod, containerLog.Container, options)
+	err := containerLog.Read(func(event *strace.Event) error {
+		summariser.add(event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summariser.summary(), nil
+}
+
+type summariser struct {
+	options   Options
+	container ContainerSummary
+	syscalls  map[string]*SyscallSummary
+	first     time.Time
+	last      time.Time
+}
+
+func newSummariser(pod string, container string, options Options) *summariser {
+	return &summariser{
+		options: options,
+		container: ContainerSummary{
+			Pod:       pod,
+			Container: container,
+			Errors:    map[string]int64{},
+			Slowest:   []*strace.Event{},
+		},
+		syscalls: map[string]*SyscallSummary{},
+	}
+}
+
+func (summariser *summariser) add(event *strace.Event) {
+	syscall, ok := summariser.syscalls[event.Syscall]
+	if !ok {
+		syscall = &SyscallSummary{Name: event.Syscall, Errnos: map[string]int64{}, Latency: NewHistogram()}
+		summariser.syscalls[event.Syscall] = syscall
+	}
+
+	summariser.container.Calls++
+	syscall.Count++
+	syscall.Latency.Add(event.Duration)
+	if event.Failed() {
+		syscall.Errors++
+		syscall.Errnos[event.Errno]++
+		summariser.container.Errors[event.Errno]++
+	}
+	if !event.Time.IsZero() {
+		if summariser.first.IsZero() || event.Time.Before(summariser.first) {
+			summariser.first = event.Time
+		}
+		if event.Time.After(summariser.last) {
+			summariser.last = event.Time
+		}
+	}
+
+	summariser.addSlowest(event)
+}
+
+// addSlowest keeps the slowest events sorted with the slowest first
+func (summariser *summariser) addSlowest(event *strace.Event) {
+	slowest := summariser.container.Slowest
+	if summariser.options.Slowest <= 0 || event.Duration == 0 {
+		return
+	}
+	if len(slowest) == summariser.options.Slowest && event.Duration <= slowest[len(slowest)-1].Duration {
+		return
+	}
+
+	index := sort.Search(len(slowest), func(i int) bool { return slowest[i].Duration < event.Duration })
+	slowest = append(slowest, nil)
+	copy(slowest[index+1:], slowest[index:])
+	slowest[index] = event
+
+	if len(slowest) > summariser.options.Slowest {
+		slowest = slowest[:summariser.options.Slowest]
+	}
+	summariser.container.Slowest = slowest
+}
+
+func (summariser *summariser) summary() *ContainerSummary {
+	summariser.container.Duration = summariser.last.Sub(summariser.first)
+	for _, syscall := range summariser.syscalls {
+		syscall.Total = syscall.Latency.Total
+		syscall.Max = syscall.Latency.Max
+		syscall.P50 = syscall.Latency.Quantile(0.50)
+		syscall.P95 = syscall.Latency.Quantile(0.95)
+		syscall.P99 = syscall.Latency.Quantile(0.99)
+		summariser.container.Syscalls = append(summariser.container.Syscalls, *syscall)
+	}
+
+	// Most frequent first
+	sort.Slice(summariser.container.Syscalls, func(i, j int) bool {
+		left, right := summariser.container.Syscalls[i], summariser.container.Syscalls[j]
+		if left.Count != right.Count {
+			return left.Count > right.Count
+		}
+		return left.Name < right.Name
+	})
+	return &summariser.container
+}
@@ -0,0 +1,179 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+const (
+	maxEvents   = 2000
+	maxErrors   = 50
+	maxMessages = 20
+)
+
+type containerStats struct {
+	name      string
+	calls     int64
+	errors    int64
+	lastCalls int64
+	rate      float64
+}
+
+type syscallStats struct {
+	name  string
+	count int64
+	time  time.Duration
+}
+
+// Dashboard aggregates the system calls of every traced container for display in a terminal. It is fed
+// as a LineObserver from the same exec streams used to store the output.
+type Dashboard struct {
+	options Options
+
+	mutex      sync.Mutex
+	started    time.Time
+	lastTick   time.Time
+	parsers    map[kstrace.TraceTarget]*strace.Parser
+	containers map[kstrace.TraceTarget]*containerStats
+	syscalls   map[string]*syscallStats
+	calls      int64
+	rate       float64
+	lastCalls  int64
+
+	// The most recent output
+	events   *ring
+	errors   *ring
+	messages *ring
+
+	// View state driven by the keyboard
+	filter  string
+	editing bool
+	input   string
+	paused  bool
+	frozen  []string
+	offset  int
+
+	// drawMutex serialises writes to the terminal
+	drawMutex sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	restore   func()
+}
+
+func New(options Options) *Dashboard {
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = time.Second
+	}
+	now := time.Now()
+	return &Dashboard{
+		options:    options,
+		started:    now,
+		lastTick:   now,
+		parsers:    map[kstrace.TraceTarget]*strace.Parser{},
+		containers: map[kstrace.TraceTarget]*containerStats{},
+		syscalls:   map[string]*syscallStats{},
+		events:     newRing(maxEvents),
+		errors:     newRing(maxErrors),
+		messages:   newRing(maxMessages),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// ObserveLine records a line of strace output from one of the traced containers
+func (dashboard *Dashboard) ObserveLine(target kstrace.TraceTarget, line string) {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	container, ok := dashboard.containers[target]
+	if !ok {
+		container = &containerStats{name: target.Pod + "/" + target.Container}
+		dashboard.containers[target] = container
+		dashboard.parsers[target] = strace.NewParser(time.Now())
+	}
+	dashboard.events.add(container.name + " " + line)
+
+	event := dashboard.parsers[target].Parse(line)
+	if event == nil {
+		return
+	}
+
+	dashboard.calls++
+	container.calls++
+	syscall, ok := dashboard.syscalls[event.Syscall]
+	if !ok {
+		syscall = &syscallStats{name: event.Syscall}
+		dashboard.syscalls[event.Syscall] = syscall
+	}
+	syscall.count++
+	syscall.time += event.Duration
+
+	if event.Failed() {
+		container.errors++
+		dashboard.errors.add(fmt.Sprintf("%s %s %s %s(%s)",
+			event.Time.Format("15:04:05.000"), container.name, event.Errno, event.Syscall, event.Args))
+	}
+}
+
+// Write records a log message so logging does not draw over the dashboard
+func (dashboard *Dashboard) Write(data []byte) (int, error) {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	for _, message := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		dashboard.messages.add(message)
+	}
+	return len(data), nil
+}
+
+// tick updates the call rates since the previous tick
+func (dashboard *Dashboard) tick(now time.Time) {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	elapsed := now.Sub(dashboard.lastTick).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	dashboard.lastTick = now
+
+	dashboard.rate = float64(dashboard.calls-dashboard.lastCalls) / elapsed
+	dashboard.lastCalls = dashboard.calls
+	for _, container := range dashboard.containers {
+		container.rate = float64(container.calls-container.lastCalls) / elapsed
+		container.lastCalls = container.calls
+	}
+}
+
+// ring keeps the most recent values up to a fixed size
+type ring struct {
+	values []string
+	next   int
+	size   int
+}
+
+func newRing(size int) *ring {
+	return &ring{size: size}
+}
+
+func (ring *ring) add(value string) {
+	if len(ring.values) < ring.size {
+		ring.values = append(ring.values, value)
+		return
+	}
+	ring.values[ring.next] = value
+	ring.next = (ring.next + 1) % ring.size
+}
+
+// list returns a copy of the values, oldest first
+func (ring *ring) list() []string {
+	values := make([]string, 0, len(ring.values))
+	values = append(values, ring.values[ring.next:]...)
+	return append(values, ring.values[:ring.next]...)
+}
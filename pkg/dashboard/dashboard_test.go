@@ -0,0 +1,67 @@
+package dashboard
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+func TestDashboard(t *testing.T) {
+	dashboard := New(Options{})
+	app := kstrace.TraceTarget{Namespace: "default", Pod: "web-1", Container: "app"}
+	sidecar := kstrace.TraceTarget{Namespace: "default", Pod: "web-1", Container: "proxy"}
+
+	for i := 0; i < 10; i++ {
+		dashboard.ObserveLine(app, `10:00:00.000000 read(3, "data", 4096) = 4 <0.000010>`)
+	}
+	dashboard.ObserveLine(app, `10:00:00.000000 openat(AT_FDCWD, "/etc/app.yaml", O_RDONLY) = -1 ENOENT (No such file or directory) <0.001000>`)
+	dashboard.ObserveLine(sidecar, `10:00:00.000000 epoll_wait(4, [], 128, 100) = 0 <0.100000>`)
+	dashboard.tick(dashboard.lastTick.Add(time.Second))
+
+	if stats := dashboard.containers[app]; stats.calls != 11 || stats.errors != 1 || stats.rate != 11 {
+		t.Errorf("Container stats mismatch. Got: %+v", stats)
+	}
+
+	screen := strings.Join(dashboard.render(120, 40), "\n")
+	for _, expected := range []string{"2 containers  12 calls", "web-1/app", "read                           10", "epoll_wait", "ENOENT openat", "web-1/proxy 10:00:00.000000 epoll_wait"} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("Expected %q on the dashboard:\n%s", expected, screen)
+		}
+	}
+	if lines := dashboard.render(120, 40); len(lines) != 40 {
+		t.Errorf("Expected the dashboard to fill the terminal. Got %d lines", len(lines))
+	}
+
+	// Filter the event view to a single container
+	if dashboard.handleKeys("/") || dashboard.handleKeys("proxy\r") {
+		t.Fatalf("Unexpected quit while filtering")
+	}
+	screen = strings.Join(dashboard.render(120, 40), "\n")
+	if !strings.Contains(screen, `EVENTS matching "proxy"`) || strings.Contains(screen, "web-1/app 10:00:00") {
+		t.Errorf("Expected the events to be filtered:\n%s", screen)
+	}
+	if !dashboard.handleKeys("q") {
+		t.Errorf("Expected q to quit the dashboard")
+	}
+
+	// Closing hands the output back to the caller once
+	closed := 0
+	dashboard = New(Options{OnClose: func() { closed++ }})
+	dashboard.Close()
+	dashboard.Close()
+	if closed != 1 {
+		t.Errorf("Expected OnClose to be called once. Got %d calls", closed)
+	}
+}
+
+func TestRing(t *testing.T) {
+	values := newRing(3)
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		values.add(value)
+	}
+	if list := strings.Join(values.list(), ""); list != "cde" {
+		t.Errorf("Ring mismatch. Expected %q, Got: %q", "cde", list)
+	}
+}
@@ -0,0 +1,349 @@
+package dashboard
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	enterAlternateScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAlternateScreen = "\x1b[?25h\x1b[?1049l"
+	moveHome             = "\x1b[H"
+	clearLine            = "\x1b[K"
+	clearBelow           = "\x1b[J"
+	reverseVideo         = "\x1b[7m"
+	boldText             = "\x1b[1m"
+	redText              = "\x1b[31m"
+	resetText            = "\x1b[0m"
+
+	tableRows = 8
+	errorRows = 5
+)
+
+// Options configures the terminal the dashboard is drawn on
+type Options struct {
+	Input  *os.File
+	Output *os.File
+	// RefreshInterval is how often the dashboard is redrawn. Defaults to a second
+	RefreshInterval time.Duration
+	// OnQuit is called after the terminal is restored when the user quits the dashboard
+	OnQuit func()
+	// OnClose is called once the terminal is restored, whether the user quit or Close was called
+	OnClose func()
+}
+
+// Start switches the terminal to the dashboard and redraws it until Close is called
+func (dashboard *Dashboard) Start() error {
+	inputFd, outputFd := int(dashboard.options.Input.Fd()), int(dashboard.options.Output.Fd())
+	if !term.IsTerminal(inputFd) || !term.IsTerminal(outputFd) {
+		return fmt.Errorf("the dashboard requires an interactive terminal")
+	}
+
+	state, err := term.MakeRaw(inputFd)
+	if err != nil {
+		return err
+	}
+	dashboard.options.Output.WriteString(enterAlternateScreen)
+	dashboard.restore = func() {
+		dashboard.options.Output.WriteString(leaveAlternateScreen)
+		term.Restore(inputFd, state)
+	}
+
+	go dashboard.readKeys()
+	go func() {
+		defer close(dashboard.done)
+
+		ticker := time.NewTicker(dashboard.options.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			dashboard.draw()
+			select {
+			case now := <-ticker.C:
+				dashboard.tick(now)
+			case <-dashboard.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops redrawing the dashboard and restores the terminal. It is safe to call more than once.
+func (dashboard *Dashboard) Close() {
+	dashboard.closeOnce.Do(func() {
+		close(dashboard.stop)
+		if dashboard.restore != nil {
+			<-dashboard.done
+
+			dashboard.drawMutex.Lock()
+			dashboard.restore()
+			dashboard.drawMutex.Unlock()
+		}
+		if dashboard.options.OnClose != nil {
+			dashboard.options.OnClose()
+		}
+	})
+}
+
+func (dashboard *Dashboard) draw() {
+	dashboard.drawMutex.Lock()
+	defer dashboard.drawMutex.Unlock()
+
+	// The terminal is restored once the dashboard is closed
+	select {
+	case <-dashboard.stop:
+		return
+	default:
+	}
+
+	width, height, err := term.GetSize(int(dashboard.options.Output.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteString(moveHome)
+	for index, line := range dashboard.render(width, height) {
+		if index > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString(line)
+		builder.WriteString(resetText + clearLine)
+	}
+	builder.WriteString(clearBelow)
+	dashboard.options.Output.WriteString(builder.String())
+}
+
+// readKeys handles keyboard input until the process exits
+func (dashboard *Dashboard) readKeys() {
+	buffer := make([]byte, 64)
+	for {
+		count, err := dashboard.options.Input.Read(buffer)
+		if err != nil {
+			return
+		}
+		if dashboard.handleKeys(string(buffer[:count])) {
+			dashboard.Close()
+			if dashboard.options.OnQuit != nil {
+				dashboard.options.OnQuit()
+			}
+			return
+		}
+		dashboard.draw()
+	}
+}
+
+// handleKeys applies a chunk of keyboard input and reports whether the user asked to quit
+func (dashboard *Dashboard) handleKeys(keys string) bool {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	if dashboard.editing {
+		for _, key := range keys {
+			switch key {
+			case '\r', '\n':
+				dashboard.filter, dashboard.editing, dashboard.offset = dashboard.input, false, 0
+			case 0x1b:
+				dashboard.editing = false
+				return false
+			case 0x7f, 0x08:
+				if runes := []rune(dashboard.input); len(runes) > 0 {
+					dashboard.input = string(runes[:len(runes)-1])
+				}
+			case 0x03:
+				return true
+			default:
+				if key >= ' ' {
+					dashboard.input += string(key)
+				}
+			}
+		}
+		return false
+	}
+
+	switch keys {
+	case "\x1b[A", "k":
+		dashboard.offset++
+	case "\x1b[B", "j":
+		if dashboard.offset > 0 {
+			dashboard.offset--
+		}
+	case "\x1b[F", "G":
+		dashboard.offset = 0
+	case "\x1b":
+		dashboard.filter, dashboard.offset = "", 0
+	default:
+		for _, key := range keys {
+			switch key {
+			case 'q', 0x03:
+				return true
+			case '/':
+				dashboard.editing, dashboard.input = true, dashboard.filter
+			case 'p', ' ':
+				dashboard.paused = !dashboard.paused
+				dashboard.frozen = nil
+				if dashboard.paused {
+					dashboard.frozen = dashboard.events.list()
+				}
+			}
+		}
+	}
+	return false
+}
+
+// render lays out the dashboard for a terminal of the given size
+func (dashboard *Dashboard) render(width int, height int) []string {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	lines := []string{}
+	add := func(prefix string, text string) {
+		lines = append(lines, prefix+fit(text, width))
+	}
+
+	status := ""
+	if dashboard.paused {
+		status = "  PAUSED"
+	}
+	add(reverseVideo, fmt.Sprintf(" kstrace  %d containers  %d calls  %.0f calls/s  %s%s",
+		len(dashboard.containers), dashboard.calls, dashboard.rate, time.Since(dashboard.started).Truncate(time.Second), status)+strings.Repeat(" ", width))
+	add("", " q quit  / filter  esc clear filter  p pause  ↑/↓ scroll  G follow")
+	add("", "")
+
+	// Busiest containers
+	containers := make([]*containerStats, 0, len(dashboard.containers))
+	for _, container := range dashboard.containers {
+		containers = append(containers, container)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].rate != containers[j].rate {
+			return containers[i].rate > containers[j].rate
+		}
+		return containers[i].name < containers[j].name
+	})
+	nameWidth := width - 36
+	if nameWidth < 20 {
+		nameWidth = 20
+	}
+	add(boldText, fmt.Sprintf("%-*s %10s %12s %10s", nameWidth, "POD/CONTAINER", "CALLS/S", "CALLS", "ERRORS"))
+	for index, container := range containers {
+		if index == tableRows {
+			break
+		}
+		add("", fmt.Sprintf("%-*s %10.1f %12d %10d", nameWidth, fit(container.name, nameWidth), container.rate, container.calls, container.errors))
+	}
+	add("", "")
+
+	// Top system calls side by side
+	byCount := dashboard.topSyscalls(func(left, right *syscallStats) bool { return left.count > right.count })
+	byTime := dashboard.topSyscalls(func(left, right *syscallStats) bool { return left.time > right.time })
+	column := width / 2
+	add(boldText, fmt.Sprintf("%-*s%s", column, fmt.Sprintf("%-20s %12s", "TOP BY COUNT", "CALLS"), fmt.Sprintf("%-20s %12s", "TOP BY TIME", "TIME")))
+	for index := 0; index < len(byCount); index++ {
+		add("", fmt.Sprintf("%-*s%s", column,
+			fmt.Sprintf("%-20s %12d", byCount[index].name, byCount[index].count),
+			fmt.Sprintf("%-20s %12s", byTime[index].name, byTime[index].time.Truncate(time.Microsecond))))
+	}
+	add("", "")
+
+	// Recent errors, newest first
+	add(boldText, "RECENT ERRORS")
+	errors := dashboard.errors.list()
+	for index := len(errors) - 1; index >= 0 && index >= len(errors)-errorRows; index-- {
+		add(redText, errors[index])
+	}
+	add("", "")
+
+	// Events fill the remaining space, leaving the bottom line for the filter prompt or log messages
+	events := dashboard.events.list()
+	if dashboard.paused {
+		events = dashboard.frozen
+	}
+	if dashboard.filter != "" {
+		filtered := []string{}
+		for _, event := range events {
+			if strings.Contains(event, dashboard.filter) {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+	rows := height - len(lines) - 2
+	if rows < 0 {
+		rows = 0
+	}
+	end := len(events) - dashboard.offset
+	if end < rows {
+		end = rows
+	}
+	if end > len(events) {
+		end = len(events)
+	}
+	start := end - rows
+	if start < 0 {
+		start = 0
+	}
+	title := "EVENTS"
+	if dashboard.filter != "" {
+		title = fmt.Sprintf("EVENTS matching %q", dashboard.filter)
+	}
+	if end < len(events) {
+		title += fmt.Sprintf(" (%d newer)", len(events)-end)
+	}
+	add(boldText, title)
+	for _, event := range events[start:end] {
+		add("", event)
+	}
+	for len(lines) < height-1 {
+		add("", "")
+	}
+
+	if dashboard.editing {
+		add("", "/"+dashboard.input)
+	} else if messages := dashboard.messages.list(); len(messages) > 0 {
+		add("", messages[len(messages)-1])
+	} else {
+		add("", "")
+	}
+
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+func (dashboard *Dashboard) topSyscalls(less func(left, right *syscallStats) bool) []*syscallStats {
+	syscalls := make([]*syscallStats, 0, len(dashboard.syscalls))
+	for _, syscall := range dashboard.syscalls {
+		syscalls = append(syscalls, syscall)
+	}
+	sort.Slice(syscalls, func(i, j int) bool {
+		if less(syscalls[i], syscalls[j]) != less(syscalls[j], syscalls[i]) {
+			return less(syscalls[i], syscalls[j])
+		}
+		return syscalls[i].name < syscalls[j].name
+	})
+	if len(syscalls) > tableRows {
+		syscalls = syscalls[:tableRows]
+	}
+	return syscalls
+}
+
+// fit truncates text to the width of the terminal and strips control characters
+func fit(text string, width int) string {
+	runes := []rune(strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, text))
+	if len(runes) > width {
+		runes = runes[:width]
+	}
+	return string(runes)
+}
@@ -0,0 +1,164 @@
+package kstrace
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an action kstrace performs against the cluster
+type Permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	// Name limits the permission to a single object
+	Name string
+	// Namespace is empty for cluster scoped resources, and for Pods in a namespace that is yet to be generated
+	Namespace string
+}
+
+// ResourceName names the resource in the form used by kubectl, e.g. pods/exec or clusterroles/<name>
+func (permission Permission) ResourceName() string {
+	name := permission.Resource
+	if permission.Group != "" {
+		name += "." + permission.Group
+	}
+	if permission.Subresource != "" {
+		name += "/" + permission.Subresource
+	}
+	if permission.Name != "" {
+		name += "/" + permission.Name
+	}
+	return name
+}
+
+// Scope describes where the permission is needed
+func (permission Permission) Scope() string {
+	switch {
+	case permission.Resource == "namespaces" || permission.Resource == "clusterroles":
+		return "cluster"
+	case permission.Namespace == "":
+		return "all namespaces"
+	}
+	return permission.Namespace
+}
+
+// AccessOptions describe how a trace will run, which decides the permissions it needs
+type AccessOptions struct {
+	// TracerNamespace is the existing namespace the tracer Pods run in. A namespace is generated when empty
+	TracerNamespace string
+	// TargetNamespaces contain the Pods being traced
+	TargetNamespaces []string
+	// ImagePullSecrets are copied from SecretNamespace into a generated namespace
+	ImagePullSecrets []string
+	SecretNamespace  string
+	// Redact reads the Secrets referenced by the target Pods
+	Redact bool
+	// GrantPrivilegedSCC creates a ServiceAccount bound to the privileged SecurityContextConstraint
+	GrantPrivilegedSCC bool
+}
+
+// RequiredPermissions lists the permissions needed to trace with the given options
+func RequiredPermissions(options AccessOptions) []Permission {
+	permissions := []Permission{}
+
+	// Pods in a generated namespace can only be allowed by a ClusterRoleBinding
+	tracerNamespace := options.TracerNamespace
+	if tracerNamespace == "" {
+		permissions = append(permissions,
+			Permission{Verb: "create", Resource: "namespaces"},
+			Permission{Verb: "delete", Resource: "namespaces"},
+		)
+		if len(options.ImagePullSecrets) > 0 {
+			permissions = append(permissions,
+				Permission{Verb: "get", Resource: "secrets", Namespace: options.SecretNamespace},
+				Permission{Verb: "create", Resource: "secrets"},
+			)
+		}
+	}
+	for _, verb := range []string{"create", "delete", "get", "list", "watch", "patch"} {
+		permissions = append(permissions, Permission{Verb: verb, Resource: "pods", Namespace: tracerNamespace})
+	}
+	permissions = append(permissions,
+		Permission{Verb: "create", Resource: "pods", Subresource: "exec", Namespace: tracerNamespace},
+		Permission{Verb: "list", Resource: "events", Namespace: tracerNamespace},
+	)
+	if options.GrantPrivilegedSCC {
+		for _, verb := range []string{"create", "delete"} {
+			permissions = append(permissions,
+				Permission{Verb: verb, Resource: "serviceaccounts", Namespace: tracerNamespace},
+				Permission{Verb: verb, Group: rbacv1.GroupName, Resource: "rolebindings", Namespace: tracerNamespace},
+			)
+		}
+		// Binding a role requires holding its permissions, or being allowed to bind it
+		permissions = append(permissions, Permission{Verb: "bind", Group: rbacv1.GroupName, Resource: "clusterroles", Name: privilegedSCCRole})
+	}
+
+	targetNamespaces := append([]string{}, options.TargetNamespaces...)
+	sort.Strings(targetNamespaces)
+	for i, namespace := range targetNamespaces {
+		if i > 0 && namespace == targetNamespaces[i-1] {
+			continue
+		}
+		permissions = append(permissions, Permission{Verb: "list", Resource: "pods", Namespace: namespace})
+		if options.Redact {
+			permissions = append(permissions, Permission{Verb: "get", Resource: "secrets", Namespace: namespace})
+		}
+	}
+	return permissions
+}
+
+// AccessCheck is the outcome of reviewing a permission for the current user
+type AccessCheck struct {
+	Permission
+	Allowed bool
+	// Reason is the explanation given by the authorizer, if any
+	Reason string
+}
+
+// CheckAccess asks the API server whether the current user holds each permission
+func CheckAccess(ctx context.Context, client kubernetes.Interface, permissions []Permission) ([]AccessCheck, error) {
+	checks := []AccessCheck{}
+	for _, permission := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   permission.Namespace,
+					Verb:        permission.Verb,
+					Group:       permission.Group,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+					Name:        permission.Name,
+				},
+			},
+		}
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to review permission to %s %s. %v", permission.Verb, permission.ResourceName(), err)
+		}
+		checks = append(checks, AccessCheck{
+			Permission: permission,
+			Allowed:    review.Status.Allowed,
+			Reason:     review.Status.Reason,
+		})
+	}
+	return checks, nil
+}
+
+// MissingPermissions returns the checks that were not allowed
+func MissingPermissions(checks []AccessCheck) []AccessCheck {
+	missing := []AccessCheck{}
+	for _, check := range checks {
+		if !check.Allowed {
+			missing = append(missing, check)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,79 @@
+package kstrace
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BundleCollection stores the collection directory in a single compressed tarball next to it and
+// returns the path of the archive. The directory is left in place.
+func BundleCollection(directory string, compression Compression) (string, error) {
+	directory = filepath.Clean(directory)
+	archivePath := directory + ".tar" + compression.Extension()
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	compressor, err := compression.NewWriter(file)
+	if err != nil {
+		return "", err
+	}
+	archive := tar.NewWriter(compressor)
+
+	root := filepath.Dir(directory)
+	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() && !strings.HasSuffix(header.Name, "/") {
+			header.Name += "/"
+		}
+
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		source, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+
+		_, err = io.Copy(archive, source)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+	if err := compressor.Close(); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return archivePath, nil
+}
@@ -0,0 +1,169 @@
+package kstrace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	// Keys without hash preferences fall back to RIPEMD160
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+type Encryption string
+
+const (
+	EncryptionNone Encryption = ""
+	EncryptionAge  Encryption = "age"
+	EncryptionPGP  Encryption = "pgp"
+)
+
+var Encryptions = []Encryption{EncryptionAge, EncryptionPGP}
+
+// Extension is the file extension for a single encrypted stream
+func (encryption Encryption) Extension() string {
+	switch encryption {
+	case EncryptionAge:
+		return ".age"
+	case EncryptionPGP:
+		return ".gpg"
+	}
+	return ""
+}
+
+// EncryptionOf reports how a file was encrypted from its extension
+func EncryptionOf(path string) Encryption {
+	for _, encryption := range Encryptions {
+		if strings.HasSuffix(path, encryption.Extension()) {
+			return encryption
+		}
+	}
+	return EncryptionNone
+}
+
+// Recipients encrypts streams so they can only be read by the holders of the matching private keys
+type Recipients struct {
+	encryption Encryption
+	age        []age.Recipient
+	pgp        openpgp.EntityList
+}
+
+// LoadRecipients reads a file of age recipients or an armored or binary PGP public key ring
+func LoadRecipients(path string) (*Recipients, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyRing, err := readPGPKeyRing(data); err == nil {
+		return &Recipients{encryption: EncryptionPGP, pgp: keyRing}, nil
+	}
+	recipients, err := age.ParseRecipients(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a PGP public key nor a list of age recipients. %v", path, err)
+	}
+	return &Recipients{encryption: EncryptionAge, age: recipients}, nil
+}
+
+func (recipients *Recipients) Encryption() Encryption {
+	return recipients.encryption
+}
+
+// Encrypt wraps the writer so that everything written to it is encrypted. Closing the returned writer
+// does not close the wrapped writer.
+func (recipients *Recipients) Encrypt(writer io.Writer) (io.WriteCloser, error) {
+	if recipients.encryption == EncryptionPGP {
+		return openpgp.Encrypt(writer, recipients.pgp, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	}
+	return age.Encrypt(writer, recipients.age...)
+}
+
+// Identities decrypts streams with a set of private keys
+type Identities struct {
+	age []age.Identity
+	pgp openpgp.EntityList
+}
+
+// LoadIdentities reads a file of age identities or an armored or binary PGP private key ring. PGP keys
+// protected by a passphrase are not supported.
+func LoadIdentities(path string) (*Identities, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyRing, err := readPGPKeyRing(data); err == nil {
+		for _, entity := range keyRing {
+			if entity.PrivateKey == nil {
+				return nil, fmt.Errorf("%q does not contain a PGP private key", path)
+			}
+			if entity.PrivateKey.Encrypted {
+				return nil, fmt.Errorf("the PGP private key in %q is protected by a passphrase. export it without one to decrypt the collection", path)
+			}
+		}
+		return &Identities{pgp: keyRing}, nil
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a PGP private key nor a list of age identities. %v", path, err)
+	}
+	return &Identities{age: identities}, nil
+}
+
+// Decrypt returns a reader of the plain text of the stream
+func (identities *Identities) Decrypt(reader io.Reader, encryption Encryption) (io.Reader, error) {
+	switch encryption {
+	case EncryptionAge:
+		if identities == nil || len(identities.age) == 0 {
+			return nil, fmt.Errorf("an age identity is required to decrypt the collection")
+		}
+		return age.Decrypt(reader, identities.age...)
+	case EncryptionPGP:
+		if identities == nil || len(identities.pgp) == 0 {
+			return nil, fmt.Errorf("a PGP private key is required to decrypt the collection")
+		}
+		message, err := openpgp.ReadMessage(reader, identities.pgp, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return message.UnverifiedBody, nil
+	}
+	return reader, nil
+}
+
+func readPGPKeyRing(data []byte) (openpgp.EntityList, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if block, err := armor.Decode(bytes.NewReader(data)); err == nil {
+		reader = block.Body
+	}
+	keyRing, err := openpgp.ReadKeyRing(reader)
+	if err == nil && len(keyRing) == 0 {
+		err = fmt.Errorf("no PGP keys found")
+	}
+	return keyRing, err
+}
+
+// EncryptedSink encrypts each stream as it is written
+type EncryptedSink struct {
+	Sink       Sink
+	Recipients *Recipients
+}
+
+func (sink *EncryptedSink) Create(name string) (io.WriteCloser, string, error) {
+	writer, storedName, err := sink.Sink.Create(name + sink.Recipients.Encryption().Extension())
+	if err != nil {
+		return nil, "", err
+	}
+
+	encrypter, err := sink.Recipients.Encrypt(writer)
+	if err != nil {
+		writer.Close()
+		return nil, "", err
+	}
+	return &stackedWriteCloser{WriteCloser: encrypter, inner: writer}, storedName, nil
+}
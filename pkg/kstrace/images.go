@@ -0,0 +1,68 @@
+package kstrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+var PullPolicies = []corev1.PullPolicy{corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever}
+
+// ParsePullPolicy validates an image pull policy. An empty policy leaves the cluster default in place.
+func ParsePullPolicy(policy string) (corev1.PullPolicy, error) {
+	if policy == "" {
+		return "", nil
+	}
+	for _, valid := range PullPolicies {
+		if corev1.PullPolicy(policy) == valid {
+			return valid, nil
+		}
+	}
+	return "", fmt.Errorf("invalid image pull policy %q. Available options are %v", policy, PullPolicies)
+}
+
+// MirrorImage replaces the registry of an image with a mirror, which may include a path prefix.
+// For example quay.io/mwasher/crictl:0.0.1 becomes registry.example.com/quay/mwasher/crictl:0.0.1
+// with the mirror registry.example.com/quay.
+func MirrorImage(image string, mirror string) string {
+	mirror = strings.TrimSuffix(mirror, "/")
+	if mirror == "" {
+		return image
+	}
+
+	path := image
+	parts := strings.SplitN(image, "/", 2)
+	// The first component is a registry when it looks like a host, as in the Docker reference format
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		path = parts[1]
+	}
+	return mirror + "/" + path
+}
+
+// CopySecrets copies image pull Secrets into the namespace of the tracer Pods, which kstrace may have just
+// created. Secrets that already exist in the namespace are left unchanged.
+func CopySecrets(ctx context.Context, client kubernetes.Interface, from string, to string, names []string) error {
+	for _, name := range names {
+		secret, err := client.CoreV1().Secrets(from).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to read image pull secret %q from namespace %q. %v", name, from, err)
+		}
+
+		copied := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secret.Name, Namespace: to, Labels: map[string]string{"app": "kstrace"}},
+			Type:       secret.Type,
+			Data:       secret.Data,
+		}
+		_, err = client.CoreV1().Secrets(to).Create(ctx, copied, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("unable to copy image pull secret %q to namespace %q. %v", name, to, err)
+		}
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ package kstrace
 import (
 	"bytes"
 	"context"
-	"errors"
 	"os"
 
 	"fmt"
+	"io"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/buger/jsonparser"
@@ -20,6 +22,8 @@ import (
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+
+	"github.com/michaelwasher/kube-strace/pkg/redact"
 )
 
 type KStracer struct {
@@ -32,7 +36,45 @@ type KStracer struct {
 	restConfig        *rest.Config
 	socketPath        string
 	collectionTimeout time.Duration
+	stackTraces       bool
 	outputDirectory   string
+	observers         []LineObserver
+	stdout            *Multiplexer
+	sink              Sink
+	budget            *Budget
+	redactor          *redact.Redactor
+	pool              *TracerPool
+	result            TraceResult
+}
+
+// KStracerOptions contains the configuration for tracing a single target Pod
+type KStracerOptions struct {
+	Clientset       kubernetes.Interface
+	RestConfig      *rest.Config
+	TraceImage      string
+	TargetPod       *corev1.Pod
+	Namespace       string
+	SocketPath      string
+	Timeout         time.Duration
+	OutputDirectory string
+	Observers       []LineObserver
+
+	// StackTraces records the user space stack of every system call with `strace -k`
+	StackTraces bool
+
+	// Stdout receives the output when OutputDirectory is "-". Defaults to an unprefixed Multiplexer on os.Stdout
+	Stdout *Multiplexer
+	// Sink stores the output of each container. Defaults to plain files within OutputDirectory
+	Sink Sink
+	// Budget limits the output stored across all tracers. Tracing stops once it is exhausted
+	Budget *Budget
+	// Redactor removes secrets from the output before it is stored, streamed or observed. The values of
+	// environment variables sourced from Secrets in the target Pod are also removed
+	Redactor *redact.Redactor
+	// Pool shares tracer Pods between tracers of targets on the same node. Defaults to a pool used only by this tracer
+	Pool *TracerPool
+	// StartTimeout is how long the tracer Pod of the default pool has to start. Defaults to DefaultStartTimeout
+	StartTimeout time.Duration
 }
 
 type PrivilegedPodOptions struct {
@@ -41,24 +83,69 @@ type PrivilegedPodOptions struct {
 	Image         string
 	NodeName      string
 	SocketPath    string
+	// RunID labels the Pod so the tracers of a single run can be found and removed together
+	RunID string
+	// CreatedBy records the user running the trace so leftover Pods can be attributed
+	CreatedBy string
+	// TraceTimeout bounds the life of the Pod, with a margin for it to start and stop. Zero leaves it unbounded
+	TraceTimeout time.Duration
+	// StartTimeout is how long the Pod has to start running. Defaults to DefaultStartTimeout
+	StartTimeout time.Duration
+
+	// Tolerations of the Pod. Defaults to tolerating every taint
+	Tolerations       []corev1.Toleration
+	Resources         corev1.ResourceRequirements
+	PriorityClassName string
+	// Labels and Annotations are added to the Pod alongside those kstrace relies on, which cannot be replaced
+	Labels             map[string]string
+	Annotations        map[string]string
+	ServiceAccountName string
+
+	ImagePullPolicy corev1.PullPolicy
+	// ImagePullSecrets name Secrets in the Pod's namespace
+	ImagePullSecrets []string
 }
 
 type Tracer interface {
-	Start() error
+	// Start traces the target until it exits, the timeout is reached or the context is cancelled
+	Start(ctx context.Context) error
 	Cleanup()
+	Result() TraceResult
 }
 
-func NewKStracer(clientset kubernetes.Interface, restConfig *rest.Config, traceImage string, targetPod *corev1.Pod, namespace string, socketPath string,
-	timeout time.Duration, outputDirectory string) Tracer {
+func NewKStracer(options KStracerOptions) Tracer {
 	straceObject := KStracer{
-		traceImage:        traceImage,
-		traceNamespace:    namespace,
-		restConfig:        restConfig,
-		client:            clientset,
-		targetPod:         targetPod,
-		socketPath:        socketPath,
-		collectionTimeout: timeout,
-		outputDirectory:   outputDirectory,
+		traceImage:        options.TraceImage,
+		traceNamespace:    options.Namespace,
+		restConfig:        options.RestConfig,
+		client:            options.Clientset,
+		targetPod:         options.TargetPod,
+		socketPath:        options.SocketPath,
+		collectionTimeout: options.Timeout,
+		stackTraces:       options.StackTraces,
+		outputDirectory:   options.OutputDirectory,
+		observers:         options.Observers,
+		stdout:            options.Stdout,
+		sink:              options.Sink,
+		budget:            options.Budget,
+		redactor:          options.Redactor,
+		pool:              options.Pool,
+	}
+	if straceObject.stdout == nil {
+		straceObject.stdout = NewMultiplexer(os.Stdout, false, false)
+	}
+	if straceObject.sink == nil {
+		straceObject.sink = &FileSink{Directory: options.OutputDirectory}
+	}
+	if straceObject.pool == nil {
+		straceObject.pool = NewTracerPool(options.Clientset, PrivilegedPodOptions{
+			Namespace:     options.Namespace,
+			ContainerName: "container-name",
+			Image:         options.TraceImage,
+			TraceTimeout:  options.Timeout,
+			StartTimeout:  options.StartTimeout,
+			SocketPath:    options.SocketPath,
+		})
 	}
 
 	return &straceObject
@@ -74,9 +161,22 @@ func getPodDefinition(options PrivilegedPodOptions) *corev1.Pod {
 	objectMetadata := metav1.ObjectMeta{
 		GenerateName: "kstrace-",
 		Namespace:    options.Namespace,
-		Labels: map[string]string{
-			"app": "kstrace",
-		},
+		Labels:       map[string]string{},
+		Annotations:  map[string]string{},
+	}
+	for key, value := range options.Labels {
+		objectMetadata.Labels[key] = value
+	}
+	for key, value := range options.Annotations {
+		objectMetadata.Annotations[key] = value
+	}
+	objectMetadata.Labels["app"] = "kstrace"
+	objectMetadata.Annotations[HeartbeatAnnotation] = heartbeatValue()
+	if options.RunID != "" {
+		objectMetadata.Labels[RunLabel] = options.RunID
+	}
+	if options.CreatedBy != "" {
+		objectMetadata.Annotations[CreatedByAnnotation] = options.CreatedBy
 	}
 
 	// Mount the CRI socket through
@@ -99,11 +199,17 @@ func getPodDefinition(options PrivilegedPodOptions) *corev1.Pod {
 			},
 		},
 	}
+
+	// The Pod stops itself if kstrace stops renewing its heartbeat
+	heartbeat, heartbeatMount := heartbeatVolume()
+	volumes = append(volumes, heartbeat)
+	volumeMounts = append(volumeMounts, heartbeatMount)
 	// Create Privileged container
 	privileged := true
 	privilegedContainer := corev1.Container{
-		Name:  options.ContainerName,
-		Image: options.Image,
+		Name:            options.ContainerName,
+		Image:           options.Image,
+		ImagePullPolicy: options.ImagePullPolicy,
 
 		SecurityContext: &corev1.SecurityContext{
 			Privileged: &privileged,
@@ -115,16 +221,29 @@ func getPodDefinition(options PrivilegedPodOptions) *corev1.Pod {
 			},
 		},
 
-		Command:      []string{"sh", "-c", "sleep 10000000"},
+		Command:      []string{"sh", "-c", watchdogCommand()},
 		VolumeMounts: volumeMounts,
+		Resources:    options.Resources,
+	}
+
+	tolerations := options.Tolerations
+	if tolerations == nil {
+		tolerations = tolerateEverything
 	}
 
 	podSpecs := corev1.PodSpec{
-		NodeName:      options.NodeName,
-		RestartPolicy: corev1.RestartPolicyNever,
-		HostPID:       true,
-		Containers:    []corev1.Container{privilegedContainer},
-		Volumes:       volumes,
+		NodeName:              options.NodeName,
+		RestartPolicy:         corev1.RestartPolicyNever,
+		HostPID:               true,
+		Containers:            []corev1.Container{privilegedContainer},
+		Volumes:               volumes,
+		ActiveDeadlineSeconds: activeDeadline(options.TraceTimeout, options.StartTimeout),
+		Tolerations:           tolerations,
+		PriorityClassName:     options.PriorityClassName,
+		ServiceAccountName:    options.ServiceAccountName,
+	}
+	for _, secret := range options.ImagePullSecrets {
+		podSpecs.ImagePullSecrets = append(podSpecs.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
 	}
 
 	pod := corev1.Pod{
@@ -135,138 +254,293 @@ func getPodDefinition(options PrivilegedPodOptions) *corev1.Pod {
 	return &pod
 }
 
-func waitForPodRunning(clientset kubernetes.Interface, namespace string, pod string) error {
-	// TODO maybe this should be relative to the collection timeout?
-	timeout := time.Second * 30
+func (tracer *KStracer) getIOStream(target TraceTarget, result *ContainerResult) (*genericclioptions.IOStreams, func(), error) {
+	// Special case for std-out
+	if tracer.outputDirectory == "-" {
+		// strace writes the trace to stderr so both streams are multiplexed onto stdout
+		stdout, flushStdout := tracer.stdout.Writer(target)
+		stderr, flushStderr := tracer.stdout.Writer(target)
+
+		iostreams, closeObservers := tracer.observeIOStream(target, &genericclioptions.IOStreams{Out: stdout, In: nil, ErrOut: stderr})
+		iostreams, closeRedaction := tracer.redactIOStream(iostreams)
+		return iostreams, func() {
+			closeRedaction()
+			closeObservers()
+			flushStdout()
+			flushStderr()
+		}, nil
+	}
 
-	checkPodState := func() bool {
+	// Create file for container trace
+	sinkWriter, logFile, err := tracer.sink.Create(filepath.Join(target.Pod, logFileName(target.Container)))
+	if err != nil {
+		log.Infof("Unable to create logfile for the strace collection. %v", err)
+		return nil, nil, err
+	}
+	result.LogFiles = []string{logFile}
+	fileWriter := &syncWriter{writer: sinkWriter}
 
-		podStatus, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), pod, metav1.GetOptions{})
-		if err != nil {
-			return false
+	// Lines are written whole so rotated segments and the output budget always end on a complete line
+	writeLine := func(line string) {
+		if !tracer.budget.Take(len(line) + 1) {
+			return
+		}
+		if _, err := io.WriteString(fileWriter, line+"\n"); err != nil {
+			log.Debugf("Unable to write to logfile %q for the strace collection. %v", logFile, err)
 		}
+	}
+	outLines, errLines := newLineWriter(writeLine), newLineWriter(writeLine)
 
-		if podStatus.Status.Phase == corev1.PodRunning {
-			return true
+	iostreams, closeObservers := tracer.observeIOStream(target, &genericclioptions.IOStreams{
+		Out:    outLines,
+		ErrOut: errLines,
+		In:     nil,
+	})
+	iostreams, closeRedaction := tracer.redactIOStream(iostreams)
+	return iostreams, func() {
+		closeRedaction()
+		closeObservers()
+		outLines.Flush()
+		errLines.Flush()
+
+		if err := sinkWriter.Close(); err != nil {
+			log.Errorf("Unable to close logfile %q for the strace collection. %v", logFile, err)
 		}
+		if segmented, ok := sinkWriter.(SegmentedWriter); ok {
+			result.LogFiles = segmented.Segments()
+		}
+	}, nil
+}
 
-		return false
+// observeIOStream copies the output streams to any configured LineObservers
+func (tracer *KStracer) observeIOStream(target TraceTarget, iostreams *genericclioptions.IOStreams) (*genericclioptions.IOStreams, func()) {
+	if len(tracer.observers) == 0 {
+		return iostreams, func() {}
 	}
 
-	for timeout > 0 {
-		log.Debugf("Waiting for Tracer Pod %q to become ready", pod)
-		if checkPodState() {
-			break
+	notify := func(line string) {
+		for _, observer := range tracer.observers {
+			observer.ObserveLine(target, line)
 		}
+	}
+	// strace writes the trace to stderr so both streams are observed separately to keep lines whole
+	outLines, errLines := newLineWriter(notify), newLineWriter(notify)
+
+	return &genericclioptions.IOStreams{
+		In:     iostreams.In,
+		Out:    io.MultiWriter(iostreams.Out, outLines),
+		ErrOut: io.MultiWriter(iostreams.ErrOut, errLines),
+	}, func() {
+		outLines.Flush()
+		errLines.Flush()
+	}
+}
 
-		// Sleep
-		time.Sleep(time.Second * 2)
-		timeout -= time.Second * 2
+// redactIOStream removes secrets from each line before it reaches the output streams
+func (tracer *KStracer) redactIOStream(iostreams *genericclioptions.IOStreams) (*genericclioptions.IOStreams, func()) {
+	if tracer.redactor == nil {
+		return iostreams, func() {}
 	}
 
-	if timeout <= 0 {
-		return fmt.Errorf("tracer pod did not start correctly. review event objects from namespace %q relating to pod %q for more information", namespace, pod)
+	redactTo := func(writer io.Writer) *lineWriter {
+		return newLineWriter(func(line string) {
+			// Writers further down the stream split the output into lines again
+			_, _ = io.WriteString(writer, tracer.redactor.Redact(line)+"\n")
+		})
+	}
+	outLines, errLines := redactTo(iostreams.Out), redactTo(iostreams.ErrOut)
+
+	return &genericclioptions.IOStreams{
+		In:     iostreams.In,
+		Out:    outLines,
+		ErrOut: errLines,
+	}, func() {
+		outLines.Flush()
+		errLines.Flush()
 	}
-	return nil
 }
 
-func (tracer *KStracer) getIOStream(container string) (*genericclioptions.IOStreams, error) {
-	var err error
+func logFileName(container string) string {
+	return fmt.Sprintf("%s_strace.log", container)
+}
 
-	// Special case for std-out
-	if tracer.outputDirectory == "-" {
-		return &genericclioptions.IOStreams{Out: os.Stdout, In: nil, ErrOut: os.Stderr}, nil
+// Result returns the details of the trace collected so far
+func (tracer *KStracer) Result() TraceResult {
+	return tracer.result
+}
+
+func (tracer *KStracer) Start(ctx context.Context) error {
+	tracer.result = TraceResult{
+		Namespace:   tracer.targetPod.Namespace,
+		Pod:         tracer.targetPod.Name,
+		Owner:       podOwner(ctx, tracer.client, tracer.targetPod),
+		Node:        tracer.targetPod.Spec.NodeName,
+		TracerImage: tracer.traceImage,
+		StartTime:   time.Now(),
 	}
 
-	// Ensure trace folder is present
-	if _, err := os.Stat(tracer.outputDirectory); errors.Is(err, os.ErrNotExist) {
-		err = os.Mkdir(tracer.outputDirectory, 0775)
+	if tracer.redactor != nil {
+		values, err := SecretEnvValues(ctx, tracer.client, tracer.targetPod)
 		if err != nil {
-			log.Infof("Unable to create directory for the strace collection. %v", err)
-			return nil, err
+			log.Warnf("Unable to read every Secret used by pod %q. Some Secret values may not be redacted. %v", tracer.targetPod.Name, err)
 		}
+		tracer.redactor = tracer.redactor.WithValues(values)
 	}
 
-	// Ensure Pod-folder is present
-	podTraceFolder := fmt.Sprintf("%s%c%s", tracer.outputDirectory, os.PathSeparator, tracer.targetPod.Name)
-	if _, err := os.Stat(podTraceFolder); errors.Is(err, os.ErrNotExist) {
-		err = os.Mkdir(podTraceFolder, 0775)
-		if err != nil {
-			log.Infof("Unable to create directory for the strace collection. %v", err)
-			return nil, err
-		}
+	err := tracer.trace(ctx)
+
+	tracer.result.StopTime = time.Now()
+	if ctx.Err() != nil {
+		tracer.result.StopReason = StopReasonInterrupted
 	}
-	// Create file for container trace
-	fileWriter, err := os.Create(fmt.Sprintf("%s/%s/%s_strace.log", tracer.outputDirectory, tracer.targetPod.Name, container))
 	if err != nil {
-		log.Infof("Unable to create logfile for the strace collection. %v", err)
-		return nil, err
+		tracer.result.Error = err.Error()
 	}
 
-	return &genericclioptions.IOStreams{
-		Out:    fileWriter,
-		ErrOut: fileWriter,
-		In:     nil,
-	}, nil
+	// Record how the Pod was traced alongside the collected logs
+	if tracer.outputDirectory != "-" {
+		podTraceFolder := filepath.Join(tracer.outputDirectory, tracer.targetPod.Name)
+		if _, statErr := os.Stat(podTraceFolder); statErr == nil {
+			if manifestErr := WritePodManifest(podTraceFolder, tracer.result); manifestErr != nil {
+				log.Warnf("Unable to write the manifest for pod %q. %v", tracer.targetPod.Name, manifestErr)
+			}
+		}
+	}
+	return err
 }
 
-func (tracer *KStracer) Start() error {
+func (tracer *KStracer) trace(ctx context.Context) error {
 	var err error
-	ctx := context.TODO()
 
-	// Create the Strace Pod
-	options := PrivilegedPodOptions{
-		Namespace:     tracer.traceNamespace,
-		ContainerName: "container-name",
-		Image:         tracer.traceImage,
-		NodeName:      tracer.targetPod.Spec.NodeName,
-		SocketPath:    tracer.socketPath,
-	}
-	tracer.tracePod, err = tracer.CreateStracePod(ctx, options)
+	// Targets on the same node share a Strace Pod
+	tracer.tracePod, err = tracer.pool.Acquire(ctx, tracer.targetPod.Spec.NodeName)
 	if err != nil {
 		return err
 	}
+	tracer.result.TracerPod = tracer.tracePod.Name
+	tracer.result.StraceVersion = tracer.FindStraceVersion(ctx)
 
 	// Find out the PID for the requested Pod
 	log.Infof("Running strace on pod %q", tracer.targetPod.Name)
-	tracer.containerPIDs, err = tracer.FindPodPIDs()
+	tracer.containerPIDs, err = tracer.FindPodPIDs(ctx)
 	if err != nil {
 		return err
 	}
 
-	// Run Strace for collected containerPIDs
+	// Streams outlive the context so strace can flush its output once interrupted. They are abandoned if
+	// strace does not exit within the grace period.
+	streamCtx, cancelStreams := context.WithCancel(context.Background())
+	defer cancelStreams()
+
+	// Run Strace for collected containerPIDs concurrently
+	var straceWaitGroup sync.WaitGroup
+	straceErrors := make(chan error, len(tracer.containerPIDs))
+	tracer.result.Containers = make([]ContainerResult, len(tracer.containerPIDs))
+
 	for index, containerPID := range tracer.containerPIDs {
 		log.Debugf("Running strace on container %d", containerPID)
 
+		// PIDs are discovered in the order of the container statuses
+		containerStatus := tracer.targetPod.Status.ContainerStatuses[index]
+		target := TraceTarget{
+			Namespace: tracer.targetPod.Namespace,
+			Pod:       tracer.targetPod.Name,
+			PodUID:    string(tracer.targetPod.UID),
+			Container: containerStatus.Name,
+			Node:      tracer.targetPod.Spec.NodeName,
+			PID:       containerPID,
+		}
+		containerResult := &tracer.result.Containers[index]
+		*containerResult = ContainerResult{
+			Name:        containerStatus.Name,
+			Image:       containerStatus.Image,
+			ContainerID: containerStatus.ContainerID,
+			PID:         containerPID,
+			Mounts:      containerMounts(tracer.targetPod, containerStatus.Name),
+		}
+
 		// Write to a file with the container name
-		iostream, err := tracer.getIOStream(tracer.targetPod.Spec.Containers[index].Name)
+		iostream, closeStream, err := tracer.getIOStream(target, containerResult)
 		if err != nil {
 			return err
 		}
 
-		err = tracer.StartStrace(containerPID, iostream)
-		if err != nil {
-			return err
+		straceWaitGroup.Add(1)
+		go func(containerPID int64) {
+			defer straceWaitGroup.Done()
+			defer closeStream()
+
+			containerResult.StartTime = time.Now()
+			containerResult.Command = tracer.straceCommand(containerPID)
+			exitCode, err := tracer.StartStrace(streamCtx, containerPID, iostream)
+			containerResult.StopTime = time.Now()
+			containerResult.ExitCode = exitCode
+			if err != nil {
+				containerResult.Error = err.Error()
+			}
+
+			straceErrors <- err
+		}(containerPID)
+	}
+
+	// Stop every strace gracefully once the collection has reached its size limit or has been cancelled
+	straceDone, stopperDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopperDone)
+
+		select {
+		case <-tracer.budget.Exhausted():
+			tracer.result.StopReason = tracer.budget.Reason()
+			tracer.stopStraces()
+		case <-ctx.Done():
+			tracer.result.StopReason = StopReasonInterrupted
+			tracer.stopStraces()
+			select {
+			case <-straceDone:
+			case <-time.After(stopGracePeriod):
+				log.Warnf("Strace in pod %q did not stop in time. Its output may be incomplete.", tracer.tracePod.Name)
+				cancelStreams()
+			}
+		case <-straceDone:
+		}
+	}()
+
+	straceWaitGroup.Wait()
+	close(straceDone)
+	<-stopperDone
+	close(straceErrors)
+
+	for straceErr := range straceErrors {
+		if straceErr != nil {
+			return straceErr
 		}
 	}
 
 	log.Info("Strace complete")
-	return err
+	return nil
 }
 
 func (tracer *KStracer) CreateStracePod(ctx context.Context, options PrivilegedPodOptions) (*corev1.Pod, error) {
+	return createTracerPod(ctx, tracer.client, options)
+}
+
+func createTracerPod(ctx context.Context, client kubernetes.Interface, options PrivilegedPodOptions) (*corev1.Pod, error) {
 	// TODO: ensure that the target pod is actually active
 	podDefinition := getPodDefinition(options)
-	createdPod, err := tracer.client.CoreV1().Pods(options.Namespace).Create(ctx, podDefinition, metav1.CreateOptions{})
+	createdPod, err := client.CoreV1().Pods(options.Namespace).Create(ctx, podDefinition, metav1.CreateOptions{})
 	if err != nil {
-		return nil, err
+		return nil, describeCreateError(ctx, client, err, options.Namespace)
 	}
 
 	log.Infof("creating privileged pod %q in namespace %q on node %q", createdPod.Name, createdPod.Namespace, createdPod.Spec.NodeName)
 	log.Tracef("creating privileged pod with the following options: { %v }", options)
 
-	err = waitForPodRunning(tracer.client, createdPod.Namespace, createdPod.Name)
+	err = waitForPodRunning(ctx, client, createdPod.Namespace, createdPod.Name, options.StartTimeout)
 	if err != nil {
+		// The Pod is privileged, so it is not left behind for a later attempt to duplicate
+		if deleteErr := deletePod(client, createdPod.Namespace, createdPod.Name); deleteErr != nil {
+			log.Errorf("Unable to delete the tracer pod %q that failed to start. Manual deletion is required. %v", createdPod.Name, deleteErr)
+		}
 		return nil, err
 	}
 
@@ -275,58 +549,133 @@ func (tracer *KStracer) CreateStracePod(ctx context.Context, options PrivilegedP
 	return createdPod, nil
 }
 
-func (tracer *KStracer) StartStrace(targetPID int64, iostreams *genericclioptions.IOStreams) error {
-	command := fmt.Sprintf("strace -tfp %d", targetPID)
+func stracePIDFile(targetPID int64) string {
+	return fmt.Sprintf("/tmp/kstrace-%d.pid", targetPID)
+}
+
+func (tracer *KStracer) straceCommand(targetPID int64) string {
+	// Timestamps, durations and file descriptor details are recorded so the output can be analysed and exported.
+	// Timestamps are printed as seconds since the epoch so they do not depend on the timezone of either machine.
+	command := fmt.Sprintf("strace -f -ttt -T -yy -p %d", targetPID)
+	if tracer.stackTraces {
+		command = fmt.Sprintf("strace -f -ttt -T -yy -k -p %d", targetPID)
+	}
 
 	// Configure Command Timeout
 	if tracer.collectionTimeout != 0 {
 		command = fmt.Sprintf("timeout -s 2 --preserve-status %f %s", tracer.collectionTimeout.Seconds(), command)
 	}
 
+	// Record the PID of the trace so it can be stopped early
+	return fmt.Sprintf("echo $$ > %s && exec %s", stracePIDFile(targetPID), command)
+}
+
+// StopReasonInterrupted records that the trace was cancelled before it completed
+const StopReasonInterrupted = "the trace was interrupted"
+
+// stopGracePeriod is how long strace has to flush its output and exit once it is stopped
+const stopGracePeriod = 10 * time.Second
+
+// stopStraces interrupts every strace of the target. The requests are not tied to the trace context as they
+// are usually made once it has been cancelled.
+func (tracer *KStracer) stopStraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), stopGracePeriod)
+	defer cancel()
+
+	for _, containerPID := range tracer.containerPIDs {
+		if err := tracer.StopStrace(ctx, containerPID); err != nil {
+			log.Errorf("Unable to stop strace for PID %d in pod %q. %v", containerPID, tracer.tracePod.Name, err)
+		}
+	}
+}
+
+// StopStrace interrupts a running strace so that it detaches and exits cleanly
+func (tracer *KStracer) StopStrace(ctx context.Context, targetPID int64) error {
+	command := fmt.Sprintf("kill -INT $(cat %s)", stracePIDFile(targetPID))
+	log.Infof("Running command %q inside pod %q", command, tracer.tracePod.Name)
+
+	iostreams := &genericclioptions.IOStreams{
+		In: nil, Out: new(bytes.Buffer), ErrOut: new(bytes.Buffer),
+	}
+	execRequest := ExecRequest{
+		Client: tracer.client, RestConfig: tracer.restConfig, PodName: tracer.tracePod.Name,
+		Namespace: tracer.tracePod.Namespace, Command: command, IOStreams: iostreams, TTY: false,
+	}
+	exitCode, err := ExecCommand(ctx, execRequest)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("the command %q has failed with exit code: %d", command, exitCode)
+	}
+	return nil
+}
+
+func (tracer *KStracer) StartStrace(ctx context.Context, targetPID int64, iostreams *genericclioptions.IOStreams) (int, error) {
+	command := tracer.straceCommand(targetPID)
+
 	log.Infof("Running command %q inside pod %q", command, tracer.tracePod.Name)
 
 	execRequest := ExecRequest{
 		Client: tracer.client, RestConfig: tracer.restConfig, PodName: tracer.tracePod.Name,
 		Namespace: tracer.tracePod.Namespace, Command: command, TTY: false, IOStreams: iostreams,
 	}
-	exitCode, err := ExecCommand(execRequest)
+	exitCode, err := ExecCommand(ctx, execRequest)
 
 	// NOTE: ExitCode 130 is the response from strace after getting `Ctrl+C`
 	if exitCode != 0 && exitCode != 130 {
-		return fmt.Errorf("the function has failed with exit code: %d", exitCode)
+		return exitCode, fmt.Errorf("the function has failed with exit code: %d", exitCode)
 	}
 	log.Infof("Strace command for Pod %q complete", tracer.tracePod.Name)
 
 	if err != nil {
-		return err
+		return exitCode, err
 	}
 
-	return nil
+	return exitCode, nil
+}
+
+// FindStraceVersion reports the version of strace available in the tracer Pod
+func (tracer *KStracer) FindStraceVersion(ctx context.Context) string {
+	iostreams := &genericclioptions.IOStreams{
+		In: nil, Out: new(bytes.Buffer), ErrOut: new(bytes.Buffer),
+	}
+
+	execRequest := ExecRequest{
+		Client: tracer.client, RestConfig: tracer.restConfig, PodName: tracer.tracePod.Name,
+		Namespace: tracer.tracePod.Namespace, Command: "strace -V", IOStreams: iostreams, TTY: false,
+	}
+	exitCode, err := ExecCommand(ctx, execRequest)
+	if exitCode != 0 || err != nil {
+		log.Debugf("Unable to find the strace version in pod %q. %v", tracer.tracePod.Name, err)
+		return ""
+	}
+
+	// The first line reads "strace -- version X.Y"
+	output := strings.SplitN(iostreams.Out.(*bytes.Buffer).String(), "\n", 2)[0]
+	return strings.TrimSpace(strings.TrimPrefix(output, "strace -- version"))
 }
 func (tracer *KStracer) Cleanup() {
-	ctx := context.TODO()
-	// Delete Pod
+	// Release the Pod, which is deleted once every target on the node is traced
 	if tracer == nil || tracer.tracePod == nil {
 		return
 	}
 
-	err := tracer.client.CoreV1().Pods(tracer.tracePod.Namespace).Delete(ctx, tracer.tracePod.Name, metav1.DeleteOptions{})
-	if err != nil {
-		log.Fatalf("unable to delete strace pod %q from namespace %q. manual deletion is required.", tracer.tracePod.Name, tracer.tracePod.Namespace)
+	if err := tracer.pool.Release(tracer.targetPod.Spec.NodeName); err != nil {
+		log.Errorf("%v", err)
 	}
 	tracer.tracePod = nil
 }
 
-func (tracer *KStracer) FindPodPIDs() ([]int64, error) {
-	// Specific to Crictl
-	iostreams := &genericclioptions.IOStreams{
-		In: nil, Out: new(bytes.Buffer), ErrOut: new(bytes.Buffer),
-	}
-
+func (tracer *KStracer) FindPodPIDs(ctx context.Context) ([]int64, error) {
 	// Get all Container IDs for Pod
 	containerPIDs := []int64{}
 
 	for _, containerStatus := range tracer.targetPod.Status.ContainerStatuses {
+		// Specific to Crictl
+		iostreams := &genericclioptions.IOStreams{
+			In: nil, Out: new(bytes.Buffer), ErrOut: new(bytes.Buffer),
+		}
 
 		containerID := strings.SplitAfter(containerStatus.ContainerID, "//")[1]
 
@@ -337,7 +686,7 @@ func (tracer *KStracer) FindPodPIDs() ([]int64, error) {
 			Client: tracer.client, RestConfig: tracer.restConfig, PodName: tracer.tracePod.Name,
 			Namespace: tracer.tracePod.Namespace, Command: command, IOStreams: iostreams, TTY: false,
 		}
-		exitCode, err := ExecCommand(execRequest)
+		exitCode, err := ExecCommand(ctx, execRequest)
 		if exitCode != 0 || err != nil {
 			return nil, err
 		}
@@ -1,15 +1,40 @@
 package kstrace
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/klauspost/compress/zstd"
+
 	"k8s.io/client-go/kubernetes/fake"
 	testingcore "k8s.io/client-go/testing"
+
+	"github.com/michaelwasher/kube-strace/pkg/redact"
 )
 
 func SetPodStatusPhaseRunning(action testingcore.Action) (handled bool, ret runtime.Object, err error) {
@@ -76,7 +101,7 @@ func TestCreatePrivilegedPod(t *testing.T) {
 func TestCreateNamespace(t *testing.T) {
 	ctx := context.TODO()
 	clientset := fake.NewSimpleClientset()
-	namespace, err := CreateNamespace(ctx, clientset)
+	namespace, err := CreateNamespace(ctx, clientset, "alice")
 	if err != nil {
 		t.Errorf("Unable to create namespace. %v", err)
 	}
@@ -85,5 +110,1217 @@ func TestCreateNamespace(t *testing.T) {
 	if err != nil || resultNamespace.Name != namespace.Name {
 		t.Errorf("Unable to find the created namespace. %v", err)
 	}
+	if resultNamespace.Annotations[CreatedByAnnotation] != "alice" {
+		t.Errorf("Expected the namespace to record who created it. Got %v", resultNamespace.Annotations)
+	}
+
+}
+
+func TestMultiplexer(t *testing.T) {
+	out := new(bytes.Buffer)
+	mux := NewMultiplexer(out, true, false)
+
+	targets := []TraceTarget{{Pod: "pod-a", Container: "app"}, {Pod: "pod-b", Container: "sidecar"}}
+	var waitGroup sync.WaitGroup
+	for _, target := range targets {
+		writer, flush := mux.Writer(target)
+
+		waitGroup.Add(1)
+		go func(target TraceTarget) {
+			defer waitGroup.Done()
+			defer flush()
+
+			// Write each line in fragments to ensure partial writes are never interleaved
+			for i := 0; i < 100; i++ {
+				line := fmt.Sprintf("read(3, \"%s\", 10) = %d\n", target.Pod, i)
+				writer.Write([]byte(line[:5]))
+				writer.Write([]byte(line[5:]))
+			}
+			writer.Write([]byte("unterminated"))
+		}(target)
+	}
+	waitGroup.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 202 {
+		t.Fatalf("Line count mismatch. Expected %d, Got: %d", 202, len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "pod-a/app ") && !strings.HasPrefix(line, "pod-b/sidecar ") {
+			t.Errorf("Line missing prefix: %q", line)
+			continue
+		}
+		pod := strings.SplitN(line, "/", 2)[0]
+		if !strings.HasSuffix(line, "unterminated") && !strings.Contains(line, fmt.Sprintf("\"%s\"", pod)) {
+			t.Errorf("Line was interleaved: %q", line)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	directory := t.TempDir()
+	startTime := time.Date(2021, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	manifest := Manifest{
+		Version:   "0.0.1",
+		Command:   []string{"kubectl-strace", "pod/target-pod"},
+		StartTime: startTime,
+		Pods: []TraceResult{{
+			Pod:        "target-pod",
+			Containers: []ContainerResult{{Name: "app", PID: 42, ExitCode: 130}},
+		}},
+	}
+	if err := WriteManifest(directory, manifest); err != nil {
+		t.Fatalf("Unable to write the manifest. %v", err)
+	}
+
+	result, err := ReadManifest(directory)
+	if err != nil {
+		t.Fatalf("Unable to read the manifest. %v", err)
+	}
+	if !result.StartTime.Equal(startTime) || len(result.Pods) != 1 || result.Pods[0].Containers[0].PID != 42 {
+		t.Errorf("Manifest mismatch. Expected %+v, Got: %+v", manifest, *result)
+	}
+}
+
+func TestCompressedSink(t *testing.T) {
+	tests := []struct {
+		compression  Compression
+		expectedName string
+		decompress   func(io.Reader) (io.Reader, error)
+	}{{
+		compression:  CompressionGzip,
+		expectedName: "pod/app_strace.log.gz",
+		decompress: func(reader io.Reader) (io.Reader, error) {
+			return gzip.NewReader(reader)
+		},
+	}, {
+		compression:  CompressionZstd,
+		expectedName: "pod/app_strace.log.zst",
+		decompress: func(reader io.Reader) (io.Reader, error) {
+			return zstd.NewReader(reader)
+		},
+	}}
+
+	for _, tc := range tests {
+		t.Run(string(tc.compression), func(t *testing.T) {
+			directory := t.TempDir()
+			sink := &CompressedSink{Sink: &FileSink{Directory: directory}, Compression: tc.compression}
+
+			writer, name, err := sink.Create("pod/app_strace.log")
+			if err != nil {
+				t.Fatalf("Unable to create the sink. %v", err)
+			}
+			if name != tc.expectedName {
+				t.Errorf("Name mismatch. Expected %q, Got: %q", tc.expectedName, name)
+			}
+			fmt.Fprintln(writer, "close(3) = 0")
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Unable to close the sink. %v", err)
+			}
+
+			file, err := os.Open(filepath.Join(directory, name))
+			if err != nil {
+				t.Fatalf("Unable to open the compressed file. %v", err)
+			}
+			defer file.Close()
+
+			reader, err := tc.decompress(file)
+			if err != nil {
+				t.Fatalf("Unable to decompress the file. %v", err)
+			}
+			content, err := io.ReadAll(reader)
+			if err != nil || string(content) != "close(3) = 0\n" {
+				t.Errorf("Content mismatch. Got: %q, %v", content, err)
+			}
+		})
+	}
+}
+
+func TestBundleCollection(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "strace-collection")
+	if err := os.MkdirAll(filepath.Join(directory, "pod"), 0775); err != nil {
+		t.Fatalf("Unable to create the collection. %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "pod", "app_strace.log"), []byte("close(3) = 0\n"), 0664); err != nil {
+		t.Fatalf("Unable to create the collection. %v", err)
+	}
+
+	archivePath, err := BundleCollection(directory, CompressionGzip)
+	if err != nil {
+		t.Fatalf("Unable to bundle the collection. %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(directory, "pod", "app_strace.log")); err != nil {
+		t.Errorf("Expected the collection directory to be kept. %v", err)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("Unable to open the archive. %v", err)
+	}
+	defer file.Close()
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Unable to decompress the archive. %v", err)
+	}
+
+	names := []string{}
+	archive := tar.NewReader(decompressed)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read the archive. %v", err)
+		}
+		names = append(names, header.Name)
+	}
+
+	expected := []string{"strace-collection/", "strace-collection/pod/", "strace-collection/pod/app_strace.log"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Archive mismatch. Expected %v, Got: %v", expected, names)
+	}
+}
+
+func TestRotatingSink(t *testing.T) {
+	directory := t.TempDir()
+	sink := &RotatingSink{Sink: &FileSink{Directory: directory}, MaxSize: 30}
+
+	writer, name, err := sink.Create("pod/app_strace.log")
+	if err != nil {
+		t.Fatalf("Unable to create the sink. %v", err)
+	}
+	if name != "pod/app_strace.log" {
+		t.Errorf("Name mismatch. Expected %q, Got: %q", "pod/app_strace.log", name)
+	}
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(writer, "close(%d) = 0 <0.000010>\n", i)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Unable to close the sink. %v", err)
+	}
+
+	segments := writer.(SegmentedWriter).Segments()
+	expected := []string{"pod/app_strace.log", "pod/app_strace.log.1", "pod/app_strace.log.2", "pod/app_strace.log.3", "pod/app_strace.log.4"}
+	if strings.Join(segments, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Segment mismatch. Expected %v, Got: %v", expected, segments)
+	}
+	for i, segment := range segments {
+		content, err := os.ReadFile(filepath.Join(directory, segment))
+		if err != nil || string(content) != fmt.Sprintf("close(%d) = 0 <0.000010>\n", i) {
+			t.Errorf("Segment %q content mismatch. Got: %q, %v", segment, content, err)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	budget := NewBudget(10)
+
+	if !budget.Take(6) || !budget.Take(4) {
+		t.Fatalf("Expected the budget to allow 10 bytes")
+	}
+	select {
+	case <-budget.Exhausted():
+		t.Fatalf("Budget exhausted before the limit was exceeded")
+	default:
+	}
+
+	if budget.Take(1) {
+		t.Errorf("Expected the budget to be exhausted")
+	}
+	select {
+	case <-budget.Exhausted():
+	default:
+		t.Errorf("Expected the exhausted channel to be closed")
+	}
+
+	var unlimited *Budget
+	if !unlimited.Take(1 << 40) {
+		t.Errorf("Expected a nil budget to be unlimited")
+	}
+}
+
+func TestClusterResolver(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{ClusterIPs: []string{"10.96.0.10"}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "web"},
+			Status:     corev1.PodStatus{PodIPs: []corev1.PodIP{{IP: "10.0.0.9"}}},
+		},
+	)
+
+	resolver, err := NewClusterResolver(clientset, nil)
+	if err != nil {
+		t.Fatalf("Unable to create the resolver. %v", err)
+	}
+
+	tests := map[string]string{"10.96.0.10": "service/default/postgres", "10.0.0.9": "pod/web/web-0", "8.8.8.8": ""}
+	for address, expected := range tests {
+		if resource := resolver.Resolve(address); resource != expected {
+			t.Errorf("Resolve(%q) mismatch. Expected %q, Got: %q", address, expected, resource)
+		}
+	}
+}
+
+func TestSecretEnvValues(t *testing.T) {
+	optional := true
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "web"},
+			Data:       map[string][]byte{"password": []byte("hunter22"), "user": []byte("admin")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "web"},
+			Data:       map[string][]byte{"token": []byte("t0k3n-value")},
+		},
+	)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "web"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "app",
+			Env: []corev1.EnvVar{
+				{Name: "PLAIN", Value: "not-a-secret"},
+				{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password",
+				}}},
+				{Name: "OPTIONAL", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Key: "value", Optional: &optional,
+				}}},
+			},
+			EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "api"}}}},
+		}}},
+	}
+
+	values, err := SecretEnvValues(context.TODO(), clientset, pod)
+	if err != nil {
+		t.Fatalf("Expected missing optional Secrets to be ignored. %v", err)
+	}
+	if fmt.Sprint(values) != "[t0k3n-value hunter22]" {
+		t.Errorf("Secret value mismatch. Got: %v", values)
+	}
+
+	pod.Spec.Containers[0].EnvFrom[0].SecretRef.Name = "deleted"
+	if values, err := SecretEnvValues(context.TODO(), clientset, pod); err == nil || fmt.Sprint(values) != "[hunter22]" {
+		t.Errorf("Expected the readable values and an error for the missing Secret. Got: %v, %v", values, err)
+	}
+}
+
+type recordingObserver struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (observer *recordingObserver) ObserveLine(target TraceTarget, line string) {
+	observer.mutex.Lock()
+	defer observer.mutex.Unlock()
+	observer.lines = append(observer.lines, line)
+}
+
+func TestRedactedIOStream(t *testing.T) {
+	redactor, err := redact.New(nil)
+	if err != nil {
+		t.Fatalf("Unable to create the redactor. %v", err)
+	}
+	directory := t.TempDir()
+	observer := &recordingObserver{}
+	tracer := NewKStracer(KStracerOptions{
+		OutputDirectory: directory,
+		Observers:       []LineObserver{observer},
+		Redactor:        redactor.WithValues([]string{"hunter22"}),
+	}).(*KStracer)
+
+	target := TraceTarget{Pod: "web-0", Container: "app"}
+	iostreams, closeStream, err := tracer.getIOStream(target, &ContainerResult{})
+	if err != nil {
+		t.Fatalf("Unable to create the streams. %v", err)
+	}
+	iostreams.ErrOut.Write([]byte(`write(3, "Authorization: Bearer abc`))
+	iostreams.ErrOut.Write([]byte("def\\r\\n\", 31) = 31\nwrite(1, \"hunter22\", 8) = 8"))
+	closeStream()
+
+	expected := []string{
+		`write(3, "Authorization: Bearer [REDACTED:bearer-token]\r\n", 31) = 31`,
+		`write(1, "[REDACTED:secret]", 8) = 8`,
+	}
+	data, err := os.ReadFile(filepath.Join(directory, "web-0", "app_strace.log"))
+	if err != nil {
+		t.Fatalf("Unable to read the log. %v", err)
+	}
+	if string(data) != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("Stored output was not redacted. Got:\n%s", data)
+	}
+	if fmt.Sprint(observer.lines) != fmt.Sprint(expected) {
+		t.Errorf("Observed output was not redacted. Got: %q", observer.lines)
+	}
+}
+
+// writeKeys generates a key pair and stores the public and private halves in the directory
+func writeKeys(t *testing.T, directory string, encryption Encryption) (string, string) {
+	t.Helper()
+	recipientFile, identityFile := filepath.Join(directory, "recipient"), filepath.Join(directory, "identity")
+
+	public, private := new(bytes.Buffer), new(bytes.Buffer)
+	switch encryption {
+	case EncryptionAge:
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("Unable to generate an age identity. %v", err)
+		}
+		fmt.Fprintln(public, "# kstrace test recipient")
+		fmt.Fprintln(public, identity.Recipient().String())
+		fmt.Fprintln(private, identity.String())
+	case EncryptionPGP:
+		entity, err := openpgp.NewEntity("kstrace", "test", "kstrace@example.com", nil)
+		if err != nil {
+			t.Fatalf("Unable to generate a PGP key. %v", err)
+		}
+		armored, err := armor.Encode(public, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatalf("Unable to armor the PGP key. %v", err)
+		}
+		if err := entity.Serialize(armored); err != nil {
+			t.Fatalf("Unable to serialise the PGP public key. %v", err)
+		}
+		armored.Close()
+		// Private keys are written unarmored to cover binary key rings
+		if err := entity.SerializePrivate(private, nil); err != nil {
+			t.Fatalf("Unable to serialise the PGP private key. %v", err)
+		}
+	}
+
+	if err := os.WriteFile(recipientFile, public.Bytes(), 0600); err != nil {
+		t.Fatalf("Unable to write the recipient. %v", err)
+	}
+	if err := os.WriteFile(identityFile, private.Bytes(), 0600); err != nil {
+		t.Fatalf("Unable to write the identity. %v", err)
+	}
+	return recipientFile, identityFile
+}
+
+func TestEncryptedSink(t *testing.T) {
+	for _, encryption := range Encryptions {
+		t.Run(string(encryption), func(t *testing.T) {
+			directory := t.TempDir()
+			recipientFile, identityFile := writeKeys(t, t.TempDir(), encryption)
+
+			recipients, err := LoadRecipients(recipientFile)
+			if err != nil {
+				t.Fatalf("Unable to load the recipients. %v", err)
+			}
+			if recipients.Encryption() != encryption {
+				t.Errorf("Encryption mismatch. Expected %q, Got: %q", encryption, recipients.Encryption())
+			}
+			if _, err := LoadIdentities(recipientFile); err == nil {
+				t.Errorf("Expected a public key to be rejected as an identity")
+			}
+
+			sink := &CompressedSink{Sink: &EncryptedSink{Sink: &FileSink{Directory: directory}, Recipients: recipients}, Compression: CompressionGzip}
+			writer, name, err := sink.Create("pod/app_strace.log")
+			if err != nil {
+				t.Fatalf("Unable to create the sink. %v", err)
+			}
+			if expected := "pod/app_strace.log.gz" + encryption.Extension(); name != expected || EncryptionOf(name) != encryption {
+				t.Errorf("Name mismatch. Expected %q, Got: %q", expected, name)
+			}
+			fmt.Fprintln(writer, "close(3) = 0")
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Unable to close the sink. %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(directory, name))
+			if err != nil {
+				t.Fatalf("Unable to read the encrypted file. %v", err)
+			}
+			if _, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+				t.Errorf("Expected the file to be encrypted")
+			}
+
+			identities, err := LoadIdentities(identityFile)
+			if err != nil {
+				t.Fatalf("Unable to load the identities. %v", err)
+			}
+			plainText, err := identities.Decrypt(bytes.NewReader(data), encryption)
+			if err != nil {
+				t.Fatalf("Unable to decrypt the file. %v", err)
+			}
+			reader, err := gzip.NewReader(plainText)
+			if err != nil {
+				t.Fatalf("Unable to decompress the file. %v", err)
+			}
+			content, err := io.ReadAll(reader)
+			if err != nil || string(content) != "close(3) = 0\n" {
+				t.Errorf("Content mismatch. Got: %q, %v", content, err)
+			}
+
+			var missing *Identities
+			if _, err := missing.Decrypt(bytes.NewReader(data), encryption); err == nil {
+				t.Errorf("Expected decryption without an identity to fail")
+			}
+		})
+	}
+}
+
+func TestTracerPool(t *testing.T) {
+	ctx := context.TODO()
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", SetPodStatusPhaseRunning)
+	// The fake clientset does not generate names
+	var created int32
+	clientset.PrependReactor("create", "pods", func(action testingcore.Action) (bool, runtime.Object, error) {
+		pod := action.(testingcore.CreateAction).GetObject().(*corev1.Pod)
+		pod.Name = fmt.Sprintf("%s%d", pod.GenerateName, atomic.AddInt32(&created, 1))
+		return false, nil, nil
+	})
+	failures := 1
+	clientset.PrependReactor("create", "pods", func(action testingcore.Action) (bool, runtime.Object, error) {
+		pod := action.(testingcore.CreateAction).GetObject().(*corev1.Pod)
+		if pod.Spec.NodeName == "node-c" && failures > 0 {
+			failures--
+			return true, nil, fmt.Errorf("quota exceeded")
+		}
+		return false, nil, nil
+	})
+	pool := NewTracerPool(clientset, PrivilegedPodOptions{Namespace: "kstrace", ContainerName: "container-name", Image: "imagename"})
+
+	// Targets on the same node acquire the pool concurrently
+	nodes := []string{"node-a", "node-a", "node-a", "node-b"}
+	pods := make([]*corev1.Pod, len(nodes))
+	var waitGroup sync.WaitGroup
+	for index, node := range nodes {
+		waitGroup.Add(1)
+		go func(index int, node string) {
+			defer waitGroup.Done()
+			pod, err := pool.Acquire(ctx, node)
+			if err != nil {
+				t.Errorf("Unable to acquire a tracer pod on %q. %v", node, err)
+			}
+			pods[index] = pod
+		}(index, node)
+	}
+	waitGroup.Wait()
+
+	tracerPods := func() int {
+		list, err := clientset.CoreV1().Pods("kstrace").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Unable to list the tracer pods. %v", err)
+		}
+		return len(list.Items)
+	}
+	if count := tracerPods(); count != 2 {
+		t.Fatalf("Expected one tracer pod per node. Got: %d", count)
+	}
+	if pods[0].Name != pods[1].Name || pods[1].Name != pods[2].Name || pods[0].Spec.NodeName != "node-a" {
+		t.Errorf("Expected the targets on node-a to share a tracer pod")
+	}
+
+	// The pod is only deleted once every target on the node has released it
+	pool.Release("node-a")
+	pool.Release("node-a")
+	if count := tracerPods(); count != 2 {
+		t.Errorf("Expected the tracer pod to be kept while it is in use. Got: %d pods", count)
+	}
+	pool.Release("node-a")
+	pool.Release("node-b")
+	if count := tracerPods(); count != 0 {
+		t.Errorf("Expected the tracer pods to be deleted once released. Got: %d pods", count)
+	}
+
+	// A failed tracer pod is retried by the next target
+	if _, err := pool.Acquire(ctx, "node-c"); err == nil {
+		t.Errorf("Expected the tracer pod creation to fail")
+	}
+	if _, err := pool.Acquire(ctx, "node-c"); err != nil {
+		t.Errorf("Expected the tracer pod to be created on the next attempt. %v", err)
+	}
+	pool.Release("node-c")
+}
+
+func TestCleanupTracerPods(t *testing.T) {
+	ctx := context.TODO()
+	pod := func(name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shared", Labels: labels}}
+	}
+	clientset := fake.NewSimpleClientset(
+		pod("kstrace-ours", map[string]string{"app": "kstrace", RunLabel: "run-a"}),
+		pod("kstrace-other-run", map[string]string{"app": "kstrace", RunLabel: "run-b"}),
+		pod("workload", map[string]string{"app": "web"}),
+	)
+
+	definition := getPodDefinition(PrivilegedPodOptions{Namespace: "shared", RunID: "run-a"})
+	if definition.Labels[RunLabel] != "run-a" {
+		t.Errorf("Expected the tracer pod to be labelled with the run. Got %v", definition.Labels)
+	}
+
+	if err := CleanupTracerPods(clientset, "shared", "run-a"); err != nil {
+		t.Errorf("Unable to remove the tracer pods. %v", err)
+	}
+
+	list, err := clientset.CoreV1().Pods("shared").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := []string{}
+	for _, pod := range list.Items {
+		remaining = append(remaining, pod.Name)
+	}
+	sort.Strings(remaining)
+	if !reflect.DeepEqual(remaining, []string{"kstrace-other-run", "workload"}) {
+		t.Errorf("Expected only the pods of the run to be removed. Got %v", remaining)
+	}
+}
+
+func TestCleanupNamespace(t *testing.T) {
+	defaultBackoff := cleanupBackoff
+	defer func() { cleanupBackoff = defaultBackoff }()
+	cleanupBackoff.Duration = time.Millisecond
+
+	tests := []struct {
+		name      string
+		failures  int
+		expectErr bool
+	}{
+		{name: "first attempt", failures: 0},
+		{name: "transient failures", failures: 2},
+		{name: "persistent failures", failures: 10, expectErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kstrace-abc"}})
+			attempts, failures := 0, test.failures
+			clientset.PrependReactor("delete", "namespaces", func(action testingcore.Action) (bool, runtime.Object, error) {
+				attempts++
+				if failures > 0 {
+					failures--
+					return true, nil, fmt.Errorf("connection refused")
+				}
+				return false, nil, nil
+			})
+
+			err := CleanupNamespace(clientset, "kstrace-abc")
+			if (err != nil) != test.expectErr {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if test.expectErr && attempts != cleanupBackoff.Steps {
+				t.Errorf("Expected %d attempts. Got %d", cleanupBackoff.Steps, attempts)
+			}
+			if !test.expectErr && attempts != test.failures+1 {
+				t.Errorf("Expected %d attempts. Got %d", test.failures+1, attempts)
+			}
+		})
+	}
+}
+
+func TestFindLeftovers(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	meta := func(namespace string, name string, labels map[string]string, createdBy string, age time.Duration) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			Labels:            labels,
+			Annotations:       map[string]string{CreatedByAnnotation: createdBy},
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+		}
+	}
+	tracerLabels := map[string]string{"app": "kstrace"}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: meta("", "kstrace-old", map[string]string{NamespaceLabel: ""}, "alice", 3*time.Hour)},
+		&corev1.Namespace{ObjectMeta: meta("", "kstrace-new", map[string]string{NamespaceLabel: ""}, "bob", time.Minute)},
+		&corev1.Namespace{ObjectMeta: meta("", "default", nil, "", 24*time.Hour)},
+		&corev1.Pod{ObjectMeta: meta("kstrace-old", "kstrace-inside", tracerLabels, "alice", 3*time.Hour)},
+		&corev1.Pod{ObjectMeta: meta("debugging", "kstrace-shared", tracerLabels, "alice", 2*time.Hour)},
+		&corev1.Pod{ObjectMeta: meta("debugging", "web", map[string]string{"app": "web"}, "", 2*time.Hour)},
+		&corev1.ServiceAccount{ObjectMeta: meta("kstrace-old", "kstrace-tracer-a", tracerLabels, "alice", 3*time.Hour)},
+		&corev1.ServiceAccount{ObjectMeta: meta("debugging", "kstrace-tracer-b", tracerLabels, "bob", 2*time.Hour)},
+		&corev1.ServiceAccount{ObjectMeta: meta("debugging", "default", nil, "", 24*time.Hour)},
+		&rbacv1.RoleBinding{ObjectMeta: meta("debugging", "kstrace-tracer-b", tracerLabels, "bob", 2*time.Hour)},
+	)
+
+	tests := []struct {
+		name     string
+		filter   LeftoverFilter
+		expected []string
+	}{
+		{
+			name:     "everything",
+			filter:   LeftoverFilter{Now: now},
+			expected: []string{"Namespace/kstrace-new", "Namespace/kstrace-old", "Pod/kstrace-shared", "RoleBinding/kstrace-tracer-b", "ServiceAccount/kstrace-tracer-b"},
+		},
+		{
+			name:     "older than",
+			filter:   LeftoverFilter{Now: now, OlderThan: time.Hour},
+			expected: []string{"Namespace/kstrace-old", "Pod/kstrace-shared", "RoleBinding/kstrace-tracer-b", "ServiceAccount/kstrace-tracer-b"},
+		},
+		{
+			name:     "created by",
+			filter:   LeftoverFilter{Now: now, CreatedBy: "bob"},
+			expected: []string{"Namespace/kstrace-new", "RoleBinding/kstrace-tracer-b", "ServiceAccount/kstrace-tracer-b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			leftovers, err := FindLeftovers(ctx, clientset, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := []string{}
+			for _, leftover := range leftovers {
+				found = append(found, leftover.Kind+"/"+leftover.Name)
+			}
+			if !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("Expected %v. Got %v", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestTracerPodExpiry(t *testing.T) {
+	tests := []struct {
+		name             string
+		traceTimeout     time.Duration
+		startTimeout     time.Duration
+		expectedDeadline *int64
+	}{
+		{name: "unbounded trace", traceTimeout: 0, expectedDeadline: nil},
+		{name: "trace timeout", traceTimeout: time.Minute, expectedDeadline: func() *int64 { seconds := int64(390); return &seconds }()},
+		{name: "slow start", traceTimeout: time.Minute, startTimeout: 10 * time.Minute, expectedDeadline: func() *int64 { seconds := int64(960); return &seconds }()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := getPodDefinition(PrivilegedPodOptions{Namespace: "kstrace", TraceTimeout: test.traceTimeout, StartTimeout: test.startTimeout})
+			if !reflect.DeepEqual(pod.Spec.ActiveDeadlineSeconds, test.expectedDeadline) {
+				t.Errorf("Unexpected active deadline %v", pod.Spec.ActiveDeadlineSeconds)
+			}
+			if pod.Annotations[HeartbeatAnnotation] == "" {
+				t.Errorf("Expected the pod to be created with a heartbeat")
+			}
+			if !strings.Contains(pod.Spec.Containers[0].Command[2], heartbeatMountPath+"/"+heartbeatFile) {
+				t.Errorf("Expected the container to watch the heartbeat. Got %q", pod.Spec.Containers[0].Command[2])
+			}
+		})
+	}
+
+	// The heartbeat is renewed until the pod is released
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "kstrace-abc", Namespace: "kstrace"}}
+	clientset := fake.NewSimpleClientset(pod)
+	var patches int32
+	clientset.PrependReactor("patch", "pods", func(action testingcore.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&patches, 1)
+		return false, nil, nil
+	})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		keepAlive(clientset, pod, time.Millisecond, stop)
+		close(done)
+	}()
+	for atomic.LoadInt32(&patches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	renewed, err := clientset.CoreV1().Pods("kstrace").Get(context.TODO(), "kstrace-abc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Annotations[HeartbeatAnnotation] == "" {
+		t.Errorf("Expected the heartbeat annotation to be renewed")
+	}
+}
+
+func TestWaitForPodRunning(t *testing.T) {
+	waiting := func(reason string) corev1.PodStatus {
+		return corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "container-name",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "back-off pulling image"}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		status   corev1.PodStatus
+		update   *corev1.PodStatus
+		timeout  time.Duration
+		expected []string
+	}{
+		{
+			name:   "already running",
+			status: corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		{
+			name:   "starts running",
+			status: corev1.PodStatus{Phase: corev1.PodPending},
+			update: &corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		{
+			name:     "image pull back off",
+			status:   corev1.PodStatus{Phase: corev1.PodPending},
+			update:   func() *corev1.PodStatus { status := waiting("ImagePullBackOff"); return &status }(),
+			expected: []string{"ImagePullBackOff", "back-off pulling image", "Warning\tFailed\tFailed to pull image"},
+		},
+		{
+			name: "unschedulable",
+			status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{{
+				Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable, Message: "0/3 nodes are available",
+			}}},
+			expected: []string{"unschedulable", "0/3 nodes are available"},
+		},
+		{
+			name:     "rejected by the node",
+			status:   corev1.PodStatus{Phase: corev1.PodFailed, Reason: "OutOfcpu", Message: "Node didn't have enough resource"},
+			expected: []string{"stopped before it was ready", "OutOfcpu"},
+		},
+		{
+			name:     "timeout",
+			status:   waiting("ContainerCreating"),
+			timeout:  50 * time.Millisecond,
+			expected: []string{"did not start within 50ms", "ContainerCreating"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.TODO()
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "kstrace-abc", Namespace: "kstrace"}, Status: test.status}
+			event := &corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "kstrace-abc.1", Namespace: "kstrace"},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "kstrace-abc", Namespace: "kstrace"},
+				Type:           corev1.EventTypeWarning,
+				Reason:         "Failed",
+				Message:        "Failed to pull image \"imagename\"",
+			}
+			clientset := fake.NewSimpleClientset(pod, event)
+
+			if test.update != nil {
+				go func() {
+					// Update the pod once it is being watched
+					for !watched(clientset) {
+						time.Sleep(time.Millisecond)
+					}
+					updated := pod.DeepCopy()
+					updated.Status = *test.update
+					clientset.CoreV1().Pods("kstrace").UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+				}()
+			}
+
+			timeout := test.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+			err := waitForPodRunning(ctx, clientset, "kstrace", "kstrace-abc", timeout)
+			if len(test.expected) == 0 {
+				if err != nil {
+					t.Errorf("Expected the pod to be running. %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected the wait to fail")
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected the error to contain %q. Got %q", expected, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestTracerPodStartFailure(t *testing.T) {
+	ctx := context.TODO()
+	clientset := fake.NewSimpleClientset()
+	var created int32
+	clientset.PrependReactor("create", "pods", func(action testingcore.Action) (bool, runtime.Object, error) {
+		pod := action.(testingcore.CreateAction).GetObject().(*corev1.Pod)
+		pod.Name = fmt.Sprintf("%s%d", pod.GenerateName, atomic.AddInt32(&created, 1))
+		pod.Status = corev1.PodStatus{Phase: corev1.PodPending, ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "container-name",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+		}}}
+		return false, nil, nil
+	})
+	pool := NewTracerPool(clientset, PrivilegedPodOptions{Namespace: "kstrace", ContainerName: "container-name", Image: "imagename"})
+
+	// Each attempt removes its Pod, so retrying does not leave privileged Pods behind
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := pool.Acquire(ctx, "node-a"); err == nil {
+			t.Fatalf("Expected the tracer pod to fail to start")
+		}
+		pods, err := clientset.CoreV1().Pods("kstrace").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pods.Items) != 0 {
+			t.Errorf("Expected the failed tracer pod to be deleted. Got %d pods", len(pods.Items))
+		}
+	}
+}
+
+func watched(clientset *fake.Clientset) bool {
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "watch" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		spec      string
+		expected  corev1.Toleration
+		expectErr bool
+	}{
+		{spec: "dedicated=tracing:NoSchedule", expected: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "tracing", Effect: corev1.TaintEffectNoSchedule}},
+		{spec: "node.kubernetes.io/unreachable:NoExecute", expected: corev1.Toleration{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute}},
+		{spec: "gpu", expected: corev1.Toleration{Key: "gpu", Operator: corev1.TolerationOpExists}},
+		{spec: ":NoExecute", expected: corev1.Toleration{Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute}},
+		{spec: "dedicated=tracing:Sometimes", expectErr: true},
+		{spec: "=tracing", expectErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			toleration, err := ParseToleration(test.spec)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !test.expectErr && !reflect.DeepEqual(toleration, test.expected) {
+				t.Errorf("Expected %+v. Got %+v", test.expected, toleration)
+			}
+		})
+	}
+
+	resources, err := ParseResourceList("cpu=100m, memory=64Mi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources.Cpu().MilliValue() != 100 || resources.Memory().Value() != 64*1024*1024 {
+		t.Errorf("Unexpected resources %v", resources)
+	}
+	for _, invalid := range []string{"cpu", "cpu=lots"} {
+		if _, err := ParseResourceList(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTracerPodScheduling(t *testing.T) {
+	pod := getPodDefinition(PrivilegedPodOptions{Namespace: "kstrace", RunID: "run-a"})
+	if !reflect.DeepEqual(pod.Spec.Tolerations, []corev1.Toleration{{Operator: corev1.TolerationOpExists}}) {
+		t.Errorf("Expected every taint to be tolerated by default. Got %v", pod.Spec.Tolerations)
+	}
 
+	toleration := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "tracing"}
+	pod = getPodDefinition(PrivilegedPodOptions{
+		Namespace:          "kstrace",
+		RunID:              "run-a",
+		Tolerations:        []corev1.Toleration{toleration},
+		Resources:          corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("1")}},
+		PriorityClassName:  "system-node-critical",
+		Labels:             map[string]string{"team": "sre", "app": "other", RunLabel: "other"},
+		Annotations:        map[string]string{"owner": "sre"},
+		ServiceAccountName: "tracer",
+	})
+	if !reflect.DeepEqual(pod.Spec.Tolerations, []corev1.Toleration{toleration}) {
+		t.Errorf("Expected only the given tolerations. Got %v", pod.Spec.Tolerations)
+	}
+	if pod.Spec.Containers[0].Resources.Limits.Cpu().Value() != 1 {
+		t.Errorf("Expected the resource limits to be set. Got %v", pod.Spec.Containers[0].Resources)
+	}
+	if pod.Spec.PriorityClassName != "system-node-critical" || pod.Spec.ServiceAccountName != "tracer" {
+		t.Errorf("Expected the priority class and service account to be set. Got %q and %q", pod.Spec.PriorityClassName, pod.Spec.ServiceAccountName)
+	}
+	expectedLabels := map[string]string{"team": "sre", "app": "kstrace", RunLabel: "run-a"}
+	if !reflect.DeepEqual(pod.Labels, expectedLabels) {
+		t.Errorf("Expected the kstrace labels to be kept. Got %v", pod.Labels)
+	}
+	if pod.Annotations["owner"] != "sre" || pod.Annotations[HeartbeatAnnotation] == "" {
+		t.Errorf("Expected the annotations to be added. Got %v", pod.Annotations)
+	}
+}
+
+func TestMirrorImage(t *testing.T) {
+	tests := []struct {
+		image    string
+		mirror   string
+		expected string
+	}{
+		{image: "quay.io/mwasher/crictl:0.0.1", mirror: "registry.example.com", expected: "registry.example.com/mwasher/crictl:0.0.1"},
+		{image: "quay.io/mwasher/crictl:0.0.1", mirror: "registry.example.com/quay/", expected: "registry.example.com/quay/mwasher/crictl:0.0.1"},
+		{image: "localhost:5000/crictl:0.0.1", mirror: "mirror:5000", expected: "mirror:5000/crictl:0.0.1"},
+		{image: "mwasher/crictl:0.0.1", mirror: "registry.example.com", expected: "registry.example.com/mwasher/crictl:0.0.1"},
+		{image: "quay.io/mwasher/crictl:0.0.1", mirror: "", expected: "quay.io/mwasher/crictl:0.0.1"},
+	}
+	for _, test := range tests {
+		if mirrored := MirrorImage(test.image, test.mirror); mirrored != test.expected {
+			t.Errorf("Expected %q mirrored to %q to be %q. Got %q", test.image, test.mirror, test.expected, mirrored)
+		}
+	}
+
+	if _, err := ParsePullPolicy("Sometimes"); err == nil {
+		t.Errorf("Expected an invalid pull policy to be rejected")
+	}
+	policy, err := ParsePullPolicy("IfNotPresent")
+	if err != nil || policy != corev1.PullIfNotPresent {
+		t.Errorf("Expected IfNotPresent. Got %q. %v", policy, err)
+	}
+}
+
+func TestImagePullSecrets(t *testing.T) {
+	ctx := context.TODO()
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+
+	if err := CopySecrets(ctx, clientset, "default", "kstrace-abc", []string{"registry"}); err != nil {
+		t.Fatalf("Unable to copy the secret. %v", err)
+	}
+	copied, err := clientset.CoreV1().Secrets("kstrace-abc").Get(ctx, "registry", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the secret in the tracer namespace. %v", err)
+	}
+	if copied.Type != corev1.SecretTypeDockerConfigJson || string(copied.Data[corev1.DockerConfigJsonKey]) != `{"auths":{}}` {
+		t.Errorf("Expected an identical copy of the secret. Got %v", copied)
+	}
+	if err := CopySecrets(ctx, clientset, "default", "kstrace-abc", []string{"missing"}); err == nil {
+		t.Errorf("Expected a missing secret to fail")
+	}
+
+	pod := getPodDefinition(PrivilegedPodOptions{ImagePullPolicy: corev1.PullNever, ImagePullSecrets: []string{"registry"}})
+	if pod.Spec.Containers[0].ImagePullPolicy != corev1.PullNever {
+		t.Errorf("Expected the pull policy to be set. Got %q", pod.Spec.Containers[0].ImagePullPolicy)
+	}
+	if !reflect.DeepEqual(pod.Spec.ImagePullSecrets, []corev1.LocalObjectReference{{Name: "registry"}}) {
+		t.Errorf("Expected the pull secret to be set. Got %v", pod.Spec.ImagePullSecrets)
+	}
+}
+
+func TestPodSecurity(t *testing.T) {
+	ctx := context.TODO()
+
+	// The namespace allows privileged pods unless a policy forbids the labels
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "namespaces", func(action testingcore.Action) (bool, runtime.Object, error) {
+		namespace := action.(testingcore.CreateAction).GetObject().(*corev1.Namespace)
+		if _, ok := namespace.Labels[podSecurityEnforceLabel]; ok {
+			return true, nil, apierrors.NewForbidden(corev1.Resource("namespaces"), "", fmt.Errorf("pod security labels are managed by the platform team"))
+		}
+		namespace.Name = "kstrace-abc"
+		return false, nil, nil
+	})
+	namespace, err := CreateNamespace(ctx, clientset, "alice")
+	if err != nil {
+		t.Fatalf("Expected the namespace to be created without the labels. %v", err)
+	}
+	if _, ok := namespace.Labels[podSecurityEnforceLabel]; ok {
+		t.Errorf("Expected the forbidden labels to be removed. Got %v", namespace.Labels)
+	}
+
+	namespace, err = CreateNamespace(ctx, fake.NewSimpleClientset(), "alice")
+	if err != nil || namespace.Labels[podSecurityEnforceLabel] != podSecurityPrivileged {
+		t.Errorf("Expected the namespace to allow privileged pods. Got %v. %v", namespace.Labels, err)
+	}
+
+	// The admission policy that rejected the tracer pod is named
+	clientset = fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "debugging", Labels: map[string]string{podSecurityEnforceLabel: "restricted"}}})
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "pod security",
+			err:      apierrors.NewForbidden(corev1.Resource("pods"), "kstrace-abc", fmt.Errorf(`violates PodSecurity "restricted:latest": privileged`)),
+			expected: `namespace "debugging" enforces level "restricted"`,
+		},
+		{
+			name:     "security context constraints",
+			err:      apierrors.NewForbidden(corev1.Resource("pods"), "kstrace-abc", fmt.Errorf("unable to validate against any security context constraint")),
+			expected: privilegedSCCRole,
+		},
+		{
+			name:     "other",
+			err:      fmt.Errorf("connection refused"),
+			expected: "connection refused",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := describeCreateError(ctx, clientset, test.err, "debugging")
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected the error to contain %q. Got %q", test.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestGrantPrivilegedSCC(t *testing.T) {
+	ctx := context.TODO()
+	clientset := fake.NewSimpleClientset()
+	if openShift, err := IsOpenShift(clientset); err != nil || openShift {
+		t.Errorf("Expected a plain cluster not to be OpenShift. %v", err)
+	}
+	clientset.Resources = []*metav1.APIResourceList{{GroupVersion: "security.openshift.io/v1"}}
+	if openShift, err := IsOpenShift(clientset); err != nil || !openShift {
+		t.Errorf("Expected the cluster to be OpenShift. %v", err)
+	}
+
+	serviceAccount, revoke, err := GrantPrivilegedSCC(ctx, clientset, "debugging", "run-a", "alice")
+	if err != nil {
+		t.Fatalf("Unable to grant the privileged SCC. %v", err)
+	}
+	if serviceAccount != TracerServiceAccount+"-run-a" {
+		t.Errorf("Unexpected serviceaccount %q", serviceAccount)
+	}
+	binding, err := clientset.RbacV1().RoleBindings("debugging").Get(ctx, serviceAccount, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected a rolebinding. %v", err)
+	}
+	if binding.RoleRef.Name != privilegedSCCRole || binding.Subjects[0].Name != serviceAccount {
+		t.Errorf("Expected the serviceaccount to be bound to the privileged SCC. Got %v", binding)
+	}
+	leftovers, err := FindLeftovers(ctx, clientset, LeftoverFilter{CreatedBy: "alice"})
+	if err != nil || len(leftovers) != 2 {
+		t.Fatalf("Expected the serviceaccount and rolebinding to be found as leftovers. Got %v %v", leftovers, err)
+	}
+	for _, leftover := range leftovers {
+		if err := DeleteLeftover(clientset, leftover); err != nil {
+			t.Errorf("Unable to delete leftover %s %q. %v", leftover.Kind, leftover.Name, err)
+		}
+	}
+	if _, err := clientset.RbacV1().RoleBindings("debugging").Get(ctx, serviceAccount, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected the leftover rolebinding to be deleted. %v", err)
+	}
+
+	if err := revoke(); err != nil {
+		t.Errorf("Unable to revoke the privileged SCC. %v", err)
+	}
+	if _, err := clientset.CoreV1().ServiceAccounts("debugging").Get(ctx, serviceAccount, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected the serviceaccount to be deleted. %v", err)
+	}
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  AccessOptions
+		expected []string
+	}{
+		{
+			name:    "generated namespace",
+			options: AccessOptions{TargetNamespaces: []string{"web", "api", "web"}, ImagePullSecrets: []string{"registry"}, SecretNamespace: "web"},
+			expected: []string{
+				"create namespaces cluster", "delete namespaces cluster",
+				"get secrets web", "create secrets all namespaces",
+				"create pods all namespaces", "delete pods all namespaces", "get pods all namespaces",
+				"list pods all namespaces", "watch pods all namespaces", "patch pods all namespaces",
+				"create pods/exec all namespaces", "list events all namespaces",
+				"list pods api", "list pods web",
+			},
+		},
+		{
+			name:    "existing namespace",
+			options: AccessOptions{TracerNamespace: "debugging", TargetNamespaces: []string{"web"}, ImagePullSecrets: []string{"registry"}},
+			expected: []string{
+				"create pods debugging", "delete pods debugging", "get pods debugging",
+				"list pods debugging", "watch pods debugging", "patch pods debugging",
+				"create pods/exec debugging", "list events debugging",
+				"list pods web",
+			},
+		},
+		{
+			name:    "redaction on openshift",
+			options: AccessOptions{TracerNamespace: "debugging", TargetNamespaces: []string{"web"}, Redact: true, GrantPrivilegedSCC: true},
+			expected: []string{
+				"create pods debugging", "delete pods debugging", "get pods debugging",
+				"list pods debugging", "watch pods debugging", "patch pods debugging",
+				"create pods/exec debugging", "list events debugging",
+				"create serviceaccounts debugging", "create rolebindings.rbac.authorization.k8s.io debugging",
+				"delete serviceaccounts debugging", "delete rolebindings.rbac.authorization.k8s.io debugging",
+				"bind clusterroles.rbac.authorization.k8s.io/system:openshift:scc:privileged cluster",
+				"list pods web", "get secrets web",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			permissions := []string{}
+			for _, permission := range RequiredPermissions(test.options) {
+				permissions = append(permissions, fmt.Sprintf("%s %s %s", permission.Verb, permission.ResourceName(), permission.Scope()))
+			}
+			if !reflect.DeepEqual(permissions, test.expected) {
+				t.Errorf("Expected permissions %v. Got %v", test.expected, permissions)
+			}
+		})
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	// Everything is allowed except exec
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action testingcore.Action) (bool, runtime.Object, error) {
+		review := action.(testingcore.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Subresource != "exec"
+		if !review.Status.Allowed {
+			review.Status.Reason = "exec is reserved for administrators"
+		}
+		return true, review, nil
+	})
+
+	checks, err := CheckAccess(context.TODO(), clientset, RequiredPermissions(AccessOptions{TracerNamespace: "debugging", TargetNamespaces: []string{"web"}}))
+	if err != nil {
+		t.Fatalf("Unable to check access. %v", err)
+	}
+	missing := MissingPermissions(checks)
+	if len(missing) != 1 || missing[0].ResourceName() != "pods/exec" || missing[0].Namespace != "debugging" || missing[0].Reason == "" {
+		t.Errorf("Expected only pods/exec to be missing. Got %v", missing)
+	}
+
+	// Reviews that cannot be made are reported rather than treated as denied
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action testingcore.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(authorizationv1.Resource("selfsubjectaccessreviews"), "")
+	})
+	if _, err := CheckAccess(context.TODO(), clientset, RequiredPermissions(AccessOptions{})); err == nil {
+		t.Errorf("Expected an error when access reviews are unavailable")
+	}
+}
+
+func TestPodOwner(t *testing.T) {
+	controller := true
+	ownedBy := func(kind string, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-5d4f8", Namespace: "default", OwnerReferences: ownedBy("Deployment", "web")}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: "default"}},
+	)
+
+	tests := []struct {
+		name     string
+		owners   []metav1.OwnerReference
+		expected string
+	}{
+		{name: "no owner", expected: ""},
+		{name: "deployment", owners: ownedBy("ReplicaSet", "web-5d4f8"), expected: "Deployment/web"},
+		{name: "standalone replicaset", owners: ownedBy("ReplicaSet", "standalone"), expected: "ReplicaSet/standalone"},
+		{name: "missing replicaset", owners: ownedBy("ReplicaSet", "web-deleted"), expected: "ReplicaSet/web-deleted"},
+		{name: "statefulset", owners: ownedBy("StatefulSet", "db"), expected: "StatefulSet/db"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", OwnerReferences: test.owners}}
+			if owner := podOwner(context.TODO(), clientset, pod); owner != test.expected {
+				t.Errorf("Owner mismatch. Expected %q, Got: %q", test.expected, owner)
+			}
+		})
+	}
 }
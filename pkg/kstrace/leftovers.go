@@ -0,0 +1,146 @@
+package kstrace
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	LeftoverNamespace      = "Namespace"
+	LeftoverPod            = "Pod"
+	LeftoverRoleBinding    = "RoleBinding"
+	LeftoverServiceAccount = "ServiceAccount"
+)
+
+// Leftover is a namespace, tracer Pod or tracer ServiceAccount and RoleBinding created by kstrace that may have
+// outlived its run
+type Leftover struct {
+	Kind      string
+	Namespace string
+	Name      string
+	CreatedBy string
+	Created   time.Time
+}
+
+// LeftoverFilter selects which leftovers are returned. Zero values match everything.
+type LeftoverFilter struct {
+	// OlderThan skips resources created more recently, such as those of a trace that is still running
+	OlderThan time.Duration
+	CreatedBy string
+	// Now is the time ages are measured from. Defaults to the current time
+	Now time.Time
+}
+
+func (filter LeftoverFilter) matches(object metav1.ObjectMeta) bool {
+	now := filter.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if filter.OlderThan > 0 && now.Sub(object.CreationTimestamp.Time) < filter.OlderThan {
+		return false
+	}
+	return filter.CreatedBy == "" || object.Annotations[CreatedByAnnotation] == filter.CreatedBy
+}
+
+// FindLeftovers lists the namespaces, tracer Pods and the ServiceAccounts and RoleBindings granting them the
+// privileged SCC created by kstrace across the cluster. Resources within a kstrace namespace are not listed
+// separately as they are removed with the namespace.
+func FindLeftovers(ctx context.Context, client kubernetes.Interface, filter LeftoverFilter) ([]Leftover, error) {
+	leftovers := []Leftover{}
+	newLeftover := func(kind string, object metav1.ObjectMeta) Leftover {
+		return Leftover{
+			Kind:      kind,
+			Namespace: object.Namespace,
+			Name:      object.Name,
+			CreatedBy: object.Annotations[CreatedByAnnotation],
+			Created:   object.CreationTimestamp.Time,
+		}
+	}
+
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: NamespaceLabel})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list kstrace namespaces. %v", err)
+	}
+	generated := map[string]bool{}
+	for _, namespace := range namespaces.Items {
+		generated[namespace.Name] = true
+		if filter.matches(namespace.ObjectMeta) {
+			leftovers = append(leftovers, newLeftover(LeftoverNamespace, namespace.ObjectMeta))
+		}
+	}
+
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: "app=kstrace"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list kstrace pods. %v", err)
+	}
+	for _, pod := range pods.Items {
+		if !generated[pod.Namespace] && filter.matches(pod.ObjectMeta) {
+			leftovers = append(leftovers, newLeftover(LeftoverPod, pod.ObjectMeta))
+		}
+	}
+
+	// RoleBindings are listed first so they are deleted before the ServiceAccount they bind
+	bindings, err := client.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: "app=kstrace"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list kstrace rolebindings. %v", err)
+	}
+	for _, binding := range bindings.Items {
+		if !generated[binding.Namespace] && filter.matches(binding.ObjectMeta) {
+			leftovers = append(leftovers, newLeftover(LeftoverRoleBinding, binding.ObjectMeta))
+		}
+	}
+
+	accounts, err := client.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: "app=kstrace"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list kstrace serviceaccounts. %v", err)
+	}
+	for _, account := range accounts.Items {
+		if !generated[account.Namespace] && filter.matches(account.ObjectMeta) {
+			leftovers = append(leftovers, newLeftover(LeftoverServiceAccount, account.ObjectMeta))
+		}
+	}
+
+	sort.SliceStable(leftovers, func(i, j int) bool {
+		if leftovers[i].Namespace != leftovers[j].Namespace {
+			return leftovers[i].Namespace < leftovers[j].Namespace
+		}
+		return leftovers[i].Name < leftovers[j].Name
+	})
+	return leftovers, nil
+}
+
+// DeleteLeftover removes a resource found by FindLeftovers
+func DeleteLeftover(client kubernetes.Interface, leftover Leftover) error {
+	switch leftover.Kind {
+	case LeftoverNamespace:
+		return CleanupNamespace(client, leftover.Name)
+	case LeftoverRoleBinding:
+		err := retryCleanup(fmt.Sprintf("rolebinding %q", leftover.Name), func(ctx context.Context) error {
+			return client.RbacV1().RoleBindings(leftover.Namespace).Delete(ctx, leftover.Name, metav1.DeleteOptions{})
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete rolebinding %q from namespace %q. %v", leftover.Name, leftover.Namespace, err)
+		}
+	case LeftoverServiceAccount:
+		err := retryCleanup(fmt.Sprintf("serviceaccount %q", leftover.Name), func(ctx context.Context) error {
+			return client.CoreV1().ServiceAccounts(leftover.Namespace).Delete(ctx, leftover.Name, metav1.DeleteOptions{})
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete serviceaccount %q from namespace %q. %v", leftover.Name, leftover.Namespace, err)
+		}
+	default:
+		if err := deletePod(client, leftover.Namespace, leftover.Name); err != nil {
+			return fmt.Errorf("unable to delete strace pod %q from namespace %q. %v", leftover.Name, leftover.Namespace, err)
+		}
+	}
+	log.Infof("%s %q Deleted from namespace %q", leftover.Kind, leftover.Name, leftover.Namespace)
+	return nil
+}
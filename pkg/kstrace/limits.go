@@ -0,0 +1,130 @@
+package kstrace
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RotatingSink splits each stream into numbered segments of at most MaxSize bytes. Streams are
+// expected to be written a line at a time so segments always end on a complete line.
+type RotatingSink struct {
+	Sink    Sink
+	MaxSize int64
+}
+
+func (sink *RotatingSink) Create(name string) (io.WriteCloser, string, error) {
+	writer := &rotatingWriter{sink: sink.Sink, name: name, maxSize: sink.MaxSize}
+	if err := writer.rotate(); err != nil {
+		return nil, "", err
+	}
+	return writer, writer.segments[0], nil
+}
+
+// SegmentedWriter is implemented by writers that store a stream across several files
+type SegmentedWriter interface {
+	Segments() []string
+}
+
+type rotatingWriter struct {
+	sink     Sink
+	name     string
+	maxSize  int64
+	current  io.WriteCloser
+	size     int64
+	segments []string
+}
+
+func (writer *rotatingWriter) rotate() error {
+	if writer.current != nil {
+		if err := writer.current.Close(); err != nil {
+			return err
+		}
+	}
+
+	// The first segment keeps the original name so small collections are unchanged
+	name := writer.name
+	if len(writer.segments) > 0 {
+		name = fmt.Sprintf("%s.%d", writer.name, len(writer.segments))
+	}
+
+	current, storedName, err := writer.sink.Create(name)
+	if err != nil {
+		return err
+	}
+	writer.current = current
+	writer.size = 0
+	writer.segments = append(writer.segments, storedName)
+	return nil
+}
+
+func (writer *rotatingWriter) Write(data []byte) (int, error) {
+	if writer.size > 0 && writer.size+int64(len(data)) > writer.maxSize {
+		log.Debugf("Rotating strace output %q after %d bytes", writer.name, writer.size)
+		if err := writer.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	written, err := writer.current.Write(data)
+	writer.size += int64(written)
+	return written, err
+}
+
+func (writer *rotatingWriter) Close() error {
+	return writer.current.Close()
+}
+
+func (writer *rotatingWriter) Segments() []string {
+	return writer.segments
+}
+
+// Budget limits the total amount of strace output stored across every tracer in a collection
+type Budget struct {
+	mutex     sync.Mutex
+	limit     int64
+	used      int64
+	exhausted chan struct{}
+}
+
+func NewBudget(limit int64) *Budget {
+	return &Budget{limit: limit, exhausted: make(chan struct{})}
+}
+
+// Take reserves space for the given number of bytes, reporting false once the budget is exhausted.
+// A nil Budget is unlimited.
+func (budget *Budget) Take(size int) bool {
+	if budget == nil {
+		return true
+	}
+
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	if budget.used+int64(size) > budget.limit {
+		select {
+		case <-budget.exhausted:
+		default:
+			log.Warnf("The output budget of %d bytes is exhausted. Stopping all traces.", budget.limit)
+			close(budget.exhausted)
+		}
+		return false
+	}
+	budget.used += int64(size)
+	return true
+}
+
+// Exhausted is closed once the budget has been used up
+func (budget *Budget) Exhausted() <-chan struct{} {
+	if budget == nil {
+		return nil
+	}
+	return budget.exhausted
+}
+
+// Reason describes why the budget stopped the collection
+func (budget *Budget) Reason() string {
+	return fmt.Sprintf("the total output size limit of %d bytes was reached", budget.limit)
+}
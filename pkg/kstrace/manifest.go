@@ -0,0 +1,194 @@
+package kstrace
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ManifestFile = "manifest.json"
+
+	// A manifest describes either a whole collection or one of the Pods within it
+	ManifestKindCollection = "Collection"
+	ManifestKindPod        = "Pod"
+)
+
+// ContainerResult records how a single container was traced
+type ContainerResult struct {
+	Name        string    `json:"name"`
+	Image       string    `json:"image"`
+	ContainerID string    `json:"containerID"`
+	PID         int64     `json:"pid"`
+	Command     string    `json:"command,omitempty"`
+	LogFiles    []string  `json:"logFiles,omitempty"`
+	Mounts      []Mount   `json:"mounts,omitempty"`
+	StartTime   time.Time `json:"startTime"`
+	StopTime    time.Time `json:"stopTime"`
+	ExitCode    int       `json:"exitCode"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Mount records where a volume from the Pod spec is mounted in a container
+type Mount struct {
+	Volume    string `json:"volume"`
+	MountPath string `json:"mountPath"`
+	SubPath   string `json:"subPath,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+	// Source describes where the volume comes from, e.g. configMap/app-config
+	Source string `json:"source"`
+}
+
+// TraceResult records how a target Pod was traced
+type TraceResult struct {
+	Namespace     string            `json:"namespace"`
+	Pod           string            `json:"pod"`
+	Owner         string            `json:"owner,omitempty"`
+	Node          string            `json:"node"`
+	TracerPod     string            `json:"tracerPod,omitempty"`
+	TracerImage   string            `json:"tracerImage"`
+	StraceVersion string            `json:"straceVersion,omitempty"`
+	StartTime     time.Time         `json:"startTime"`
+	StopTime      time.Time         `json:"stopTime"`
+	Containers    []ContainerResult `json:"containers"`
+	StopReason    string            `json:"stopReason,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// Manifest describes how an entire collection was produced
+type Manifest struct {
+	Kind           string        `json:"kind"`
+	Version        string        `json:"version"`
+	Commit         string        `json:"commit,omitempty"`
+	Command        []string      `json:"command"`
+	TraceNamespace string        `json:"traceNamespace"`
+	StartTime      time.Time     `json:"startTime"`
+	StopTime       time.Time     `json:"stopTime"`
+	Pods           []TraceResult `json:"pods"`
+	Redaction      []string      `json:"redaction,omitempty"`
+	Encryption     Encryption    `json:"encryption,omitempty"`
+}
+
+// PodManifest is stored alongside the logs of each traced Pod
+type PodManifest struct {
+	Kind string `json:"kind"`
+	TraceResult
+}
+
+// podOwner returns the kind and name of the controller that manages the Pod. Each rollout of a Deployment creates
+// a new ReplicaSet, so the Deployment is returned for its Pods to keep the replicas of a rollout together.
+func podOwner(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+	if owner.Kind == "ReplicaSet" {
+		replicaSet, err := client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Debugf("Unable to find the owner of replicaset %q. %v", owner.Name, err)
+		} else if deployment := metav1.GetControllerOf(replicaSet); deployment != nil {
+			return deployment.Kind + "/" + deployment.Name
+		}
+	}
+	return owner.Kind + "/" + owner.Name
+}
+
+// WriteManifest stores the manifest as JSON in the given directory
+func WriteManifest(directory string, manifest interface{}) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(directory, ManifestFile), append(data, '\n'), 0664)
+}
+
+// WritePodManifest stores the result of a traced Pod as JSON in the given directory
+func WritePodManifest(directory string, result TraceResult) error {
+	return WriteManifest(directory, PodManifest{Kind: ManifestKindPod, TraceResult: result})
+}
+
+// ReadManifest loads the collection manifest from the given directory
+func ReadManifest(directory string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(directory, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ReadPodManifest loads the manifest of a single traced Pod from the given directory
+func ReadPodManifest(directory string) (*TraceResult, error) {
+	data, err := os.ReadFile(filepath.Join(directory, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TraceResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// containerMounts describes the volume mounts of the named container in the Pod spec
+func containerMounts(pod *corev1.Pod, containerName string) []Mount {
+	sources := map[string]string{}
+	for _, volume := range pod.Spec.Volumes {
+		sources[volume.Name] = describeVolumeSource(volume.VolumeSource)
+	}
+
+	mounts := []Mount{}
+	for _, container := range pod.Spec.Containers {
+		if container.Name != containerName {
+			continue
+		}
+		for _, volumeMount := range container.VolumeMounts {
+			mounts = append(mounts, Mount{
+				Volume:    volumeMount.Name,
+				MountPath: volumeMount.MountPath,
+				SubPath:   volumeMount.SubPath,
+				ReadOnly:  volumeMount.ReadOnly,
+				Source:    sources[volumeMount.Name],
+			})
+		}
+	}
+	return mounts
+}
+
+func describeVolumeSource(source corev1.VolumeSource) string {
+	switch {
+	case source.ConfigMap != nil:
+		return "configMap/" + source.ConfigMap.Name
+	case source.Secret != nil:
+		return "secret/" + source.Secret.SecretName
+	case source.PersistentVolumeClaim != nil:
+		return "persistentVolumeClaim/" + source.PersistentVolumeClaim.ClaimName
+	case source.HostPath != nil:
+		return "hostPath:" + source.HostPath.Path
+	case source.EmptyDir != nil:
+		return "emptyDir"
+	case source.Projected != nil:
+		return "projected"
+	case source.DownwardAPI != nil:
+		return "downwardAPI"
+	case source.CSI != nil:
+		return "csi/" + source.CSI.Driver
+	case source.Ephemeral != nil:
+		return "ephemeral"
+	}
+	return "other"
+}
@@ -0,0 +1,62 @@
+package kstrace
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var prefixColours = []int{32, 33, 34, 35, 36, 31, 92, 93, 94, 95, 96, 91}
+
+// Multiplexer combines the strace output of many containers into a single writer. Each line is
+// written whole, optionally prefixed with a `pod/container` tag, so concurrent streams never interleave.
+type Multiplexer struct {
+	mutex   sync.Mutex
+	out     io.Writer
+	prefix  bool
+	colour  bool
+	colours map[string]int
+}
+
+func NewMultiplexer(out io.Writer, prefix bool, colour bool) *Multiplexer {
+	return &Multiplexer{
+		out:     out,
+		prefix:  prefix,
+		colour:  colour,
+		colours: map[string]int{},
+	}
+}
+
+func (mux *Multiplexer) tag(target TraceTarget) string {
+	if !mux.prefix {
+		return ""
+	}
+
+	tag := fmt.Sprintf("%s/%s", target.Pod, target.Container)
+	if !mux.colour {
+		return tag + " "
+	}
+
+	colour, ok := mux.colours[tag]
+	if !ok {
+		colour = prefixColours[len(mux.colours)%len(prefixColours)]
+		mux.colours[tag] = colour
+	}
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m ", colour, tag)
+}
+
+func (mux *Multiplexer) writeLine(target TraceTarget, line string) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+
+	// A single write per line keeps the output intact. There is no sensible recovery if stdout is closed
+	_, _ = io.WriteString(mux.out, mux.tag(target)+line+"\n")
+}
+
+// Writer returns a writer for the target's output. The returned function flushes any partial line.
+func (mux *Multiplexer) Writer(target TraceTarget) (io.Writer, func()) {
+	writer := newLineWriter(func(line string) {
+		mux.writeLine(target, line)
+	})
+	return writer, writer.Flush
+}
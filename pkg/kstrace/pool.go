@@ -0,0 +1,106 @@
+package kstrace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// TracerPool shares a single privileged tracer Pod between every target on the same node. The Pod is
+// created by the first tracer to acquire it and deleted once the last tracer releases it.
+type TracerPool struct {
+	client   kubernetes.Interface
+	template PrivilegedPodOptions
+
+	mutex sync.Mutex
+	nodes map[string]*nodeTracer
+}
+
+// nodeTracer is the tracer Pod of a single node
+type nodeTracer struct {
+	// ready is closed once the Pod is running or has failed to start
+	ready      chan struct{}
+	pod        *corev1.Pod
+	err        error
+	references int
+	// stopHeartbeat is closed once the Pod is released
+	stopHeartbeat chan struct{}
+}
+
+// NewTracerPool creates a pool of tracer Pods. The NodeName of the template is set for each node.
+func NewTracerPool(client kubernetes.Interface, template PrivilegedPodOptions) *TracerPool {
+	return &TracerPool{client: client, template: template, nodes: map[string]*nodeTracer{}}
+}
+
+// Acquire returns the running tracer Pod for the node, creating it if this is the first target on the node.
+// Every successful Acquire must be matched by a Release.
+func (pool *TracerPool) Acquire(ctx context.Context, node string) (*corev1.Pod, error) {
+	pool.mutex.Lock()
+	tracer, ok := pool.nodes[node]
+	if !ok {
+		tracer = &nodeTracer{ready: make(chan struct{})}
+		pool.nodes[node] = tracer
+	}
+	tracer.references++
+	pool.mutex.Unlock()
+
+	if !ok {
+		options := pool.template
+		options.NodeName = node
+		tracer.pod, tracer.err = createTracerPod(ctx, pool.client, options)
+		if tracer.err == nil {
+			tracer.stopHeartbeat = make(chan struct{})
+			go keepAlive(pool.client, tracer.pod, heartbeatInterval, tracer.stopHeartbeat)
+		}
+		close(tracer.ready)
+	} else {
+		log.Debugf("Sharing the tracer pod on node %q", node)
+	}
+	<-tracer.ready
+
+	if tracer.err != nil {
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+
+		// Forget the failed Pod so a later target can try again
+		tracer.references--
+		if tracer.references == 0 && pool.nodes[node] == tracer {
+			delete(pool.nodes, node)
+		}
+		return nil, tracer.err
+	}
+	return tracer.pod, nil
+}
+
+// Release gives up a reference to the node's tracer Pod, deleting it once it is no longer used. The deletion is
+// retried a bounded number of times and is not cancelled with the trace.
+func (pool *TracerPool) Release(node string) error {
+	pool.mutex.Lock()
+	tracer, ok := pool.nodes[node]
+	if !ok {
+		pool.mutex.Unlock()
+		return nil
+	}
+	tracer.references--
+	if tracer.references > 0 {
+		pool.mutex.Unlock()
+		return nil
+	}
+	delete(pool.nodes, node)
+	pool.mutex.Unlock()
+	if tracer.stopHeartbeat != nil {
+		close(tracer.stopHeartbeat)
+	}
+
+	log.Infof("Deleting tracer pod %q from node %q", tracer.pod.Name, node)
+	if err := deletePod(pool.client, tracer.pod.Namespace, tracer.pod.Name); err != nil {
+		return fmt.Errorf("unable to delete strace pod %q from namespace %q. manual deletion is required. %v", tracer.pod.Name, tracer.pod.Namespace, err)
+	}
+	return nil
+}
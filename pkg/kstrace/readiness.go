@@ -0,0 +1,148 @@
+package kstrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// DefaultStartTimeout is how long a tracer Pod has to start when no timeout is given
+const DefaultStartTimeout = 30 * time.Second
+
+// Container waiting reasons that will not resolve without intervention
+var fatalWaitingReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"ErrImageNeverPull":          true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"CrashLoopBackOff":           true,
+}
+
+// waitForPodRunning watches the tracer Pod until it is running. Pods that cannot start, such as those that
+// are unschedulable or cannot pull their image, fail early with the reason and the Pod's events.
+func waitForPodRunning(ctx context.Context, clientset kubernetes.Interface, namespace string, pod string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultStartTimeout
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	selector := fields.OneTermEqualSelector("metadata.name", pod).String()
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return clientset.CoreV1().Pods(namespace).List(waitCtx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return clientset.CoreV1().Pods(namespace).Watch(waitCtx, options)
+		},
+	}
+
+	var lastSeen *corev1.Pod
+	_, err := watchtools.UntilWithSync(waitCtx, listWatch, &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		observed, ok := event.Object.(*corev1.Pod)
+		if !ok || observed.Name != pod {
+			return false, nil
+		}
+		if event.Type == watch.Deleted {
+			return false, fmt.Errorf("tracer pod %q was deleted before it started", pod)
+		}
+		lastSeen = observed
+		return podStarted(observed)
+	})
+	if err == nil {
+		return nil
+	}
+
+	// The run itself was cancelled, so there is nothing to diagnose
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, wait.ErrWaitTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("tracer pod %q did not start within %v%s", pod, timeout, waitingReasons(lastSeen))
+	}
+	return fmt.Errorf("%v%s", err, describeEvents(clientset, namespace, pod))
+}
+
+// podStarted reports whether the Pod is running, or an error once it can no longer start
+func podStarted(pod *corev1.Pod) (bool, error) {
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+		return true, nil
+	case corev1.PodFailed, corev1.PodSucceeded:
+		return false, fmt.Errorf("tracer pod %q stopped before it was ready. %s %s", pod.Name, pod.Status.Reason, pod.Status.Message)
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+			return false, fmt.Errorf("tracer pod %q is unschedulable. %s", pod.Name, condition.Message)
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && fatalWaitingReasons[status.State.Waiting.Reason] {
+			return false, fmt.Errorf("tracer pod %q cannot start. container %q is waiting with reason %s: %s",
+				pod.Name, status.Name, status.State.Waiting.Reason, status.State.Waiting.Message)
+		}
+	}
+	return false, nil
+}
+
+// waitingReasons describes why the containers of a Pod have not started
+func waitingReasons(pod *corev1.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	reasons := []string{}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			reasons = append(reasons, fmt.Sprintf("container %q is waiting with reason %s", status.Name, status.State.Waiting.Reason))
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf(". the pod is %s", pod.Status.Phase)
+	}
+	return ". " + strings.Join(reasons, ", ")
+}
+
+// describeEvents lists the events of a Pod so a failure to start can be diagnosed without kubectl
+func describeEvents(clientset kubernetes.Interface, namespace string, pod string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	selector := fields.OneTermEqualSelector("involvedObject.name", pod).String()
+	events, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil || len(events.Items) == 0 {
+		return ""
+	}
+
+	items := []corev1.Event{}
+	for _, event := range events.Items {
+		if event.InvolvedObject.Name == pod {
+			items = append(items, event)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].LastTimestamp.Before(&items[j].LastTimestamp) })
+
+	builder := strings.Builder{}
+	builder.WriteString("\nevents:")
+	for _, event := range items {
+		fmt.Fprintf(&builder, "\n  %s\t%s\t%s", event.Type, event.Reason, strings.TrimSpace(event.Message))
+	}
+	return builder.String()
+}
@@ -0,0 +1,72 @@
+package kstrace
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterResolver maps IP addresses to the Services and Pods that currently own them
+type ClusterResolver struct {
+	addresses map[string]string
+}
+
+// NewClusterResolver indexes the Service and Pod IPs of the cluster. When the cluster cannot be listed as a
+// whole, only the given namespaces are indexed.
+func NewClusterResolver(clientset kubernetes.Interface, namespaces []string) (*ClusterResolver, error) {
+	resolver := &ClusterResolver{addresses: map[string]string{}}
+
+	err := resolver.index(clientset, metav1.NamespaceAll)
+	if err == nil {
+		return resolver, nil
+	}
+	log.Debugf("unable to index the cluster, falling back to namespaces %v. %v", namespaces, err)
+
+	for _, namespace := range namespaces {
+		if err = resolver.index(clientset, namespace); err != nil {
+			return nil, err
+		}
+	}
+	return resolver, nil
+}
+
+func (resolver *ClusterResolver) index(clientset kubernetes.Interface, namespace string) error {
+	services, err := clientset.CoreV1().Services(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services.Items {
+		for _, ip := range service.Spec.ClusterIPs {
+			if ip != corev1.ClusterIPNone {
+				resolver.addresses[ip] = "service/" + service.Namespace + "/" + service.Name
+			}
+		}
+	}
+	for _, pod := range pods.Items {
+		// Host network pods share the node address and cannot be told apart
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		for _, ip := range pod.Status.PodIPs {
+			if _, exists := resolver.addresses[ip.IP]; !exists {
+				resolver.addresses[ip.IP] = "pod/" + pod.Namespace + "/" + pod.Name
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve returns the resource owning the address, or an empty string when it is unknown
+func (resolver *ClusterResolver) Resolve(address string) string {
+	return resolver.addresses[address]
+}
@@ -0,0 +1,84 @@
+package kstrace
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// tolerateEverything lets tracer Pods run on every node, including those with NoExecute taints
+var tolerateEverything = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+
+var taintEffects = []corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute}
+
+// ParseToleration reads a toleration in the form key[=value][:effect]. A key without a value tolerates any value
+// and an empty key tolerates every taint with the effect.
+func ParseToleration(spec string) (corev1.Toleration, error) {
+	toleration := corev1.Toleration{}
+	rest := spec
+	if index := strings.LastIndex(spec, ":"); index >= 0 {
+		rest = spec[:index]
+		toleration.Effect = corev1.TaintEffect(spec[index+1:])
+		valid := false
+		for _, effect := range taintEffects {
+			valid = valid || toleration.Effect == effect
+		}
+		if !valid {
+			return toleration, fmt.Errorf("invalid toleration %q. the effect must be one of %v", spec, taintEffects)
+		}
+	}
+
+	toleration.Key = rest
+	toleration.Operator = corev1.TolerationOpExists
+	if index := strings.Index(rest, "="); index >= 0 {
+		toleration.Key, toleration.Value = rest[:index], rest[index+1:]
+		toleration.Operator = corev1.TolerationOpEqual
+		if toleration.Key == "" {
+			return toleration, fmt.Errorf("invalid toleration %q. a value requires a key", spec)
+		}
+	}
+	return toleration, nil
+}
+
+// ParseResourceList reads resource quantities in the form cpu=100m,memory=64Mi
+func ParseResourceList(spec string) (corev1.ResourceList, error) {
+	resources := corev1.ResourceList{}
+	if spec == "" {
+		return resources, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		name, value, err := splitKeyValue(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resources %q. %v", spec, err)
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for %q. %v", name, err)
+		}
+		resources[corev1.ResourceName(name)] = quantity
+	}
+	return resources, nil
+}
+
+// ParseKeyValues reads labels or annotations in the form key=value
+func ParseKeyValues(specs []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, spec := range specs {
+		key, value, err := splitKeyValue(spec)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func splitKeyValue(spec string) (string, string, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("%q is not in the form key=value", spec)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
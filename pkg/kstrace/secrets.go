@@ -0,0 +1,58 @@
+package kstrace
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SecretEnvValues returns the values of the environment variables in the Pod's containers that are sourced
+// from Secrets. Values that could be read are returned alongside the first error encountered.
+func SecretEnvValues(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod) ([]string, error) {
+	secrets := map[string]*corev1.Secret{}
+	var firstErr error
+	getSecret := func(name string, optional *bool) *corev1.Secret {
+		if secret, ok := secrets[name]; ok {
+			return secret
+		}
+		secret, err := client.CoreV1().Secrets(pod.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			secret = nil
+			if firstErr == nil && !(apierrors.IsNotFound(err) && optional != nil && *optional) {
+				firstErr = err
+			}
+		}
+		secrets[name] = secret
+		return secret
+	}
+
+	values := []string{}
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, source := range container.EnvFrom {
+			if source.SecretRef == nil {
+				continue
+			}
+			if secret := getSecret(source.SecretRef.Name, source.SecretRef.Optional); secret != nil {
+				for _, value := range secret.Data {
+					values = append(values, string(value))
+				}
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+				continue
+			}
+			reference := env.ValueFrom.SecretKeyRef
+			if secret := getSecret(reference.Name, reference.Optional); secret != nil {
+				if value, ok := secret.Data[reference.Key]; ok {
+					values = append(values, string(value))
+				}
+			}
+		}
+	}
+	return values, firstErr
+}
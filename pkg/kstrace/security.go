@@ -0,0 +1,132 @@
+package kstrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// Tracer Pods are privileged, so the namespace they run in must admit privileged Pods. Pod Security admission
+// is configured with namespace labels, while OpenShift admits Pods through SecurityContextConstraints granted to
+// their ServiceAccount.
+
+const (
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	podSecurityAuditLabel   = "pod-security.kubernetes.io/audit"
+	podSecurityWarnLabel    = "pod-security.kubernetes.io/warn"
+	podSecurityPrivileged   = "privileged"
+
+	openShiftSecurityGroup = "security.openshift.io"
+	// privilegedSCCRole is the ClusterRole OpenShift provides to use the privileged SecurityContextConstraint
+	privilegedSCCRole = "system:openshift:scc:privileged"
+
+	// TracerServiceAccount is the ServiceAccount kstrace creates for tracer Pods on OpenShift
+	TracerServiceAccount = "kstrace-tracer"
+)
+
+// podSecurityLabels allow privileged Pods in a namespace under Pod Security admission
+func podSecurityLabels() map[string]string {
+	return map[string]string{
+		podSecurityEnforceLabel: podSecurityPrivileged,
+		podSecurityAuditLabel:   podSecurityPrivileged,
+		podSecurityWarnLabel:    podSecurityPrivileged,
+	}
+}
+
+// IsOpenShift reports whether the cluster admits Pods with SecurityContextConstraints
+func IsOpenShift(client kubernetes.Interface) (bool, error) {
+	groups, err := client.Discovery().ServerGroups()
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups.Groups {
+		if group.Name == openShiftSecurityGroup {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GrantPrivilegedSCC creates a ServiceAccount for the tracer Pods of a run and allows it to use the privileged
+// SecurityContextConstraint. The returned function removes both again. Both are labelled and annotated like the
+// tracer Pods so that FindLeftovers can find them if the run is killed.
+func GrantPrivilegedSCC(ctx context.Context, client kubernetes.Interface, namespace string, runID string, createdBy string) (string, func() error, error) {
+	name := TracerServiceAccount
+	labels := map[string]string{"app": "kstrace"}
+	if runID != "" {
+		name = TracerServiceAccount + "-" + runID
+		labels[RunLabel] = runID
+	}
+	objectMetadata := metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}
+	if createdBy != "" {
+		objectMetadata.Annotations = map[string]string{CreatedByAnnotation: createdBy}
+	}
+
+	revoke := func() error {
+		bindingErr := retryCleanup(fmt.Sprintf("rolebinding %q", name), func(ctx context.Context) error {
+			return client.RbacV1().RoleBindings(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		})
+		accountErr := retryCleanup(fmt.Sprintf("serviceaccount %q", name), func(ctx context.Context) error {
+			return client.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		})
+		if bindingErr != nil || accountErr != nil {
+			return fmt.Errorf("unable to delete the tracer serviceaccount and rolebinding %q from namespace %q. manual deletion is required", name, namespace)
+		}
+		return nil
+	}
+
+	_, err := client.CoreV1().ServiceAccounts(namespace).Create(ctx, &corev1.ServiceAccount{ObjectMeta: objectMetadata}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", nil, fmt.Errorf("unable to create serviceaccount %q in namespace %q. %v", name, namespace, err)
+	}
+	_, err = client.RbacV1().RoleBindings(namespace).Create(ctx, &rbacv1.RoleBinding{
+		ObjectMeta: objectMetadata,
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: privilegedSCCRole},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace}},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		if revokeErr := revoke(); revokeErr != nil {
+			log.Errorf("%v", revokeErr)
+		}
+		return "", nil, fmt.Errorf("unable to bind the privileged SecurityContextConstraint to serviceaccount %q. %v", name, err)
+	}
+
+	log.Infof("Granted the privileged SecurityContextConstraint to serviceaccount %q in namespace %q", name, namespace)
+	return name, revoke, nil
+}
+
+// describeCreateError explains which admission policy rejected a tracer Pod
+func describeCreateError(ctx context.Context, client kubernetes.Interface, err error, namespace string) error {
+	if !apierrors.IsForbidden(err) {
+		return err
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "PodSecurity"):
+		level := "unknown"
+		if ns, nsErr := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{}); nsErr == nil {
+			if enforced, ok := ns.Labels[podSecurityEnforceLabel]; ok {
+				level = fmt.Sprintf("%q", enforced)
+			} else {
+				level = "the cluster default"
+			}
+		}
+		return fmt.Errorf("the tracer pod was rejected by Pod Security admission. namespace %q enforces level %s and must be labelled %s=%s to allow privileged pods. %v",
+			namespace, level, podSecurityEnforceLabel, podSecurityPrivileged, err)
+	case strings.Contains(message, "security context constraint"):
+		return fmt.Errorf("the tracer pod was rejected by OpenShift SecurityContextConstraints. its serviceaccount must be allowed to use the privileged SCC, e.g. through the %q ClusterRole. %v", privilegedSCCRole, err)
+	case strings.Contains(message, "PodSecurityPolicy"):
+		return fmt.Errorf("the tracer pod was rejected by a PodSecurityPolicy. no policy available to the tracer pod allows privileged pods. %v", err)
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package kstrace
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Sink stores the strace output streamed back from a tracer
+type Sink interface {
+	// Create opens a writer for the named file relative to the collection. The returned name includes
+	// any extension added by the Sink.
+	Create(name string) (io.WriteCloser, string, error)
+}
+
+// FileSink writes each stream to a plain file within the collection directory
+type FileSink struct {
+	Directory string
+}
+
+func (sink *FileSink) Create(name string) (io.WriteCloser, string, error) {
+	path := filepath.Join(sink.Directory, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return nil, "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, name, nil
+}
+
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "tar.gz"
+	CompressionZstd Compression = "zstd"
+)
+
+var Compressions = []Compression{CompressionGzip, CompressionZstd}
+
+func ParseCompression(value string) (Compression, error) {
+	for _, compression := range append(Compressions, CompressionNone) {
+		if value == string(compression) {
+			return compression, nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("unsupported archive format %q. available options are %v", value, Compressions)
+}
+
+// Extension is the file extension for a single compressed stream
+func (compression Compression) Extension() string {
+	switch compression {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// NewWriter wraps the writer in a compressor. Closing the returned writer does not close the wrapped writer.
+func (compression Compression) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(writer), nil
+	case CompressionZstd:
+		return zstd.NewWriter(writer)
+	}
+	return nopWriteCloser{writer}, nil
+}
+
+// CompressedSink compresses each stream as it is written
+type CompressedSink struct {
+	Sink        Sink
+	Compression Compression
+}
+
+func (sink *CompressedSink) Create(name string) (io.WriteCloser, string, error) {
+	writer, storedName, err := sink.Sink.Create(name + sink.Compression.Extension())
+	if err != nil {
+		return nil, "", err
+	}
+
+	compressor, err := sink.Compression.NewWriter(writer)
+	if err != nil {
+		writer.Close()
+		return nil, "", err
+	}
+	return &stackedWriteCloser{WriteCloser: compressor, inner: writer}, storedName, nil
+}
+
+// stackedWriteCloser closes the wrapping writer before the writer it wraps
+type stackedWriteCloser struct {
+	io.WriteCloser
+	inner io.Closer
+}
+
+func (writer *stackedWriteCloser) Close() error {
+	err := writer.WriteCloser.Close()
+	if innerErr := writer.inner.Close(); err == nil {
+		err = innerErr
+	}
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// syncWriter serialises writes from the concurrent stdout and stderr exec streams
+type syncWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (writer *syncWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	return writer.writer.Write(data)
+}
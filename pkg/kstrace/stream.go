@@ -0,0 +1,60 @@
+package kstrace
+
+import (
+	"bytes"
+	"sync"
+)
+
+// TraceTarget identifies a single container being traced
+type TraceTarget struct {
+	Namespace string
+	Pod       string
+	PodUID    string
+	Container string
+	Node      string
+	PID       int64
+}
+
+// LineObserver receives each complete line of strace output as it is streamed back from the cluster.
+// Lines from every tracer are delivered concurrently so implementations must be safe for concurrent use.
+type LineObserver interface {
+	ObserveLine(target TraceTarget, line string)
+}
+
+// lineWriter buffers partial writes from an exec stream and passes on complete lines
+type lineWriter struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+	onLine func(line string)
+}
+
+func newLineWriter(onLine func(line string)) *lineWriter {
+	return &lineWriter{onLine: onLine}
+}
+
+func (writer *lineWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.buffer.Write(data)
+	for {
+		index := bytes.IndexByte(writer.buffer.Bytes(), '\n')
+		if index < 0 {
+			break
+		}
+		line := string(writer.buffer.Next(index + 1))
+		writer.onLine(line[:len(line)-1])
+	}
+	return len(data), nil
+}
+
+// Flush passes on any trailing data that was not terminated by a newline
+func (writer *lineWriter) Flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.buffer.Len() > 0 {
+		writer.onLine(writer.buffer.String())
+		writer.buffer.Reset()
+	}
+}
@@ -2,11 +2,17 @@ package kstrace
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
@@ -30,8 +36,11 @@ type ExecRequest struct {
 	TTY        bool
 }
 
-func ExecCommand(reqOptions ExecRequest) (int, error) {
+func ExecCommand(ctx context.Context, reqOptions ExecRequest) (int, error) {
 	exitCode := 0
+	if err := ctx.Err(); err != nil {
+		return exitCode, err
+	}
 	cmd := []string{
 		"sh",
 		"-c",
@@ -52,12 +61,27 @@ func ExecCommand(reqOptions ExecRequest) (int, error) {
 		return exitCode, err
 	}
 
-	// This is a blocking statement - Manage the bidi stream in Goroutine
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  reqOptions.IOStreams.In,
-		Stdout: reqOptions.IOStreams.Out,
-		Stderr: reqOptions.IOStreams.ErrOut,
-	})
+	// The stream cannot be cancelled, so it is abandoned when the context is done. Its output is discarded
+	// from then on as the caller may have closed the writers.
+	stdout, stderr := newGuardedWriter(reqOptions.IOStreams.Out), newGuardedWriter(reqOptions.IOStreams.ErrOut)
+	streamErr := make(chan error, 1)
+	go func() {
+		// This is a blocking statement - Manage the bidi stream in Goroutine
+		streamErr <- exec.Stream(remotecommand.StreamOptions{
+			Stdin:  reqOptions.IOStreams.In,
+			Stdout: stdout.orNil(),
+			Stderr: stderr.orNil(),
+		})
+	}()
+
+	select {
+	case err = <-streamErr:
+	case <-ctx.Done():
+		stdout.close()
+		stderr.close()
+		log.Debugf("Abandoning command %q. %v", reqOptions.Command, ctx.Err())
+		return exitCode, ctx.Err()
+	}
 
 	if err != nil {
 		if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.Exited() {
@@ -70,15 +94,108 @@ func ExecCommand(reqOptions ExecRequest) (int, error) {
 	return exitCode, nil
 }
 
-func CreateNamespace(ctx context.Context, clientset kubernetes.Interface) (*corev1.Namespace, error) {
-	ns, err := clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+// guardedWriter stops passing writes through once it is closed
+type guardedWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	closed bool
+}
+
+func newGuardedWriter(writer io.Writer) *guardedWriter {
+	if writer == nil {
+		return nil
+	}
+	return &guardedWriter{writer: writer}
+}
+
+func (writer *guardedWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return writer.writer.Write(data)
+}
+
+func (writer *guardedWriter) close() {
+	if writer == nil {
+		return
+	}
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.closed = true
+}
+
+// orNil keeps a missing stream as a nil interface so it is not requested from the exec
+func (writer *guardedWriter) orNil() io.Writer {
+	if writer == nil {
+		return nil
+	}
+	return writer
+}
+
+// cleanupBackoff bounds the attempts made to delete a kstrace resource
+var cleanupBackoff = wait.Backoff{Steps: 4, Duration: time.Second, Factor: 2}
+
+// cleanupTimeout bounds each attempt to delete a kstrace resource
+const cleanupTimeout = 15 * time.Second
+
+// retryCleanup calls remove until it succeeds, the resource is already gone or the attempts run out.
+// Cleanup runs after the run has been cancelled, so each attempt has its own timeout instead of sharing a context.
+func retryCleanup(description string, remove func(ctx context.Context) error) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(cleanupBackoff, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+
+		lastErr = remove(ctx)
+		if lastErr == nil || apierrors.IsNotFound(lastErr) {
+			return true, nil
+		}
+		log.Debugf("Unable to delete %s, retrying. %v", description, lastErr)
+		return false, nil
+	})
+	if err != nil {
+		return lastErr
+	}
+	return nil
+}
+
+// NamespaceLabel marks the namespaces created by kstrace
+const NamespaceLabel = "kstrace-generated-namespace"
+
+// CreatedByAnnotation records the user that created a kstrace namespace, tracer Pod or tracer ServiceAccount
+const CreatedByAnnotation = "kstrace-created-by"
+
+// CreateNamespace creates a temporary namespace for the tracer Pods. It is labelled to allow privileged Pods
+// under Pod Security admission, unless a policy forbids the labels.
+func CreateNamespace(ctx context.Context, clientset kubernetes.Interface, createdBy string) (*corev1.Namespace, error) {
+	namespace := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "kstrace-",
 			Labels: map[string]string{
-				"kstrace-generated-namespace": "",
+				NamespaceLabel: "",
+			},
+			Annotations: map[string]string{
+				CreatedByAnnotation: createdBy,
 			},
 		},
-	}, metav1.CreateOptions{})
+	}
+	for key, value := range podSecurityLabels() {
+		namespace.Labels[key] = value
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+	if apierrors.IsForbidden(err) || apierrors.IsInvalid(err) {
+		labelErr := err
+		for key := range podSecurityLabels() {
+			delete(namespace.Labels, key)
+		}
+		ns, err = clientset.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+		if err == nil {
+			log.Warnf("Unable to allow privileged pods in the tracer namespace, so Pod Security admission may reject them. %v", labelErr)
+		}
+	}
 
 	if err == nil {
 		log.Infof("Namespace %q Created", ns.Name)
@@ -88,10 +205,51 @@ func CreateNamespace(ctx context.Context, clientset kubernetes.Interface) (*core
 
 }
 
-func CleanupNamespace(ctx context.Context, client kubernetes.Interface, namespace string) {
-	// Delete Namespace
-	err := client.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+// CleanupNamespace deletes a namespace created by CreateNamespace, retrying a bounded number of times
+func CleanupNamespace(client kubernetes.Interface, namespace string) error {
+	err := retryCleanup(fmt.Sprintf("namespace %q", namespace), func(ctx context.Context) error {
+		return client.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("unable to delete strace namespace %q. manual deletion is required. %v", namespace, err)
+	}
+	log.Infof("Namespace %q Deleted", namespace)
+	return nil
+}
+
+// RunLabel holds the ID of the run that created a tracer Pod
+const RunLabel = "kstrace-run"
+
+// CleanupTracerPods removes the tracer Pods created by a run from a namespace that kstrace does not own,
+// leaving every other Pod in place. Pods are deleted one at a time as restricted roles rarely allow deletecollection.
+func CleanupTracerPods(client kubernetes.Interface, namespace string, runID string) error {
+	selector := "app=kstrace," + RunLabel + "=" + runID
+	var pods *corev1.PodList
+	err := retryCleanup(fmt.Sprintf("the pods labelled %q", selector), func(ctx context.Context) error {
+		var err error
+		pods, err = client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		return err
+	})
 	if err != nil {
-		log.Fatalf("unable to delete strace namespace %q. manual deletion is required.", namespace)
+		return fmt.Errorf("unable to list strace pods in namespace %q. manual deletion of the pods labelled %q is required. %v", namespace, selector, err)
+	}
+
+	failed := []string{}
+	for _, pod := range pods.Items {
+		if err := deletePod(client, namespace, pod.Name); err != nil {
+			log.Debugf("%v", err)
+			failed = append(failed, pod.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to delete strace pods %v from namespace %q. manual deletion is required", failed, namespace)
 	}
+	return nil
+}
+
+// deletePod deletes a tracer Pod, retrying a bounded number of times
+func deletePod(client kubernetes.Interface, namespace string, name string) error {
+	return retryCleanup(fmt.Sprintf("pod %q", name), func(ctx context.Context) error {
+		return client.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
 }
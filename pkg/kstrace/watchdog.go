@@ -0,0 +1,108 @@
+package kstrace
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// Tracer Pods stop themselves when kstrace goes away, so a killed or disconnected client never leaves a
+// privileged Pod running. kstrace renews a heartbeat annotation on each tracer Pod, which the kubelet projects
+// into the container through the downward API. The container exits once the heartbeat stops changing.
+
+// HeartbeatAnnotation holds the time kstrace last renewed a tracer Pod
+const HeartbeatAnnotation = "kstrace-heartbeat"
+
+const (
+	heartbeatMountPath = "/etc/kstrace"
+	heartbeatFile      = "heartbeat"
+
+	// heartbeatTimeout allows for the delay before the kubelet updates the projected annotation
+	heartbeatTimeout = 5 * time.Minute
+
+	// heartbeatInterval is how often kstrace renews the heartbeat of each tracer Pod
+	heartbeatInterval = 30 * time.Second
+
+	// deadlineMargin is added to the start and trace timeouts to allow for the traces to stop
+	deadlineMargin = 5 * time.Minute
+)
+
+// watchdogCommand keeps the tracer container alive while the heartbeat keeps changing. Changes are timed with the
+// node's clock so the client's clock does not need to match.
+func watchdogCommand() string {
+	return fmt.Sprintf(`last=""; seen=$(date +%%s); `+
+		`while true; do `+
+		`beat=$(cat %s/%s 2>/dev/null); now=$(date +%%s); `+
+		`if [ "$beat" != "$last" ]; then last="$beat"; seen=$now; fi; `+
+		`if [ $((now - seen)) -gt %d ]; then echo "kstrace heartbeat lost, exiting"; exit 1; fi; `+
+		`sleep 10; `+
+		`done`, heartbeatMountPath, heartbeatFile, int64(heartbeatTimeout.Seconds()))
+}
+
+// heartbeatVolume projects the heartbeat annotation into the tracer container
+func heartbeatVolume() (corev1.Volume, corev1.VolumeMount) {
+	volume := corev1.Volume{
+		Name: "heartbeat",
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{{
+					Path:     heartbeatFile,
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.annotations['%s']", HeartbeatAnnotation)},
+				}},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: "heartbeat", ReadOnly: true, MountPath: heartbeatMountPath}
+	return volume, mount
+}
+
+// activeDeadline bounds the life of a tracer Pod when the trace has a timeout. The deadline counts from when the
+// Pod is scheduled, so it includes the time the Pod has to start.
+func activeDeadline(traceTimeout time.Duration, startTimeout time.Duration) *int64 {
+	if traceTimeout <= 0 {
+		return nil
+	}
+	if startTimeout <= 0 {
+		startTimeout = DefaultStartTimeout
+	}
+	seconds := int64((startTimeout + traceTimeout + deadlineMargin).Seconds())
+	return &seconds
+}
+
+func heartbeatValue() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// renewHeartbeat updates the heartbeat of a tracer Pod
+func renewHeartbeat(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, HeartbeatAnnotation, heartbeatValue())
+	_, err := client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// keepAlive renews the heartbeat of a tracer Pod every interval until stop is closed
+func keepAlive(client kubernetes.Interface, pod *corev1.Pod, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := renewHeartbeat(ctx, client, pod); err != nil {
+				log.Warnf("Unable to renew the heartbeat of tracer pod %q. It stops itself once the heartbeat is %v old. %v", pod.Name, heartbeatTimeout, err)
+			}
+			cancel()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+const tracesPath = "/v1/traces"
+
+// Config controls which system calls are exported and where they are sent
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector URL. The standard `/v1/traces` path is used when no path is provided
+	Endpoint string
+	Headers  map[string]string
+
+	// System calls that take at least LatencyThreshold are exported. A zero value disables latency based export
+	LatencyThreshold time.Duration
+	// ExportErrors exports every system call that returned an error, regardless of latency
+	ExportErrors bool
+
+	BatchSize     int
+	FlushInterval time.Duration
+	Client        *http.Client
+	// Backoff controls how often a batch is retried when the collector is unavailable or throttling
+	Backoff wait.Backoff
+}
+
+// Exporter converts slow or failing system calls into spans and sends them to an OTLP/HTTP collector
+type Exporter struct {
+	config   Config
+	endpoint string
+
+	mutex   sync.Mutex
+	parsers map[kstrace.TraceTarget]*strace.Parser
+	pending map[kstrace.TraceTarget][]span
+	count   int
+	// dropped counts the spans that could not be exported
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewExporter(config Config) (*Exporter, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("the otlp endpoint %q must be an http or https url", config.Endpoint)
+	}
+	if strings.Trim(endpoint.Path, "/") == "" {
+		endpoint.Path = tracesPath
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.Backoff.Steps <= 0 {
+		config.Backoff = wait.Backoff{Steps: 4, Duration: 500 * time.Millisecond, Factor: 2}
+	}
+
+	exporter := &Exporter{
+		config:   config,
+		endpoint: endpoint.String(),
+		parsers:  map[kstrace.TraceTarget]*strace.Parser{},
+		pending:  map[kstrace.TraceTarget][]span{},
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go exporter.run()
+
+	return exporter, nil
+}
+
+// ObserveLine parses a line of strace output and exports it if it completes a slow or failing system call
+func (exporter *Exporter) ObserveLine(target kstrace.TraceTarget, line string) {
+	exporter.mutex.Lock()
+	parser, ok := exporter.parsers[target]
+	if !ok {
+		parser = strace.NewParser(time.Now())
+		exporter.parsers[target] = parser
+	}
+	event := parser.Parse(line)
+	exporter.mutex.Unlock()
+
+	if event != nil {
+		exporter.Export(target, event)
+	}
+}
+
+// Export queues a span for the event if it is slower than the configured threshold or has failed
+func (exporter *Exporter) Export(target kstrace.TraceTarget, event *strace.Event) {
+	if !exporter.shouldExport(event) {
+		return
+	}
+
+	exporter.mutex.Lock()
+	exporter.pending[target] = append(exporter.pending[target], newSpan(target, event))
+	exporter.count++
+	full := exporter.count >= exporter.config.BatchSize
+	exporter.mutex.Unlock()
+
+	if full {
+		select {
+		case exporter.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (exporter *Exporter) shouldExport(event *strace.Event) bool {
+	if exporter.config.ExportErrors && event.Failed() {
+		return true
+	}
+	return exporter.config.LatencyThreshold > 0 && event.Duration >= exporter.config.LatencyThreshold
+}
+
+func (exporter *Exporter) run() {
+	defer close(exporter.done)
+
+	ticker := time.NewTicker(exporter.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-exporter.flush:
+		case <-exporter.stop:
+			return
+		}
+
+		if err := exporter.Flush(context.Background()); err != nil {
+			log.Warnf("unable to export spans to %q. %v", exporter.endpoint, err)
+		}
+	}
+}
+
+// Flush sends all queued spans to the collector, retrying while it is unavailable. Spans that cannot be sent
+// are dropped and counted.
+func (exporter *Exporter) Flush(ctx context.Context) error {
+	exporter.mutex.Lock()
+	pending := exporter.pending
+	exporter.pending = map[kstrace.TraceTarget][]span{}
+	exporter.count = 0
+	exporter.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	spans := 0
+	for _, targetSpans := range pending {
+		spans += len(targetSpans)
+	}
+
+	body, err := encodeRequest(pending)
+	if err == nil {
+		err = exporter.send(ctx, body)
+	}
+	if err != nil {
+		exporter.mutex.Lock()
+		exporter.dropped += spans
+		exporter.mutex.Unlock()
+		return fmt.Errorf("%d spans were dropped. %v", spans, err)
+	}
+	log.Debugf("Exported spans for %d containers to %q", len(pending), exporter.endpoint)
+	return nil
+}
+
+// send posts the encoded spans, retrying connection failures and responses that the collector may accept later
+func (exporter *Exporter) send(ctx context.Context, body []byte) error {
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, exporter.config.Backoff, func() (bool, error) {
+		retry, err := exporter.post(ctx, body)
+		lastErr = err
+		if err == nil {
+			return true, nil
+		}
+		if !retry {
+			return false, err
+		}
+		log.Debugf("Unable to export spans to %q, retrying. %v", exporter.endpoint, err)
+		return false, nil
+	})
+	if err != nil && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
+// post makes a single export request and reports whether a failure is worth retrying
+func (exporter *Exporter) post(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, exporter.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range exporter.config.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := exporter.config.Client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return retryableStatus(response.StatusCode), fmt.Errorf("the otlp collector responded with %q", response.Status)
+	}
+	return false, nil
+}
+
+// retryableStatus follows the OTLP/HTTP specification of responses that may succeed when retried
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Shutdown stops the background export and sends any remaining spans
+func (exporter *Exporter) Shutdown(ctx context.Context) error {
+	close(exporter.stop)
+	<-exporter.done
+
+	err := exporter.Flush(ctx)
+
+	exporter.mutex.Lock()
+	dropped := exporter.dropped
+	exporter.mutex.Unlock()
+	if dropped > 0 {
+		return fmt.Errorf("%d spans could not be exported to %q", dropped, exporter.endpoint)
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+)
+
+// receiver is a stand-in for an OTLP/HTTP collector that records the requests it is sent
+type receiver struct {
+	mutex    sync.Mutex
+	paths    []string
+	headers  []http.Header
+	requests []exportTraceServiceRequest
+}
+
+func (recv *receiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	decoded := exportTraceServiceRequest{}
+	if err := json.NewDecoder(request.Body).Decode(&decoded); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	recv.mutex.Lock()
+	defer recv.mutex.Unlock()
+	recv.paths = append(recv.paths, request.URL.Path)
+	recv.headers = append(recv.headers, request.Header)
+	recv.requests = append(recv.requests, decoded)
+}
+
+func (recv *receiver) spans() []span {
+	recv.mutex.Lock()
+	defer recv.mutex.Unlock()
+
+	spans := []span{}
+	for _, request := range recv.requests {
+		for _, resourceSpan := range request.ResourceSpans {
+			for _, scopeSpan := range resourceSpan.ScopeSpans {
+				spans = append(spans, scopeSpan.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestExporter(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	exporter, err := NewExporter(Config{
+		Endpoint:         server.URL,
+		Headers:          map[string]string{"Authorization": "Bearer token"},
+		LatencyThreshold: 100 * time.Millisecond,
+		ExportErrors:     true,
+		FlushInterval:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Unable to create the exporter. %v", err)
+	}
+
+	target := kstrace.TraceTarget{Namespace: "default", Pod: "target-pod", Container: "app", Node: "node-1", PID: 42}
+	lines := []string{
+		`12:00:00.000000 openat(AT_FDCWD, "/etc/hosts", O_RDONLY) = 3 <0.000010>`,
+		`12:00:00.100000 openat(AT_FDCWD, "/missing", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000010>`,
+		`12:00:00.200000 epoll_wait(4, [], 128, 500) = 0 <0.500000>`,
+		`12:00:01.000000 --- SIGCHLD {si_signo=SIGCHLD} ---`,
+	}
+	for _, line := range lines {
+		exporter.ObserveLine(target, line)
+	}
+
+	if err := exporter.Shutdown(context.TODO()); err != nil {
+		t.Fatalf("Unable to flush the exporter. %v", err)
+	}
+
+	spans := recv.spans()
+	if len(spans) != 2 {
+		t.Fatalf("Span count mismatch. Expected %d, Got: %d", 2, len(spans))
+	}
+	if spans[0].Name != "openat" || spans[0].Status.Code != statusCodeError {
+		t.Errorf("Expected a failed openat span, Got: %+v", spans[0])
+	}
+	if spans[1].Name != "epoll_wait" || spans[1].Status.Code != 0 {
+		t.Errorf("Expected a successful epoll_wait span, Got: %+v", spans[1])
+	}
+	if recv.paths[0] != tracesPath {
+		t.Errorf("Path mismatch. Expected %q, Got: %q", tracesPath, recv.paths[0])
+	}
+	if authorization := recv.headers[0].Get("Authorization"); authorization != "Bearer token" {
+		t.Errorf("Header mismatch. Expected %q, Got: %q", "Bearer token", authorization)
+	}
+
+	attributes := map[string]string{}
+	for _, attribute := range recv.requests[0].ResourceSpans[0].Resource.Attributes {
+		if attribute.Value.StringValue != nil {
+			attributes[attribute.Key] = *attribute.Value.StringValue
+		}
+	}
+	if attributes["k8s.pod.name"] != target.Pod || attributes["k8s.container.name"] != target.Container {
+		t.Errorf("Resource attributes mismatch. Got: %v", attributes)
+	}
+}
+
+func TestExporterRetries(t *testing.T) {
+	target := kstrace.TraceTarget{Namespace: "default", Pod: "target-pod", Container: "app", Node: "node-1", PID: 42}
+	line := `12:00:00.000000 openat(AT_FDCWD, "/missing", O_RDONLY) = -1 ENOENT (No such file or directory) <0.000010>`
+
+	tests := []struct {
+		name     string
+		statuses []int
+		expected int
+		attempts int
+		dropped  bool
+	}{
+		{name: "unavailable then accepted", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, expected: 1, attempts: 3},
+		{name: "always unavailable", statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, attempts: 3, dropped: true},
+		{name: "rejected", statuses: []int{http.StatusBadRequest}, attempts: 1, dropped: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The collector fails with each status in turn before accepting the spans
+			recv := &receiver{}
+			var mutex sync.Mutex
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				mutex.Lock()
+				attempt := attempts
+				attempts++
+				mutex.Unlock()
+				if attempt < len(test.statuses) {
+					writer.WriteHeader(test.statuses[attempt])
+					return
+				}
+				recv.ServeHTTP(writer, request)
+			}))
+			defer server.Close()
+
+			exporter, err := NewExporter(Config{
+				Endpoint:      server.URL,
+				ExportErrors:  true,
+				FlushInterval: time.Hour,
+				Backoff:       wait.Backoff{Steps: 3, Duration: time.Millisecond, Factor: 2},
+			})
+			if err != nil {
+				t.Fatalf("Unable to create the exporter. %v", err)
+			}
+			exporter.ObserveLine(target, line)
+
+			err = exporter.Shutdown(context.TODO())
+			if test.dropped && (err == nil || !strings.Contains(err.Error(), "1 spans could not be exported")) {
+				t.Errorf("Expected the dropped span to be reported. Got %v", err)
+			}
+			if !test.dropped && err != nil {
+				t.Errorf("Unable to export the spans. %v", err)
+			}
+			if len(recv.spans()) != test.expected {
+				t.Errorf("Span count mismatch. Expected %d, Got: %d", test.expected, len(recv.spans()))
+			}
+			if attempts != test.attempts {
+				t.Errorf("Attempt count mismatch. Expected %d, Got: %d", test.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestExporterTimezone(t *testing.T) {
+	// The tracer container prints the time of day in UTC while kstrace runs ten hours ahead
+	local := time.Local
+	time.Local = time.FixedZone("AEST", 10*60*60)
+	defer func() { time.Local = local }()
+
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	exporter, err := NewExporter(Config{Endpoint: server.URL, ExportErrors: true, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Unable to create the exporter. %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	target := kstrace.TraceTarget{Namespace: "default", Pod: "target-pod", Container: "app"}
+	exporter.ObserveLine(target, now.Format("15:04:05.000000")+` close(9) = -1 EBADF (Bad file descriptor) <0.000010>`)
+	if err := exporter.Shutdown(context.TODO()); err != nil {
+		t.Fatalf("Unable to flush the exporter. %v", err)
+	}
+
+	spans := recv.spans()
+	if len(spans) != 1 {
+		t.Fatalf("Span count mismatch. Expected %d, Got: %d", 1, len(spans))
+	}
+	if expected := strconv.FormatInt(now.UnixNano(), 10); spans[0].StartTimeUnixNano != expected {
+		t.Errorf("Start time mismatch. Expected %s, Got: %s", expected, spans[0].StartTimeUnixNano)
+	}
+}
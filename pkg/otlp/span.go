@@ -0,0 +1,139 @@
+package otlp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/michaelwasher/kube-strace/pkg/kstrace"
+	"github.com/michaelwasher/kube-strace/pkg/strace"
+)
+
+// The types below follow the JSON encoding of the OTLP trace protocol
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+	scopeName        = "kstrace"
+)
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type exportTraceServiceRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+func stringAttribute(key string, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int64) keyValue {
+	encoded := strconv.FormatInt(value, 10)
+	return keyValue{Key: key, Value: anyValue{IntValue: &encoded}}
+}
+
+func randomID(length int) string {
+	id := make([]byte, length)
+	// A failed read leaves a zeroed ID which collectors reject, so there is nothing useful to recover
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func newSpan(target kstrace.TraceTarget, event *strace.Event) span {
+	start := event.Time
+	if start.IsZero() {
+		start = time.Now().Add(-event.Duration)
+	}
+	end := start.Add(event.Duration)
+
+	exported := span{
+		TraceID:           randomID(16),
+		SpanID:            randomID(8),
+		Name:              event.Syscall,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes: []keyValue{
+			stringAttribute("syscall.name", event.Syscall),
+			stringAttribute("syscall.args", event.Args),
+			stringAttribute("syscall.return", event.Return),
+			intAttribute("thread.id", event.PID),
+		},
+	}
+
+	if event.Failed() {
+		exported.Attributes = append(exported.Attributes, stringAttribute("syscall.errno", event.Errno))
+		exported.Status = status{Code: statusCodeError, Message: event.Errno + " " + event.ErrorText}
+	}
+	return exported
+}
+
+func resourceAttributes(target kstrace.TraceTarget) []keyValue {
+	return []keyValue{
+		stringAttribute("service.name", scopeName),
+		stringAttribute("k8s.namespace.name", target.Namespace),
+		stringAttribute("k8s.pod.name", target.Pod),
+		stringAttribute("k8s.pod.uid", target.PodUID),
+		stringAttribute("k8s.container.name", target.Container),
+		stringAttribute("k8s.node.name", target.Node),
+		intAttribute("process.pid", target.PID),
+	}
+}
+
+func encodeRequest(pending map[kstrace.TraceTarget][]span) ([]byte, error) {
+	request := exportTraceServiceRequest{}
+
+	for target, spans := range pending {
+		request.ResourceSpans = append(request.ResourceSpans, resourceSpans{
+			Resource:   resource{Attributes: resourceAttributes(target)},
+			ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: spans}},
+		})
+	}
+
+	return json.Marshal(request)
+}
@@ -0,0 +1,195 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Names of the built-in detectors
+const (
+	DetectorBearerToken  = "bearer-token"
+	DetectorAWSAccessKey = "aws-access-key"
+	DetectorAWSSecretKey = "aws-secret-key"
+	DetectorJWT          = "jwt"
+	DetectorSecret       = "secret"
+	DetectorCustom       = "custom"
+)
+
+// Values shorter than this are not redacted as they would match unrelated data
+const minValueLength = 4
+
+// Rule redacts every match of a pattern. When the pattern has capture groups only the first group is
+// replaced so the surrounding context, such as a header name, is kept.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+var builtinRules = []Rule{
+	{Name: DetectorBearerToken, Pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`)},
+	{Name: DetectorAWSAccessKey, Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA)[A-Z0-9]{16}\b`)},
+	{Name: DetectorAWSSecretKey, Pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key(?:\\"|["'\s:=])*([A-Za-z0-9/+]{40})`)},
+	// The signature is optional as strace may truncate the token
+	{Name: DetectorJWT, Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]*(?:\.[A-Za-z0-9_-]*)?`)},
+}
+
+// Matches a string argument that strace truncated, e.g. "abc"...
+var truncatedString = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"\.\.\.`)
+
+// Redactor removes secrets from lines of strace output. A nil Redactor leaves lines unchanged.
+type Redactor struct {
+	rules []Rule
+	// values are literal secrets escaped as strace prints them
+	values []string
+}
+
+// New creates a Redactor with the built-in detectors and the given regular expressions
+func New(patterns []string) (*Redactor, error) {
+	redactor := &Redactor{rules: append([]Rule{}, builtinRules...)}
+	for _, pattern := range patterns {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q. %v", pattern, err)
+		}
+		redactor.rules = append(redactor.rules, Rule{Name: DetectorCustom, Pattern: expression})
+	}
+	return redactor, nil
+}
+
+// WithValues returns a copy of the Redactor that also removes the given literal values, such as the
+// contents of Secrets
+func (redactor *Redactor) WithValues(values []string) *Redactor {
+	if redactor == nil {
+		return nil
+	}
+
+	copied := &Redactor{rules: redactor.rules, values: append([]string{}, redactor.values...)}
+	for _, value := range values {
+		if len(value) >= minValueLength {
+			copied.values = append(copied.values, Escape(value))
+		}
+	}
+	// Longer values are replaced first so a value containing another is removed whole
+	sort.Slice(copied.values, func(i, j int) bool { return len(copied.values[i]) > len(copied.values[j]) })
+	return copied
+}
+
+// Detectors lists the names of the rules applied by the Redactor
+func (redactor *Redactor) Detectors() []string {
+	if redactor == nil {
+		return nil
+	}
+
+	detectors := []string{}
+	seen := map[string]bool{}
+	for _, rule := range redactor.rules {
+		if !seen[rule.Name] {
+			seen[rule.Name] = true
+			detectors = append(detectors, rule.Name)
+		}
+	}
+	if len(redactor.values) > 0 {
+		detectors = append(detectors, DetectorSecret)
+	}
+	return detectors
+}
+
+// Redact replaces every secret found in the line with a marker naming the detector that found it
+func (redactor *Redactor) Redact(line string) string {
+	if redactor == nil {
+		return line
+	}
+
+	for _, value := range redactor.values {
+		line = strings.ReplaceAll(line, value, marker(DetectorSecret))
+	}
+	if len(redactor.values) > 0 {
+		line = redactor.redactTruncated(line)
+	}
+	for _, rule := range redactor.rules {
+		line = redactRule(line, rule)
+	}
+	return line
+}
+
+// redactTruncated removes the start of a secret from strings that strace cut short
+func (redactor *Redactor) redactTruncated(line string) string {
+	return truncatedString.ReplaceAllStringFunc(line, func(quoted string) string {
+		content := quoted[1 : len(quoted)-len(`"...`)]
+		for start := 0; len(content)-start >= minValueLength; start++ {
+			// Never split an escape sequence
+			if start > 0 && content[start-1] == '\\' {
+				continue
+			}
+			for _, value := range redactor.values {
+				if strings.HasPrefix(value, content[start:]) {
+					return `"` + content[:start] + marker(DetectorSecret) + `"...`
+				}
+			}
+		}
+		return quoted
+	})
+}
+
+func redactRule(line string, rule Rule) string {
+	matches := rule.Pattern.FindAllStringSubmatchIndex(line, -1)
+	if matches == nil {
+		return line
+	}
+
+	builder := strings.Builder{}
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if len(match) > 2 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+		builder.WriteString(line[last:start])
+		builder.WriteString(marker(rule.Name))
+		last = end
+	}
+	builder.WriteString(line[last:])
+	return builder.String()
+}
+
+func marker(detector string) string {
+	return "[REDACTED:" + detector + "]"
+}
+
+// Escape formats a value the way strace prints string arguments
+func Escape(value string) string {
+	builder := strings.Builder{}
+	for index := 0; index < len(value); index++ {
+		char := value[index]
+		switch char {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\v':
+			builder.WriteString(`\v`)
+		case '\f':
+			builder.WriteString(`\f`)
+		default:
+			if char >= ' ' && char < 0x7f {
+				builder.WriteByte(char)
+				continue
+			}
+			// Octal escapes are padded when followed by a digit so they are not ambiguous
+			if index+1 < len(value) && value[index+1] >= '0' && value[index+1] <= '7' {
+				fmt.Fprintf(&builder, `\%03o`, char)
+			} else {
+				fmt.Fprintf(&builder, `\%o`, char)
+			}
+		}
+	}
+	return builder.String()
+}
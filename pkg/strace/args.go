@@ -0,0 +1,107 @@
+package strace
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SplitArgs splits the arguments of a system call on the commas that separate them, ignoring commas
+// within strings, structures, arrays and file descriptor decorations.
+func SplitArgs(args string) []string {
+	split := []string{}
+	depth := 0
+	inString := false
+	start := 0
+
+	for index := 0; index < len(args); index++ {
+		switch character := args[index]; {
+		case inString:
+			if character == '\\' {
+				index++
+			} else if character == '"' {
+				inString = false
+			}
+		case character == '"':
+			inString = true
+		case character == '{' || character == '[' || character == '(' || character == '<':
+			depth++
+		case character == '}' || character == ']' || character == ')' || character == '>':
+			// `->` within decorations and `=>` within structures are not closing brackets
+			if index > 0 && (args[index-1] == '-' || args[index-1] == '=') {
+				continue
+			}
+			depth--
+		case character == ',' && depth == 0:
+			split = append(split, strings.TrimSpace(args[start:index]))
+			start = index + 1
+		}
+	}
+
+	if last := strings.TrimSpace(args[start:]); last != "" || len(split) > 0 {
+		split = append(split, last)
+	}
+	return split
+}
+
+// Unquote decodes a string argument as printed by strace. The second return value is false when the
+// argument is not a string, and the third is true when strace truncated the string.
+func Unquote(arg string) (string, bool, bool) {
+	if !strings.HasPrefix(arg, `"`) {
+		return "", false, false
+	}
+
+	var decoded strings.Builder
+	for index := 1; index < len(arg); index++ {
+		character := arg[index]
+		if character == '"' {
+			return decoded.String(), true, strings.HasSuffix(arg[index:], `"...`)
+		}
+		if character != '\\' || index+1 >= len(arg) {
+			decoded.WriteByte(character)
+			continue
+		}
+
+		index++
+		switch escaped := arg[index]; escaped {
+		case 'n':
+			decoded.WriteByte('\n')
+		case 't':
+			decoded.WriteByte('\t')
+		case 'r':
+			decoded.WriteByte('\r')
+		case 'v':
+			decoded.WriteByte('\v')
+		case 'f':
+			decoded.WriteByte('\f')
+		case 'x':
+			if index+2 < len(arg) {
+				if value, err := strconv.ParseUint(arg[index+1:index+3], 16, 8); err == nil {
+					decoded.WriteByte(byte(value))
+					index += 2
+					continue
+				}
+			}
+			decoded.WriteByte(escaped)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			end := index + 1
+			for end < len(arg) && end < index+3 && arg[end] >= '0' && arg[end] <= '7' {
+				end++
+			}
+			value, _ := strconv.ParseUint(arg[index:end], 8, 8)
+			decoded.WriteByte(byte(value))
+			index = end - 1
+		default:
+			decoded.WriteByte(escaped)
+		}
+	}
+	return decoded.String(), true, false
+}
+
+// Decoration returns the path or socket details that `-y` and `-yy` print after a file descriptor
+func Decoration(arg string) string {
+	start := strings.Index(arg, "<")
+	if start < 1 || !strings.HasSuffix(arg, ">") {
+		return ""
+	}
+	return arg[start+1 : len(arg)-1]
+}
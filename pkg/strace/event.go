@@ -0,0 +1,30 @@
+package strace
+
+import (
+	"time"
+)
+
+// Event is a single completed system call recovered from strace output.
+type Event struct {
+	PID     int64     `json:"pid,omitempty"`
+	Time    time.Time `json:"time"`
+	Syscall string    `json:"syscall"`
+	Args    string    `json:"args"`
+
+	// Return is the raw return value as printed by strace, including any `-y` decoration
+	Return      string `json:"return,omitempty"`
+	ReturnValue int64  `json:"returnValue"`
+	Errno       string `json:"errno,omitempty"`
+	ErrorText   string `json:"errorText,omitempty"`
+
+	// Duration is only populated when strace is run with `-T`
+	Duration time.Duration `json:"duration"`
+
+	// Stack is only populated when strace is run with `-k`, with the innermost frame first
+	Stack []string `json:"stack,omitempty"`
+}
+
+// Failed reports whether the system call returned an error.
+func (event *Event) Failed() bool {
+	return event.Errno != ""
+}
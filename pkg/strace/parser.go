@@ -0,0 +1,249 @@
+package strace
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationSuffix = regexp.MustCompile(`\s<(\d+\.\d+)>$`)
+	resumedPrefix  = regexp.MustCompile(`^<\.\.\. ([a-z0-9_]+) resumed>`)
+	errnoPattern   = regexp.MustCompile(`^E[A-Z0-9]+$`)
+)
+
+const (
+	unfinishedSuffix = "<unfinished ...>"
+	stackFramePrefix = "> "
+)
+
+type pendingCall struct {
+	time time.Time
+	line string
+}
+
+// Parser converts lines of strace output into Events. Output produced with `-f` interleaves
+// `<unfinished ...>` and `<... resumed>` lines across threads, so a Parser keeps state between lines
+// and must only be used for a single strace stream.
+//
+// Stack frames printed by `-k` follow the system call they belong to, so they are appended to the
+// previously returned Event.
+type Parser struct {
+	// Date is used for timestamps printed with `-t` or `-tt`, which only carry the time of day. The tracer
+	// container runs in UTC, so the time of day is read as UTC.
+	Date time.Time
+
+	pending   map[int64]pendingCall
+	lastTime  time.Time
+	lastEvent *Event
+}
+
+func NewParser(date time.Time) *Parser {
+	year, month, day := date.UTC().Date()
+	return &Parser{
+		Date:    time.Date(year, month, day, 0, 0, 0, 0, time.UTC),
+		pending: map[int64]pendingCall{},
+	}
+}
+
+// Parse processes a single line of strace output. A nil Event is returned for lines that do not
+// complete a system call, such as signals, exit notices and `<unfinished ...>` calls.
+func (parser *Parser) Parse(line string) *Event {
+	line = strings.TrimRight(line, "\r\n")
+
+	if frame := strings.TrimLeft(line, " "); strings.HasPrefix(frame, stackFramePrefix) {
+		if parser.lastEvent != nil {
+			parser.lastEvent.Stack = append(parser.lastEvent.Stack, strings.TrimPrefix(frame, stackFramePrefix))
+		}
+		return nil
+	}
+
+	pid, rest := splitPID(line)
+	timestamp, rest := parser.splitTimestamp(rest)
+
+	if rest == "" || strings.HasPrefix(rest, "---") || strings.HasPrefix(rest, "+++") || strings.HasPrefix(rest, "strace:") {
+		return nil
+	}
+
+	if strings.HasSuffix(rest, unfinishedSuffix) {
+		parser.pending[pid] = pendingCall{
+			time: timestamp,
+			line: strings.TrimSuffix(strings.TrimSuffix(rest, unfinishedSuffix), " "),
+		}
+		return nil
+	}
+
+	if match := resumedPrefix.FindStringSubmatch(rest); match != nil {
+		pending, ok := parser.pending[pid]
+		if !ok {
+			return nil
+		}
+		delete(parser.pending, pid)
+
+		rest = pending.line + rest[len(match[0]):]
+		timestamp = pending.time
+	}
+
+	event := parseCall(rest)
+	if event == nil {
+		return nil
+	}
+	event.PID = pid
+	event.Time = timestamp
+	parser.lastEvent = event
+	return event
+}
+
+// Read parses every line from the reader, calling fn for each completed Event. Each Event is held
+// until the next one is parsed so that it includes its stack frames.
+func (parser *Parser) Read(reader io.Reader, fn func(*Event) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var previous *Event
+	for scanner.Scan() {
+		event := parser.Parse(scanner.Text())
+		if event == nil {
+			continue
+		}
+		if previous != nil {
+			if err := fn(previous); err != nil {
+				return err
+			}
+		}
+		previous = event
+	}
+	if previous != nil {
+		if err := fn(previous); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func splitPID(line string) (int64, string) {
+	if !strings.HasPrefix(line, "[pid") {
+		return 0, line
+	}
+
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return 0, line
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(line[len("[pid"):end]), 10, 64)
+	if err != nil {
+		return 0, line
+	}
+	return pid, strings.TrimLeft(line[end+1:], " ")
+}
+
+func (parser *Parser) splitTimestamp(line string) (time.Time, string) {
+	end := strings.Index(line, " ")
+	if end < 1 {
+		return time.Time{}, line
+	}
+	token := line[:end]
+
+	if token[0] < '0' || token[0] > '9' {
+		return time.Time{}, line
+	}
+
+	var timestamp time.Time
+	if strings.Contains(token, ":") {
+		// `-t` and `-tt` print the time of day
+		clock, err := time.Parse("15:04:05.999999999", token)
+		if err != nil {
+			return time.Time{}, line
+		}
+		timestamp = parser.Date.Add(clock.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+		// Collections that span midnight continue onto the next day
+		if !parser.lastTime.IsZero() && parser.lastTime.Sub(timestamp) > 12*time.Hour {
+			parser.Date = parser.Date.AddDate(0, 0, 1)
+			timestamp = timestamp.AddDate(0, 0, 1)
+		}
+	} else {
+		// `-ttt` prints seconds since the epoch. The fraction is parsed separately as a float64 cannot hold
+		// microseconds at this magnitude
+		whole, fraction := token, ""
+		if dot := strings.IndexByte(token, '.'); dot >= 0 {
+			whole, fraction = token[:dot], token[dot+1:]
+		}
+		seconds, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return time.Time{}, line
+		}
+		if len(fraction) > 9 {
+			fraction = fraction[:9]
+		}
+		nanoseconds := int64(0)
+		if fraction != "" {
+			nanoseconds, err = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+			if err != nil {
+				return time.Time{}, line
+			}
+		}
+		timestamp = time.Unix(seconds, nanoseconds)
+	}
+
+	parser.lastTime = timestamp
+	return timestamp, line[end+1:]
+}
+
+func parseCall(line string) *Event {
+	open := strings.Index(line, "(")
+	if open < 1 {
+		return nil
+	}
+	event := &Event{Syscall: line[:open]}
+
+	if match := durationSuffix.FindStringSubmatch(line); match != nil {
+		seconds, err := strconv.ParseFloat(match[1], 64)
+		if err == nil {
+			event.Duration = time.Duration(seconds * float64(time.Second))
+		}
+		line = line[:len(line)-len(match[0])]
+	}
+
+	separator := strings.LastIndex(line, ") = ")
+	if separator < open {
+		// Calls that never return, such as exit_group, have no result
+		event.Args = strings.TrimSuffix(strings.TrimSpace(line[open+1:]), ")")
+		return event
+	}
+	event.Args = line[open+1 : separator]
+
+	result := strings.Fields(line[separator+len(") = "):])
+	if len(result) == 0 {
+		return event
+	}
+	event.Return = result[0]
+	event.ReturnValue = parseReturnValue(result[0])
+
+	if len(result) > 1 && errnoPattern.MatchString(result[1]) {
+		event.Errno = result[1]
+		event.ErrorText = strings.TrimSuffix(strings.TrimPrefix(strings.Join(result[2:], " "), "("), ")")
+	}
+	return event
+}
+
+func parseReturnValue(value string) int64 {
+	// Strip any file descriptor decoration added by `-y`
+	if index := strings.Index(value, "<"); index > 0 {
+		value = value[:index]
+	}
+
+	parsed, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		unsigned, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return 0
+		}
+		return int64(unsigned)
+	}
+	return parsed
+}
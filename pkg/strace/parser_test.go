@@ -0,0 +1,205 @@
+package strace
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	date := time.Date(2021, 11, 5, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		lines    []string
+		expected *Event
+	}{{
+		name:  "Successful call",
+		lines: []string{`12:34:56.000100 openat(AT_FDCWD, "/etc/passwd", O_RDONLY|O_CLOEXEC) = 3 <0.000021>`},
+		expected: &Event{
+			Time: date.Add(12*time.Hour + 34*time.Minute + 56*time.Second + 100*time.Microsecond), Syscall: "openat",
+			Args: `AT_FDCWD, "/etc/passwd", O_RDONLY|O_CLOEXEC`, Return: "3", ReturnValue: 3, Duration: 21 * time.Microsecond,
+		},
+	}, {
+		name:  "Failed call",
+		lines: []string{`[pid  1235] 08:00:00 stat("/missing", 0x7ffd1c2d1a30) = -1 ENOENT (No such file or directory) <0.000010>`},
+		expected: &Event{
+			PID: 1235, Time: date.Add(8 * time.Hour), Syscall: "stat", Args: `"/missing", 0x7ffd1c2d1a30`, Return: "-1", ReturnValue: -1,
+			Errno: "ENOENT", ErrorText: "No such file or directory", Duration: 10 * time.Microsecond,
+		},
+	}, {
+		name: "Resumed call",
+		lines: []string{
+			`[pid    12] 10:00:00.000000 read(3,  <unfinished ...>`,
+			`[pid    13] 10:00:00.500000 close(4) = 0`,
+			`[pid    12] 10:00:01.000000 <... read resumed>"hello", 4096) = 5 <1.000000>`,
+		},
+		expected: &Event{
+			PID: 12, Time: date.Add(10 * time.Hour), Syscall: "read", Args: `3, "hello", 4096`, Return: "5", ReturnValue: 5, Duration: time.Second,
+		},
+	}, {
+		name:  "Decorated return value",
+		lines: []string{`accept4(3<TCP:[8080]>, NULL, NULL, SOCK_CLOEXEC) = 5<TCP:[10.0.0.1:8080->10.0.0.2:40000]>`},
+		expected: &Event{
+			Syscall: "accept4", Args: "3<TCP:[8080]>, NULL, NULL, SOCK_CLOEXEC", Return: "5<TCP:[10.0.0.1:8080->10.0.0.2:40000]>", ReturnValue: 5,
+		},
+	}, {
+		name: "Stack trace",
+		lines: []string{
+			`write(1, "hi", 2) = 2 <0.000005>`,
+			` > /usr/lib/libc.so.6(__write+0x14) [0x10e1e2]`,
+			` > /app/server() [0x4a1f0]`,
+		},
+		expected: &Event{
+			Syscall: "write", Args: `1, "hi", 2`, Return: "2", ReturnValue: 2, Duration: 5 * time.Microsecond,
+			Stack: []string{"/usr/lib/libc.so.6(__write+0x14) [0x10e1e2]", "/app/server() [0x4a1f0]"},
+		},
+	}, {
+		name:     "Signal",
+		lines:    []string{`12:00:00 --- SIGCHLD {si_signo=SIGCHLD, si_code=CLD_EXITED} ---`},
+		expected: nil,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := NewParser(date)
+
+			var event *Event
+			for _, line := range tc.lines {
+				if parsed := parser.Parse(line); parsed != nil {
+					event = parsed
+				}
+			}
+
+			if tc.expected == nil || event == nil {
+				if tc.expected != event {
+					t.Fatalf("Event mismatch. Expected %v, Got: %v", tc.expected, event)
+				}
+				return
+			}
+			if !reflect.DeepEqual(event, tc.expected) {
+				t.Errorf("Event mismatch.\nExpected %+v\nGot:     %+v", *tc.expected, *event)
+			}
+		})
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	// kstrace runs ten hours ahead of the tracer container, which is on the previous day in UTC
+	local := time.Local
+	time.Local = time.FixedZone("AEST", 10*60*60)
+	defer func() { time.Local = local }()
+	date := time.Date(2021, 11, 5, 8, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		line     string
+		expected time.Time
+	}{
+		{name: "time of day", line: `22:30:00.000001 close(3) = 0`, expected: time.Date(2021, 11, 4, 22, 30, 0, 1000, time.UTC)},
+		{name: "epoch", line: `1636065000.000001 close(3) = 0`, expected: time.Date(2021, 11, 4, 22, 30, 0, 1000, time.UTC)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			event := NewParser(date).Parse(tc.line)
+			if event == nil || !event.Time.Equal(tc.expected) {
+				t.Errorf("Time mismatch. Expected %v, Got: %+v", tc.expected, event)
+			}
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		expected []string
+	}{
+		{`AT_FDCWD, "/etc/a,b", O_RDONLY`, []string{"AT_FDCWD", `"/etc/a,b"`, "O_RDONLY"}},
+		{`3<TCP:[10.0.0.1:80->10.0.0.2:4000]>, {sa_family=AF_INET, sin_port=htons(80)}, 16`, []string{"3<TCP:[10.0.0.1:80->10.0.0.2:4000]>", "{sa_family=AF_INET, sin_port=htons(80)}", "16"}},
+		{`"a\"b", [1, 2]`, []string{`"a\"b"`, "[1, 2]"}},
+		{``, []string{}},
+	}
+
+	for _, tc := range tests {
+		split := SplitArgs(tc.args)
+		if fmt.Sprint(split) != fmt.Sprint(tc.expected) || len(split) != len(tc.expected) {
+			t.Errorf("SplitArgs(%q) mismatch. Expected %q, Got: %q", tc.args, tc.expected, split)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	value, ok, truncated := Unquote(`"GET / HTTP/1.1\r\nHost: \x61\101"...`)
+	if !ok || !truncated || value != "GET / HTTP/1.1\r\nHost: aA" {
+		t.Errorf("Unquote mismatch. Got: %q, %v, %v", value, ok, truncated)
+	}
+	if _, ok, _ := Unquote("AT_FDCWD"); ok {
+		t.Errorf("Expected a non-string argument to be rejected")
+	}
+}
+
+func TestParseSockaddr(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected Sockaddr
+		ok       bool
+	}{
+		{`{sa_family=AF_INET, sin_port=htons(80), sin_addr=inet_addr("10.0.0.1")}`, Sockaddr{"AF_INET", "10.0.0.1", 80}, true},
+		{`{sa_family=AF_INET6, sin6_port=htons(443), sin6_flowinfo=htonl(0), inet_pton(AF_INET6, "::1", &sin6_addr), sin6_scope_id=0}`, Sockaddr{"AF_INET6", "::1", 443}, true},
+		{`{sa_family=AF_UNIX, sun_path="/var/run/app.sock"}`, Sockaddr{"AF_UNIX", "/var/run/app.sock", 0}, true},
+		{`{sa_family=AF_UNIX, sun_path=@"abstract"}`, Sockaddr{"AF_UNIX", "@abstract", 0}, true},
+		{`NULL`, Sockaddr{}, false},
+	}
+
+	for _, tc := range tests {
+		sockaddr, ok := ParseSockaddr(tc.arg)
+		if ok != tc.ok || sockaddr != tc.expected {
+			t.Errorf("ParseSockaddr(%q) mismatch. Expected %+v, Got: %+v", tc.arg, tc.expected, sockaddr)
+		}
+	}
+}
+
+func TestParseSocketDecoration(t *testing.T) {
+	tests := []struct {
+		decoration string
+		expected   SocketDecoration
+		ok         bool
+	}{
+		{"TCP:[10.0.0.1:8080->10.0.0.2:40000]", SocketDecoration{"TCP", "10.0.0.1:8080", "10.0.0.2:40000"}, true},
+		{"TCPv6:[[::1]:80->[::1]:5000]", SocketDecoration{"TCPv6", "[::1]:80", "[::1]:5000"}, true},
+		{"UDP:[1234567]", SocketDecoration{Protocol: "UDP"}, true},
+		{"/etc/hosts", SocketDecoration{}, false},
+	}
+
+	for _, tc := range tests {
+		socket, ok := ParseSocketDecoration(tc.decoration)
+		if ok != tc.ok || socket != tc.expected {
+			t.Errorf("ParseSocketDecoration(%q) mismatch. Expected %+v, Got: %+v", tc.decoration, tc.expected, socket)
+		}
+	}
+
+	host, port, ok := SplitHostPort("[::1]:5000")
+	if !ok || host != "::1" || port != 5000 {
+		t.Errorf("SplitHostPort mismatch. Got: %q, %d, %v", host, port, ok)
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		frame    string
+		expected Frame
+		name     string
+	}{
+		{"/usr/lib/libc.so.6(__write+0x14) [0x10e1e2]", Frame{"/usr/lib/libc.so.6", "__write", "0x14", "0x10e1e2"}, "__write"},
+		{"/app/server() [0x4a1f0]", Frame{Module: "/app/server", Address: "0x4a1f0"}, "[server]"},
+		{"unexpected_backtracing_error [0x7f00]", Frame{Symbol: "unexpected_backtracing_error"}, "unexpected_backtracing_error"},
+	}
+
+	for _, tc := range tests {
+		frame := ParseFrame(tc.frame)
+		if frame != tc.expected || frame.Name() != tc.name {
+			t.Errorf("ParseFrame(%q) mismatch. Expected %+v (%s), Got: %+v (%s)", tc.frame, tc.expected, tc.name, frame, frame.Name())
+		}
+	}
+}
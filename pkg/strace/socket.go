@@ -0,0 +1,103 @@
+package strace
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	familyPattern   = regexp.MustCompile(`sa_family=(AF_[A-Z0-9]+)`)
+	portPattern     = regexp.MustCompile(`sin6?_port=htons\((\d+)\)`)
+	inet4Pattern    = regexp.MustCompile(`sin_addr=inet_addr\("([^"]+)"\)`)
+	inet6Pattern    = regexp.MustCompile(`inet_pton\(AF_INET6, "([^"]+)"`)
+	unixPathPattern = regexp.MustCompile(`sun_path=(@?"[^"]*")`)
+)
+
+// Sockaddr is a socket address argument decoded from strace output
+type Sockaddr struct {
+	Family  string
+	Address string
+	Port    int
+}
+
+// ParseSockaddr decodes a socket address structure such as
+// {sa_family=AF_INET, sin_port=htons(80), sin_addr=inet_addr("10.0.0.1")}
+func ParseSockaddr(arg string) (Sockaddr, bool) {
+	family := familyPattern.FindStringSubmatch(arg)
+	if family == nil {
+		return Sockaddr{}, false
+	}
+	sockaddr := Sockaddr{Family: family[1]}
+
+	if port := portPattern.FindStringSubmatch(arg); port != nil {
+		sockaddr.Port, _ = strconv.Atoi(port[1])
+	}
+
+	switch sockaddr.Family {
+	case "AF_INET":
+		if address := inet4Pattern.FindStringSubmatch(arg); address != nil {
+			sockaddr.Address = address[1]
+		}
+	case "AF_INET6":
+		if address := inet6Pattern.FindStringSubmatch(arg); address != nil {
+			sockaddr.Address = address[1]
+		}
+	case "AF_UNIX":
+		if path := unixPathPattern.FindStringSubmatch(arg); path != nil {
+			abstract := strings.HasPrefix(path[1], "@")
+			sockaddr.Address, _, _ = Unquote(strings.TrimPrefix(path[1], "@"))
+			if abstract {
+				sockaddr.Address = "@" + sockaddr.Address
+			}
+		}
+	}
+	return sockaddr, true
+}
+
+// SocketDecoration is the connection detail printed by `-yy` for a socket file descriptor,
+// e.g. TCP:[10.0.0.1:8080->10.0.0.2:40000]
+type SocketDecoration struct {
+	Protocol string
+	Local    string
+	Remote   string
+}
+
+// ParseSocketDecoration decodes the decoration of a socket file descriptor
+func ParseSocketDecoration(decoration string) (SocketDecoration, bool) {
+	open := strings.Index(decoration, ":[")
+	if open < 1 || !strings.HasSuffix(decoration, "]") {
+		return SocketDecoration{}, false
+	}
+
+	socket := SocketDecoration{Protocol: decoration[:open]}
+	endpoints := decoration[open+2 : len(decoration)-1]
+	if local, remote, ok := cut(endpoints, "->"); ok {
+		socket.Local, socket.Remote = local, remote
+	} else if _, err := strconv.ParseUint(endpoints, 10, 64); err != nil {
+		// A bare number is the socket inode, printed before the socket is connected
+		socket.Local = endpoints
+	}
+	return socket, true
+}
+
+// SplitHostPort splits an endpoint from a socket decoration such as 10.0.0.1:80 or [::1]:80
+func SplitHostPort(endpoint string) (string, int, bool) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", 0, false
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, false
+	}
+	return host, portNumber, true
+}
+
+func cut(value string, separator string) (string, string, bool) {
+	if index := strings.Index(value, separator); index >= 0 {
+		return value[:index], value[index+len(separator):], true
+	}
+	return value, "", false
+}
@@ -0,0 +1,46 @@
+package strace
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Matches frames such as /usr/lib/libc.so.6(__read+0x12) [0x10e1e2]
+var framePattern = regexp.MustCompile(`^(.*)\(([^()]*)\)\s+\[(0x[0-9a-f]+)\]$`)
+
+// Frame is a single user space stack frame printed by `strace -k`
+type Frame struct {
+	Module  string
+	Symbol  string
+	Offset  string
+	Address string
+}
+
+// ParseFrame decodes a stack frame. Frames that could not be unwound, such as
+// `unexpected_backtracing_error [0x...]`, are returned with only a Symbol.
+func ParseFrame(frame string) Frame {
+	match := framePattern.FindStringSubmatch(frame)
+	if match == nil {
+		fields := strings.Fields(frame)
+		if len(fields) == 0 {
+			return Frame{}
+		}
+		return Frame{Symbol: fields[0]}
+	}
+
+	parsed := Frame{Module: match[1], Address: match[3]}
+	parsed.Symbol, parsed.Offset, _ = cut(match[2], "+")
+	return parsed
+}
+
+// Name returns the function of the frame, or the module in brackets when the symbol is unknown
+func (frame Frame) Name() string {
+	if frame.Symbol != "" {
+		return frame.Symbol
+	}
+	if frame.Module != "" {
+		return "[" + filepath.Base(frame.Module) + "]"
+	}
+	return "[unknown]"
+}